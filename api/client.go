@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+// Client talks to a node's API, it satisfies blockchain.Peer and lightclient.HeaderSource
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    http.DefaultClient,
+	}
+}
+
+func (c *Client) ID() string { return c.BaseURL }
+
+func (c *Client) Headers(fromHeight, max int) ([]blockchain.BlockHeader, error) {
+	var headers []blockchain.BlockHeader
+	err := c.get(fmt.Sprintf("/headers?from=%d&max=%d", fromHeight, max), &headers)
+	return headers, err
+}
+
+func (c *Client) Body(hash string) (*blockchain.Block, error) {
+	var block blockchain.Block
+	if err := c.get("/blocks/"+url.PathEscape(hash), &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (c *Client) RideTxProof(txID string) (blockchain.RideTxProof, error) {
+	var proof blockchain.RideTxProof
+	err := c.get("/rides/"+url.PathEscape(txID)+"/proof", &proof)
+	return proof, err
+}
+
+func (c *Client) Account(uuid string) (blockchain.Account, error) {
+	var account blockchain.Account
+	err := c.get("/accounts/"+url.PathEscape(uuid), &account)
+	return account, err
+}
+
+func (c *Client) RideTx(txID string) (blockchain.RideTx, error) {
+	var tx blockchain.RideTx
+	err := c.get("/rides/"+url.PathEscape(txID), &tx)
+	return tx, err
+}
+
+func (c *Client) PayoutBatch(batchID string) (*blockchain.PayoutBatch, error) {
+	var batch blockchain.PayoutBatch
+	err := c.get("/payouts/"+url.PathEscape(batchID), &batch)
+	return &batch, err
+}
+
+// Submit sends a signed wallet action and returns the signer's updated account
+func (c *Client) Submit(req SignedRequest) (blockchain.Account, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return blockchain.Account{}, err
+	}
+	resp, err := c.HTTP.Post(c.BaseURL+"/wallet/"+url.PathEscape(req.Action), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return blockchain.Account{}, err
+	}
+	defer resp.Body.Close()
+
+	var account blockchain.Account
+	err = decodeResponse(resp, &account)
+	return account, err
+}
+
+func (c *Client) get(path string, out interface{}) error {
+	resp, err := c.HTTP.Get(c.BaseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, out)
+}
+
+func decodeResponse(resp *http.Response, out interface{}) error {
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("request failed with status %d", resp.StatusCode)
+		}
+		return errors.New(apiErr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
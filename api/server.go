@@ -0,0 +1,460 @@
+// Package api serves a RideChain node over HTTP for peers, light clients and the CLI
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+const defaultHeaderBatch = 64
+
+// maxWebhookBytes bounds a payment callback body, Stripe events are a few KB
+const maxWebhookBytes = 1 << 20
+
+// Server exposes a RideChain over HTTP
+// handlers hold mu while they use the RideChain because it is not safe for concurrent use
+type Server struct {
+	mu  sync.Mutex
+	rc  *blockchain.RideChain
+	mux *http.ServeMux
+	// WebhookSecret verifies payment callbacks to POST /webhooks/payments, empty disables them
+	WebhookSecret string
+}
+
+func NewServer(rc *blockchain.RideChain) *Server {
+	s := &Server{
+		rc:  rc,
+		mux: http.NewServeMux(),
+	}
+	s.handle("GET /headers", s.handleHeaders)
+	s.handle("GET /blocks/{hash}", s.handleBlock)
+	s.handle("GET /rides/{txID}/proof", s.handleRideProof)
+	s.handle("GET /rides/{txID}/history", s.handleRideHistory)
+	s.handle("GET /rides/{txID}", s.handleRide)
+	s.handle("GET /accounts/{uuid}", s.handleAccount)
+	s.handle("GET /profiles/{uuid}", s.handleProfile)
+	s.handle("GET /vehicles/{ref}", s.handleVehicle)
+	s.handle("POST /wallet/{action}", s.handleWalletAction)
+	s.handle("GET /metrics/expiry", s.handleExpiryMetrics)
+	// the payment report calls the provider once per ride, so it only locks while it snapshots the chain
+	s.mux.HandleFunc("GET /reports/payments", s.handlePaymentReport)
+	s.handle("GET /payouts/{batchID}", s.handlePayoutBatch)
+	s.handle("POST /webhooks/payments", s.handlePaymentWebhook)
+	return s
+}
+
+// Sweep expires stalled rides and verifications every interval until ctx is done
+func (s *Server) Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.sweep(); err != nil {
+				fmt.Printf("Sweep failed: %v\n", err)
+			}
+		}
+	}
+}
+
+func (s *Server) sweep() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired, err := s.rc.SweepExpired()
+	if err != nil {
+		return err
+	}
+	if expired == 0 {
+		return nil
+	}
+	return s.rc.Save()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle routes pattern to h holding the server's lock
+func (s *Server) handle(pattern string, h http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	})
+}
+
+// handleHeaders serves canonical headers, GET /headers?from=1&max=64
+func (s *Server) handleHeaders(w http.ResponseWriter, r *http.Request) {
+	from, err := intParam(r, "from", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	max, err := intParam(r, "max", defaultHeaderBatch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	headers := s.rc.Headers(from, max)
+	if headers == nil {
+		headers = []blockchain.BlockHeader{}
+	}
+	writeJSON(w, http.StatusOK, headers)
+}
+
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	block, err := s.rc.BlockByHash(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, block)
+}
+
+// handleRideProof serves the merkle inclusion proof a light client needs to verify a ride
+func (s *Server) handleRideProof(w http.ResponseWriter, r *http.Request) {
+	proof, err := s.rc.RideTxProof(r.PathValue("txID"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, proof)
+}
+
+func (s *Server) handleRide(w http.ResponseWriter, r *http.Request) {
+	tx, err := s.rc.RideTx(r.PathValue("txID"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tx)
+}
+
+// handleRideHistory serves a committed ride with its tips, adjustments and disputes
+func (s *Server) handleRideHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.rc.RideHistory(r.PathValue("txID"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (s *Server) handleExpiryMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.rc.ExpiryMetrics())
+}
+
+// handlePaymentReport reconciles committed rides against the payment provider without holding
+// the lock while it waits on the provider
+func (s *Server) handlePaymentReport(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	reconciliation, err := s.rc.NewPaymentReconciliation()
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	report, err := reconciliation.Run(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// WebhookResponse tells the payment provider whether the callback changed a ride
+type WebhookResponse struct {
+	Received bool `json:"received"`
+	Applied  bool `json:"applied"`
+}
+
+// handlePaymentWebhook applies a signed Stripe callback to the ride paid with the session,
+// unknown payments answer 404 so the provider retries after the paid callback lands
+func (s *Server) handlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.WebhookSecret == "" {
+		writeError(w, http.StatusNotFound, errors.New("payment webhook not configured"))
+		return
+	}
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	evt, ok, err := blockchain.ParseStripeWebhook(payload, r.Header.Get("Stripe-Signature"), s.WebhookSecret, time.Now())
+	switch {
+	case errors.Is(err, blockchain.ErrWebhookSignature):
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case !ok:
+		writeJSON(w, http.StatusOK, WebhookResponse{Received: true})
+		return
+	}
+
+	applied, err := s.rc.ApplyPaymentEvent(evt)
+	switch {
+	case errors.Is(err, blockchain.ErrUnknownPayment):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if applied {
+		if err := s.rc.Save(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, WebhookResponse{Received: true, Applied: applied})
+}
+
+// handleProfile serves the rides and reputation behind a driver's validator eligibility
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.rc.ValidatorProfile(r.PathValue("uuid")))
+}
+
+// handleVehicle serves a registered vehicle looked up by ID, VIN or plate
+func (s *Server) handleVehicle(w http.ResponseWriter, r *http.Request) {
+	vehicle, err := s.rc.LookupVehicle(r.PathValue("ref"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, vehicle)
+}
+
+// handlePayoutBatch serves a settled payout batch, ?format=csv serves the file for the payments team
+func (s *Server) handlePayoutBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := s.rc.PayoutBatch(r.PathValue("batchID"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if r.URL.Query().Get("format") != "csv" {
+		writeJSON(w, http.StatusOK, batch)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", batch.ID+".csv"))
+	if err := batch.WriteCSV(w); err != nil {
+		fmt.Printf("Payout batch %s: %v\n", batch.ID, err)
+	}
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.rc.Account(r.PathValue("uuid")))
+}
+
+// handleWalletAction runs a signed wallet action and persists the chain
+func (s *Server) handleWalletAction(w http.ResponseWriter, r *http.Request) {
+	var req SignedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Action != r.PathValue("action") {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("request signed for %q sent to %q", req.Action, r.PathValue("action")))
+		return
+	}
+	if err := s.authenticate(req); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err := RunWalletAction(s.rc, req.Signer, req.Action, req.Payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.rc.Save(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.rc.Account(req.Signer))
+}
+
+// authenticate checks the request against the signer's registered key
+// registering a key is signed by the key being registered
+func (s *Server) authenticate(req SignedRequest) error {
+	pub, ok := s.rc.AccountKeys[req.Signer]
+	if req.Action == ActionRegisterKey && !ok {
+		// a self-signed key proves nothing about who owns tokens or a role already held by the uuid
+		if s.rc.HasAccountState(req.Signer) {
+			return fmt.Errorf("%s already holds state, a validator must bind its key with %s", req.Signer, ActionBindKey)
+		}
+		var payload RegisterKeyPayload
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			return err
+		}
+		key, err := blockchain.ParsePublicKey(payload.PublicKey)
+		if err != nil {
+			return err
+		}
+		pub = key
+	} else if !ok {
+		return fmt.Errorf("no public key registered for %s", req.Signer)
+	}
+	if err := req.Verify(pub, time.Now()); err != nil {
+		return err
+	}
+	return s.rc.UseNonce(req.Signer, req.Nonce)
+}
+
+// RunWalletAction applies a wallet action for signer, the caller is responsible for authenticating it
+func RunWalletAction(rc *blockchain.RideChain, signer, action string, data json.RawMessage) error {
+	switch action {
+	case ActionRegisterKey:
+		var payload RegisterKeyPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		key, err := blockchain.ParsePublicKey(payload.PublicKey)
+		if err != nil {
+			return err
+		}
+		return rc.RegisterPublicKey(signer, key)
+	case ActionStake:
+		var payload AmountPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.StakeTokens(payload.Amount, signer)
+	case ActionUnstake:
+		var payload AmountPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.UnstakeTokens(payload.Amount, signer)
+	case ActionDelegate:
+		var payload DelegatePayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.DelegateTokens(payload.Amount, signer, payload.Validator)
+	case ActionUndelegate:
+		var payload DelegatePayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.UndelegateTokens(payload.Amount, signer, payload.Validator)
+	case ActionBindKey:
+		var payload BindKeyPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		key, err := blockchain.ParsePublicKey(payload.PublicKey)
+		if err != nil {
+			return err
+		}
+		return rc.BindPublicKey(payload.UUID, key, signer)
+	case ActionBecomeValidator:
+		return rc.BecomeValidator(signer)
+	case ActionRequestVerify:
+		var payload RequestVerificationPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.RequestDriverVerification(payload.DriverUUID, signer, payload.RequestedAt, payload.Signature)
+	case ActionVerifyDriver:
+		var payload VerifyDriverPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.VerifyDriver(payload.DriverUUID, signer, payload.Results)
+	case ActionRejectDriver:
+		var payload VerifyDriverPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.RejectDriver(payload.DriverUUID, signer, payload.Results)
+	case ActionRegisterVehicle:
+		var payload RegisterVehiclePayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		_, err := rc.RegisterVehicle(signer, payload.VIN, payload.Vehicle)
+		return err
+	case ActionConfirmVehicle:
+		var payload ConfirmVehiclePayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.ConfirmVehicle(payload.VehicleID, payload.DriverUUID, signer)
+	case ActionInsureVehicle:
+		var payload InsureVehiclePayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.InsureVehicle(payload.VehicleID, signer, payload.Policy, payload.Until)
+	case ActionProposeRefund:
+		var payload ProposeRefundPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		_, err := rc.ProposeRefund(payload.TxID, payload.Amount, signer, payload.Reason)
+		return err
+	case ActionApproveRefund:
+		var payload RefundPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.ApproveRefund(payload.RefundID, signer)
+	case ActionRejectRefund:
+		var payload RefundPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		return rc.RejectRefund(payload.RefundID, signer)
+	case ActionIssueRefund:
+		var payload RefundPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		if !rc.IsValidator(signer) {
+			return fmt.Errorf("%s is not a validator", signer)
+		}
+		return rc.IssueRefund(payload.RefundID)
+	case ActionSettle:
+		var payload SettlePayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+		_, err := rc.SettleEarnings(payload.PeriodStart, signer)
+		return err
+	}
+	return errors.New("unknown wallet action " + action)
+}
+
+func intParam(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+func TestServer_WalletAction(t *testing.T) {
+	rc, err := blockchain.NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	rc.TokenLedger.Mint("driver-123", 20)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+
+	server := httptest.NewServer(NewServer(rc))
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	pub, key, err := blockchain.GenerateKeyPair()
+	assert.Nil(t, err)
+	otherPub, otherKey, err := blockchain.GenerateKeyPair()
+	assert.Nil(t, err)
+	validatorPub, validatorKey, err := blockchain.GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey("genesis-123", validatorPub))
+
+	stake, err := NewSignedRequest("driver-123", ActionStake, AmountPayload{Amount: 5}, 1, key)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name        string
+		request     func() SignedRequest
+		wantErr     bool
+		wantBalance int
+	}{
+		{
+			name: "staking before a key is registered",
+			request: func() SignedRequest {
+				req, _ := NewSignedRequest("driver-123", ActionStake, AmountPayload{Amount: 5}, 1, key)
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "claiming a funded account with a self-signed key",
+			request: func() SignedRequest {
+				req, _ := NewSignedRequest("driver-123", ActionRegisterKey, RegisterKeyPayload{PublicKey: hexKey(otherPub)}, 1, otherKey)
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "registering a key for a new account",
+			request: func() SignedRequest {
+				req, _ := NewSignedRequest("rider-456", ActionRegisterKey, RegisterKeyPayload{PublicKey: hexKey(otherPub)}, 1, otherKey)
+				return req
+			},
+		},
+		{
+			name: "binding a key as someone who is not a validator",
+			request: func() SignedRequest {
+				req, _ := NewSignedRequest("rider-456", ActionBindKey, BindKeyPayload{UUID: "driver-123", PublicKey: hexKey(otherPub)}, 2, otherKey)
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "binding the funded driver's key as a validator",
+			request: func() SignedRequest {
+				req, _ := NewSignedRequest("genesis-123", ActionBindKey, BindKeyPayload{UUID: "driver-123", PublicKey: hexKey(pub)}, 1, validatorKey)
+				return req
+			},
+		},
+		{
+			name: "staking signed by someone else's key",
+			request: func() SignedRequest {
+				req, _ := NewSignedRequest("driver-123", ActionStake, AmountPayload{Amount: 5}, 1, otherKey)
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name: "replaying a stake request against another action",
+			request: func() SignedRequest {
+				req := stake
+				req.Action = ActionUnstake
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name:        "staking signed by the bound key",
+			request:     func() SignedRequest { return stake },
+			wantBalance: 15,
+		},
+		{
+			name:    "replaying the same stake request",
+			request: func() SignedRequest { return stake },
+			wantErr: true,
+		},
+		{
+			name: "undelegating tokens never delegated",
+			request: func() SignedRequest {
+				req, _ := NewSignedRequest("driver-123", ActionUndelegate, DelegatePayload{Validator: "genesis-123", Amount: 1}, 2, key)
+				return req
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, err := client.Submit(tt.request())
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			if tt.wantBalance > 0 {
+				assert.Equal(t, tt.wantBalance, account.Balance)
+			}
+		})
+	}
+}
+
+func hexKey(pub ed25519.PublicKey) string {
+	return hex.EncodeToString(pub)
+}
+
+func TestServer_PaymentWebhook(t *testing.T) {
+	rc, err := blockchain.NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	server := NewServer(rc)
+	server.WebhookSecret = "whsec_test"
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	paid := []byte(fmt.Sprintf(`{"id":"evt_1","type":"checkout.session.completed","created":%d,`+
+		`"data":{"object":{"id":"cs_1","amount_total":500,"payment_status":"paid","payment_intent":"pi_1"}}}`, time.Now().Unix()))
+	refund := []byte(fmt.Sprintf(`{"id":"evt_2","type":"charge.refunded","created":%d,`+
+		`"data":{"object":{"id":"ch_2","amount_refunded":500,"payment_intent":"pi_2"}}}`, time.Now().Unix()))
+
+	tests := []struct {
+		name        string
+		payload     []byte
+		secret      string
+		wantStatus  int
+		wantApplied bool
+	}{
+		{name: "signed with the wrong secret", payload: paid, secret: "whsec_other", wantStatus: http.StatusUnauthorized},
+		{name: "paid checkout", payload: paid, secret: "whsec_test", wantStatus: http.StatusOK, wantApplied: true},
+		{name: "stripe retrying the same event", payload: paid, secret: "whsec_test", wantStatus: http.StatusOK},
+		{name: "refund for a payment never seen", payload: refund, secret: "whsec_test", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/webhooks/payments", bytes.NewReader(tt.payload))
+			assert.Nil(t, err)
+			req.Header.Set("Stripe-Signature", blockchain.StripeSignature(tt.payload, tt.secret, time.Now()))
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			var body WebhookResponse
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tt.wantApplied, body.Applied)
+		})
+	}
+	assert.Len(t, rc.Payments["cs_1"], 1)
+}
+
+func TestServer_PayoutBatch(t *testing.T) {
+	rc, err := blockchain.NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	start := time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)
+	batch, err := rc.SettleEarnings(start, "genesis-123")
+	assert.Nil(t, err)
+	batch.Settlements = []blockchain.Settlement{{ID: "s-1", DriverUUID: "driver-1", PeriodStart: start, PeriodEnd: batch.PeriodEnd, Rides: 2, Fares: 1200, Payout: 1176}}
+	batch.Total = 1176
+
+	server := httptest.NewServer(NewServer(rc))
+	defer server.Close()
+
+	saved, err := NewClient(server.URL).PayoutBatch(batch.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1176, saved.Total)
+	_, err = NewClient(server.URL).PayoutBatch("payout-unknown")
+	assert.NotNil(t, err)
+
+	resp, err := http.Get(server.URL + "/payouts/" + batch.ID + "?format=csv")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	var body bytes.Buffer
+	_, err = body.ReadFrom(resp.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, body.String(), "payout-20261005T000000Z,s-1,driver-1,")
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+// maxRequestAge bounds how long a signed request can be replayed
+const maxRequestAge = 5 * time.Minute
+
+// wallet actions accepted by POST /wallet/{action}
+const (
+	ActionRegisterKey     = "register-key"
+	ActionStake           = "stake"
+	ActionUnstake         = "unstake"
+	ActionDelegate        = "delegate"
+	ActionUndelegate      = "undelegate"
+	ActionBindKey         = "bind-key"
+	ActionBecomeValidator = "become-validator"
+	ActionRequestVerify   = "request-verify"
+	ActionVerifyDriver    = "verify-driver"
+	ActionRejectDriver    = "reject-driver"
+	ActionRegisterVehicle = "register-vehicle"
+	ActionInsureVehicle   = "insure-vehicle"
+	ActionConfirmVehicle  = "confirm-vehicle"
+	ActionProposeRefund   = "propose-refund"
+	ActionApproveRefund   = "approve-refund"
+	ActionRejectRefund    = "reject-refund"
+	ActionIssueRefund     = "issue-refund"
+	ActionSettle          = "settle"
+)
+
+// SignedRequest wraps a wallet action so the node can check who sent it
+type SignedRequest struct {
+	Signer    string          `json:"signer"`
+	Action    string          `json:"action"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp int64           `json:"timestamp"` // unix seconds
+	// Nonce must be one past the signer's last accepted request, see blockchain.Account.Nonce
+	Nonce     uint64 `json:"nonce"`
+	Signature []byte `json:"signature"`
+}
+
+type RegisterKeyPayload struct {
+	PublicKey string `json:"publicKey"` // hex encoded ed25519 key
+}
+
+// BindKeyPayload lets a validator register the key of an account that already holds state
+type BindKeyPayload struct {
+	UUID      string `json:"uuid"`
+	PublicKey string `json:"publicKey"` // hex encoded ed25519 key
+}
+
+type AmountPayload struct {
+	Amount int `json:"amount"`
+}
+
+type DelegatePayload struct {
+	Validator string `json:"validator"`
+	Amount    int    `json:"amount"`
+}
+
+// RequestVerificationPayload opens a driver's verification round, Signature is the driver's
+// on blockchain.VerificationRequestSigningBytes so anyone can relay it
+type RequestVerificationPayload struct {
+	DriverUUID  string    `json:"driverUUID"`
+	RequestedAt time.Time `json:"requestedAt"`
+	Signature   []byte    `json:"signature"`
+}
+
+type VerifyDriverPayload struct {
+	DriverUUID string `json:"driverUUID"`
+	Results    string `json:"results"`
+}
+
+type RegisterVehiclePayload struct {
+	VIN     string             `json:"vin"`
+	Vehicle blockchain.Vehicle `json:"vehicle"`
+}
+
+type ConfirmVehiclePayload struct {
+	VehicleID  string `json:"vehicleId"`
+	DriverUUID string `json:"driverUUID"`
+}
+
+type InsureVehiclePayload struct {
+	VehicleID string    `json:"vehicleId"`
+	Policy    string    `json:"policy"`
+	Until     time.Time `json:"until"`
+}
+
+// ProposeRefundPayload proposes a refund of a committed ride, an authorized refund debits
+// the driver's fiat earnings in cents and leaves their tokens alone
+type ProposeRefundPayload struct {
+	TxID   string `json:"txID"`
+	Amount int    `json:"amount"` // cents, zero refunds everything left
+	Reason string `json:"reason"`
+}
+
+type RefundPayload struct {
+	RefundID string `json:"refundId"`
+}
+
+type SettlePayload struct {
+	PeriodStart time.Time `json:"periodStart"`
+}
+
+func NewSignedRequest(signer, action string, payload interface{}, nonce uint64, key ed25519.PrivateKey) (SignedRequest, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return SignedRequest{}, err
+	}
+	req := SignedRequest{
+		Signer:    signer,
+		Action:    action,
+		Payload:   data,
+		Timestamp: time.Now().Unix(),
+		Nonce:     nonce,
+	}
+	req.Signature = ed25519.Sign(key, req.signingBytes())
+	return req, nil
+}
+
+func (r SignedRequest) signingBytes() []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d|%s", r.Signer, r.Action, r.Timestamp, r.Nonce, r.Payload)))
+	return digest[:]
+}
+
+// Verify checks the signature and that the request is recent
+func (r SignedRequest) Verify(pub ed25519.PublicKey, now time.Time) error {
+	age := now.Sub(time.Unix(r.Timestamp, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return fmt.Errorf("request from %s expired", r.Signer)
+	}
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, r.signingBytes(), r.Signature) {
+		return fmt.Errorf("invalid signature from %s", r.Signer)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import "fmt"
+
+// Account is the wallet view of a driver, rider or validator
+type Account struct {
+	UUID        string         `json:"uuid"`
+	Balance     int            `json:"balance"`
+	Stake       int            `json:"stake"`
+	Delegated   int            `json:"delegated"`   // tokens others delegated to this validator
+	Delegations map[string]int `json:"delegations"` // validatorUUID -> tokens this account delegated
+	Validator   bool           `json:"validator"`
+	// Nonce is the last signed request the node accepted from this account, the next must use Nonce+1
+	Nonce uint64 `json:"nonce"`
+}
+
+func (rc *RideChain) Account(uuid string) Account {
+	rc.TokenLedger.mu.RLock()
+	account := Account{
+		UUID:        uuid,
+		Balance:     rc.TokenLedger.Balances[uuid],
+		Stake:       rc.TokenLedger.Stakes[uuid],
+		Delegations: make(map[string]int),
+		Validator:   rc.IsValidator(uuid),
+		Nonce:       rc.AccountNonces[uuid],
+	}
+	for validator, amount := range rc.TokenLedger.Delegations[uuid] {
+		account.Delegations[validator] = amount
+	}
+	rc.TokenLedger.mu.RUnlock()
+
+	account.Delegated = rc.TokenLedger.DelegatedStake(uuid)
+	return account
+}
+
+// UnstakeTokens returns staked tokens to the driver's balance
+// a validator falling below the minimum stake stops being a validator
+func (rc *RideChain) UnstakeTokens(amount int, driverUUID string) error {
+	if err := rc.TokenLedger.Unstake(driverUUID, amount); err != nil {
+		return err
+	}
+	if rc.IsValidator(driverUUID) && len(rc.Validators) > 1 && rc.TokenLedger.GetStake(driverUUID) < rc.minValidatorStake {
+		delete(rc.Validators, driverUUID)
+		rc.recordValidatorUpdate(driverUUID, true)
+		fmt.Printf("Validator %s unstaked below %d tokens and was removed from validators\n", driverUUID, rc.minValidatorStake)
+	}
+	return rc.TokenLedger.SaveToFile()
+}
+
+// DelegateTokens backs a validator with tokens from the delegator's balance
+func (rc *RideChain) DelegateTokens(amount int, delegator, validatorUUID string) error {
+	if !rc.IsValidator(validatorUUID) {
+		return fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	if err := rc.TokenLedger.Delegate(delegator, validatorUUID, amount); err != nil {
+		return err
+	}
+	return rc.TokenLedger.SaveToFile()
+}
+
+// UndelegateTokens returns tokens the delegator backed a validator with
+func (rc *RideChain) UndelegateTokens(amount int, delegator, validatorUUID string) error {
+	if err := rc.TokenLedger.Undelegate(delegator, validatorUUID, amount); err != nil {
+		return err
+	}
+	return rc.TokenLedger.SaveToFile()
+}
+
+// UseNonce accepts a signed request's nonce once, it must be one past the last accepted
+func (rc *RideChain) UseNonce(uuid string, nonce uint64) error {
+	if want := rc.AccountNonces[uuid] + 1; nonce != want {
+		return fmt.Errorf("nonce %d from %s already used or out of order, expected %d", nonce, uuid, want)
+	}
+	rc.AccountNonces[uuid] = nonce
+	return nil
+}
+
+// HasAccountState reports whether uuid holds tokens, a role or a vehicle, such an account
+// cannot claim a key for itself because anyone could sign that claim
+func (rc *RideChain) HasAccountState(uuid string) bool {
+	rc.TokenLedger.mu.RLock()
+	tokens := rc.TokenLedger.Balances[uuid] != 0 || rc.TokenLedger.Stakes[uuid] != 0 || len(rc.TokenLedger.Delegations[uuid]) > 0
+	rc.TokenLedger.mu.RUnlock()
+	if tokens || rc.IsValidator(uuid) {
+		return true
+	}
+	if _, ok := rc.PendingVerifications[uuid]; ok {
+		return true
+	}
+	if _, ok := rc.PendingRideTxs[uuid]; ok {
+		return true
+	}
+	for _, v := range rc.Vehicles {
+		for _, owner := range v.Owners {
+			if owner == uuid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RideTx looks up a ride by TxID in the ledger
+func (rc *RideChain) RideTx(txID string) (RideTx, error) {
+	tx, ok := rc.RideLedger[txID]
+	if !ok {
+		return RideTx{}, fmt.Errorf("rideTx %s not found", txID)
+	}
+	return tx, nil
+}
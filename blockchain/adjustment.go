@@ -0,0 +1,267 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AdjustmentKind string
+
+const (
+	// AdjustmentTip is signed by the rider and settles immediately
+	AdjustmentTip AdjustmentKind = "tip"
+	// AdjustmentToll and AdjustmentWaitTime are claimed by the driver and settle once validators approve
+	AdjustmentToll     AdjustmentKind = "toll"
+	AdjustmentWaitTime AdjustmentKind = "waitTime"
+)
+
+// Adjustment changes what a committed ride paid the driver, amounts are tokens
+// settling moves Amount from the rider's balance to the driver's, as far as the
+// balance covers it, the rest of a toll or wait time is left as a Receivable
+type Adjustment struct {
+	ID          string          `json:"id"`
+	TxID        string          `json:"txID"`
+	Kind        AdjustmentKind  `json:"kind"`
+	Amount      int             `json:"amount"`
+	RequestedBy string          `json:"requestedBy"`
+	Note        string          `json:"note"`
+	Timestamp   time.Time       `json:"timestamp"`
+	Signature   []byte          `json:"signature,omitempty"` // rider signature on tips
+	Approvals   map[string]bool `json:"approvals,omitempty"` // validatorUUID -> approved
+	Settled     bool            `json:"settled"`
+	SettledAt   time.Time       `json:"settledAt"`
+	// Receivable is what the rider's balance could not cover, collected by the payment side for the driver
+	Receivable int `json:"receivable"`
+}
+
+// NewTip builds a tip from the rider on a committed ride, sign it with Sign before submitting
+func NewTip(txID, riderUUID string, amount int, note string) Adjustment {
+	return Adjustment{
+		TxID:        txID,
+		Kind:        AdjustmentTip,
+		Amount:      amount,
+		RequestedBy: riderUUID,
+		Note:        note,
+		Timestamp:   now().UTC(),
+	}
+}
+
+// SigningBytes is the digest the rider signs for a tip
+func (a Adjustment) SigningBytes() []byte {
+	record := fmt.Sprintf("%s|%s|%d|%s|%s|%d", a.TxID, a.Kind, a.Amount, a.RequestedBy, a.Note, a.Timestamp.UnixNano())
+	digest := sha256.Sum256([]byte(record))
+	return digest[:]
+}
+
+func (a *Adjustment) Sign(key ed25519.PrivateKey) {
+	a.Signature = ed25519.Sign(key, a.SigningBytes())
+}
+
+// SubmitTip verifies the rider's signature and pays the tip to the driver once it is committed
+func (rc *RideChain) SubmitTip(tip Adjustment) (*Adjustment, error) {
+	tx, err := rc.RideTx(tip.TxID)
+	if err != nil {
+		return nil, err
+	}
+	if tip.Kind != AdjustmentTip {
+		return nil, fmt.Errorf("adjustment %s is not a tip", tip.Kind)
+	}
+	if tip.RequestedBy != tx.RiderUUID {
+		return nil, fmt.Errorf("only rider %s can tip on ride %s", tx.RiderUUID, tip.TxID)
+	}
+	if tip.Amount <= 0 {
+		return nil, fmt.Errorf("tip must be positive")
+	}
+	pub, ok := rc.AccountKeys[tip.RequestedBy]
+	if !ok {
+		return nil, fmt.Errorf("no public key registered for %s", tip.RequestedBy)
+	}
+	if len(tip.Signature) == 0 || !ed25519.Verify(pub, tip.SigningBytes(), tip.Signature) {
+		return nil, fmt.Errorf("invalid rider signature on tip for ride %s", tip.TxID)
+	}
+	for _, existing := range rc.Adjustments {
+		if existing.Kind == AdjustmentTip && string(existing.Signature) == string(tip.Signature) {
+			return nil, fmt.Errorf("tip %s already submitted", existing.ID)
+		}
+	}
+	if rc.TokenLedger.GetBalance(tip.RequestedBy) < tip.Amount {
+		return nil, fmt.Errorf("rider %s cannot cover a %d token tip", tip.RequestedBy, tip.Amount)
+	}
+
+	adj := tip
+	adj.ID = uuid.NewString()
+	adj.Approvals = nil
+	if err := rc.settleAdjustment(&adj, tx); err != nil {
+		return nil, err
+	}
+	if err := rc.flushRecords(); err != nil {
+		rc.unsettleAdjustment(&adj)
+		return nil, err
+	}
+	rc.Adjustments[adj.ID] = &adj
+	return &adj, rc.payAdjustment(&adj, tx)
+}
+
+// ProposeAdjustment lets the driver claim a toll or wait time on a committed ride
+func (rc *RideChain) ProposeAdjustment(txID string, kind AdjustmentKind, amount int, driverUUID, note string) (*Adjustment, error) {
+	tx, err := rc.RideTx(txID)
+	if err != nil {
+		return nil, err
+	}
+	if kind != AdjustmentToll && kind != AdjustmentWaitTime {
+		return nil, fmt.Errorf("drivers can only claim tolls or wait time, not %s", kind)
+	}
+	if driverUUID != tx.DriverUUID {
+		return nil, fmt.Errorf("only driver %s can claim adjustments on ride %s", tx.DriverUUID, txID)
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("adjustment must be positive")
+	}
+
+	adj := &Adjustment{
+		ID:          uuid.NewString(),
+		TxID:        txID,
+		Kind:        kind,
+		Amount:      amount,
+		RequestedBy: driverUUID,
+		Note:        note,
+		Timestamp:   now().UTC(),
+		Approvals:   make(map[string]bool),
+	}
+	rc.Adjustments[adj.ID] = adj
+	fmt.Printf("Adjustment %s proposed: %s %d on ride %s\n", adj.ID, kind, amount, txID)
+	return adj, nil
+}
+
+// ApproveAdjustment records a validator's approval and settles at ApprovalQuorum
+func (rc *RideChain) ApproveAdjustment(adjustmentID, validatorUUID string) error {
+	if !rc.IsValidator(validatorUUID) {
+		return fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	adj, ok := rc.Adjustments[adjustmentID]
+	if !ok {
+		return fmt.Errorf("adjustment %s not found", adjustmentID)
+	}
+	if adj.Settled {
+		return fmt.Errorf("adjustment %s already settled", adjustmentID)
+	}
+	if adj.Kind == AdjustmentTip {
+		return fmt.Errorf("tips do not need validator approval")
+	}
+	if validatorUUID == adj.RequestedBy {
+		return fmt.Errorf("validator %s cannot approve their own adjustment", validatorUUID)
+	}
+	if adj.Approvals[validatorUUID] {
+		return fmt.Errorf("validator %s already approved adjustment %s", validatorUUID, adjustmentID)
+	}
+	adj.Approvals[validatorUUID] = true
+
+	if len(adj.Approvals) < rc.ApprovalQuorum {
+		return nil
+	}
+	tx, err := rc.RideTx(adj.TxID)
+	if err != nil {
+		return err
+	}
+	if err := rc.settleAdjustment(adj, tx); err != nil {
+		delete(adj.Approvals, validatorUUID)
+		return err
+	}
+	// the approving validators commit the adjustment right away
+	if err := rc.commitRideTxs(nil, adj.Approvals); err != nil {
+		rc.unsettleAdjustment(adj)
+		delete(adj.Approvals, validatorUUID)
+		return err
+	}
+	return rc.payAdjustment(adj, tx)
+}
+
+// settleAdjustment marks the adjustment settled for what the rider's balance covers and
+// queues it to be committed, tips are covered in full by SubmitTip
+// the tokens move with payAdjustment once the adjustment is committed
+func (rc *RideChain) settleAdjustment(adj *Adjustment, tx RideTx) error {
+	covered := min(adj.Amount, max(rc.TokenLedger.GetBalance(tx.RiderUUID), 0))
+	adj.Receivable = adj.Amount - covered
+	adj.Settled = true
+	adj.SettledAt = now()
+	if err := rc.queueRecord(RecordAdjustment, adj.ID, adj); err != nil {
+		rc.unsettleAdjustment(adj)
+		return err
+	}
+	return nil
+}
+
+// unsettleAdjustment undoes settleAdjustment when the adjustment could not be committed
+func (rc *RideChain) unsettleAdjustment(adj *Adjustment) {
+	rc.dropRecord(RecordAdjustment, adj.ID)
+	adj.Receivable = 0
+	adj.Settled = false
+	adj.SettledAt = time.Time{}
+}
+
+// payAdjustment moves the covered part of a settled adjustment from the rider to the driver
+func (rc *RideChain) payAdjustment(adj *Adjustment, tx RideTx) error {
+	covered := adj.Amount - adj.Receivable
+	rc.TokenLedger.mu.Lock()
+	rc.TokenLedger.Balances[tx.RiderUUID] -= covered
+	rc.TokenLedger.Balances[tx.DriverUUID] += covered
+	rc.TokenLedger.mu.Unlock()
+
+	fmt.Printf("Adjustment %s settled: %s %d to driver %s, %d receivable from rider %s\n",
+		adj.ID, adj.Kind, covered, tx.DriverUUID, adj.Receivable, tx.RiderUUID)
+	return rc.TokenLedger.SaveToFile()
+}
+
+// RideHistory is a committed ride with everything that happened to it afterwards
+type RideHistory struct {
+	Ride        RideTx       `json:"ride"`
+	Adjustments []Adjustment `json:"adjustments"`
+	Disputes    []Dispute    `json:"disputes"`
+	// Payments are the provider's paid, refunded and disputed callbacks for the ride's session
+	Payments []PaymentEvent `json:"payments"`
+	// Refunds are the authorized and issued refunds of the fare
+	Refunds []Refund `json:"refunds"`
+	// DriverEarned is PaidAmount plus settled adjustments less refunds, cents and tokens are treated 1:1
+	DriverEarned int `json:"driverEarned"`
+}
+
+// RideHistory returns the committed ride with its adjustments and disputes in time order
+func (rc *RideChain) RideHistory(txID string) (RideHistory, error) {
+	tx, err := rc.RideTx(txID)
+	if err != nil {
+		return RideHistory{}, err
+	}
+
+	history := RideHistory{Ride: tx, DriverEarned: tx.PaidAmount}
+	for _, adj := range rc.Adjustments {
+		if adj.TxID != txID {
+			continue
+		}
+		history.Adjustments = append(history.Adjustments, *adj)
+		if adj.Settled {
+			history.DriverEarned += adj.Amount
+		}
+	}
+	for _, d := range rc.Disputes {
+		if d.TxID == txID {
+			history.Disputes = append(history.Disputes, *d)
+		}
+	}
+	history.Payments = rc.Payments[tx.StripeSessionId]
+	history.Refunds = rc.rideRefunds(tx)
+	for _, r := range history.Refunds {
+		history.DriverEarned -= r.Amount
+	}
+	sort.Slice(history.Adjustments, func(i, j int) bool {
+		return history.Adjustments[i].Timestamp.Before(history.Adjustments[j].Timestamp)
+	})
+	sort.Slice(history.Disputes, func(i, j int) bool {
+		return history.Disputes[i].OpenedAt.Before(history.Disputes[j].OpenedAt)
+	})
+	return history, nil
+}
@@ -0,0 +1,162 @@
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideChain_SubmitTip(t *testing.T) {
+	driver, rider := "genesis-123", "rider-tip"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	txID := completeTestRide(t, rc, testRideTx(driver, rider), driver)
+
+	pub, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey(rider, pub))
+	_, otherKey, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	rc.TokenLedger.Mint(rider, 100)
+
+	tests := []struct {
+		name    string
+		tip     func() Adjustment
+		wantErr bool
+	}{
+		{
+			name: "unsigned tip",
+			tip: func() Adjustment {
+				return NewTip(txID, rider, 20, "thanks")
+			},
+			wantErr: true,
+		},
+		{
+			name: "tip signed by someone else",
+			tip: func() Adjustment {
+				tip := NewTip(txID, rider, 20, "thanks")
+				tip.Sign(otherKey)
+				return tip
+			},
+			wantErr: true,
+		},
+		{
+			name: "tip on a ride that was never committed",
+			tip: func() Adjustment {
+				tip := NewTip("missing", rider, 20, "thanks")
+				tip.Sign(key)
+				return tip
+			},
+			wantErr: true,
+		},
+		{
+			name: "tip larger than the rider's balance",
+			tip: func() Adjustment {
+				tip := NewTip(txID, rider, 1000, "thanks")
+				tip.Sign(key)
+				return tip
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed tip settles to the driver",
+			tip: func() Adjustment {
+				tip := NewTip(txID, rider, 20, "thanks")
+				tip.Sign(key)
+				return tip
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adj, err := rc.SubmitTip(tt.tip())
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, adj.Settled)
+		})
+	}
+
+	assert.Equal(t, 80, rc.TokenLedger.Balances[rider])
+	assert.Equal(t, 20, rc.TokenLedger.Balances[driver])
+
+	history, err := rc.RideHistory(txID)
+	assert.Nil(t, err)
+	assert.Len(t, history.Adjustments, 1)
+	assert.Equal(t, history.Ride.PaidAmount+20, history.DriverEarned)
+
+	// a tip whose block cannot be committed changes nothing and can be submitted again
+	_, driverKey, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SetSigner(driver, driverKey))
+	tip := NewTip(txID, rider, 30, "sorry for the wait")
+	tip.Sign(key)
+	finalized := rc.Chain.Finalized
+	rc.Chain.Finalized = "not-a-block"
+	_, err = rc.SubmitTip(tip)
+	assert.NotNil(t, err)
+	assert.Equal(t, 80, rc.TokenLedger.Balances[rider])
+	assert.Equal(t, 20, rc.TokenLedger.Balances[driver])
+	assert.Len(t, rc.Adjustments, 1)
+	assert.Len(t, rc.pendingRecords, 1, "only the first tip waits for a block")
+
+	rc.Chain.Finalized = finalized
+	adj, err := rc.SubmitTip(tip)
+	assert.Nil(t, err)
+	_, ok := rc.RecordBlock(RecordAdjustment, adj.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 50, rc.TokenLedger.Balances[rider])
+	assert.Equal(t, 50, rc.TokenLedger.Balances[driver])
+}
+
+func TestRideChain_ApproveAdjustment(t *testing.T) {
+	driver, rider := "driver-toll", "rider-toll"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	rc.TokenLedger.Mint("validator-2", 10)
+	assert.Nil(t, rc.StakeTokens(10, "validator-2"))
+	assert.Nil(t, rc.BecomeValidator("validator-2"))
+	rc.ApprovalQuorum = 2
+
+	onboardTestDriver(t, rc, driver)
+	tx, err := rc.SubmitPendingRideTx(testRideTx(driver, rider))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+	driveTestRide(t, rc, tx, tx.ComputedRoute.DestinationLocation)
+	_, err = rc.ApproveRideTx(tx, "genesis-123")
+	assert.Nil(t, err)
+	txID, err := rc.ApproveRideTx(tx, "validator-2")
+	assert.Nil(t, err)
+
+	_, err = rc.ProposeAdjustment(txID, AdjustmentToll, 300, rider, "bridge toll")
+	assert.NotNil(t, err, "riders cannot claim tolls")
+	_, err = rc.ProposeAdjustment(txID, AdjustmentTip, 300, driver, "")
+	assert.NotNil(t, err, "drivers cannot tip themselves")
+
+	rc.TokenLedger.Mint(rider, 100)
+	toll, err := rc.ProposeAdjustment(txID, AdjustmentToll, 300, driver, "bridge toll")
+	assert.Nil(t, err)
+	assert.NotNil(t, rc.ApproveAdjustment(toll.ID, driver), "drivers are not validators")
+
+	assert.Nil(t, rc.ApproveAdjustment(toll.ID, "genesis-123"))
+	assert.False(t, toll.Settled)
+	assert.NotNil(t, rc.ApproveAdjustment(toll.ID, "genesis-123"))
+
+	assert.Nil(t, rc.ApproveAdjustment(toll.ID, "validator-2"))
+	assert.True(t, toll.Settled)
+	assert.Equal(t, 100, rc.TokenLedger.Balances[driver])
+	assert.Equal(t, 0, rc.TokenLedger.Balances[rider], "the rider's balance never goes negative")
+	assert.Equal(t, 200, toll.Receivable)
+	hash, ok := rc.RecordBlock(RecordAdjustment, toll.ID)
+	assert.True(t, ok, "the settled toll is committed in a block")
+	assert.Equal(t, rc.Chain.Head, hash)
+
+	history, err := rc.RideHistory(txID)
+	assert.Nil(t, err)
+	assert.Equal(t, AdjustmentToll, history.Adjustments[0].Kind)
+}
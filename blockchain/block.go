@@ -2,33 +2,52 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 var now = time.Now
 
 type Block struct {
+	Height        int
 	Timestamp     time.Time
-	Data          []byte // TODO will hold a transaction
+	Data          []byte // json encoded []RideTx committed by this block
 	PrevBlockHash string
 	Hash          string
 	Nonce         int
-	Validators    []Validator
+	Validators    []Validator // validators that approved the block and the stake the proposer reported for them
+	TxRoot        string      // merkle root of the RideTxs in Data
+	Proposer      string      // validatorUUID of the node that produced the block
+	Signature     []byte      // proposer signature over the header
+
+	// ValidatorUpdates are validator set changes that take effect after this block
+	ValidatorUpdates []ValidatorUpdate
+	// Records are adjustments and other ride state committed with the block, see Record
+	Records []Record
+}
+
+// ValidatorUpdate records a validator joining or leaving the validator set
+type ValidatorUpdate struct {
+	UUID    string
+	PubKey  ed25519.PublicKey // key light clients use to check the validator's signatures
+	Removed bool
 }
 
 // Validator stake will increase with each ride and/or driver transaction
 type Validator struct {
-	UUID  uuid.UUID
+	UUID  string
 	Stake int
 }
 
+// genesisTimestamp pins the genesis block so every node derives the same genesis hash
+var genesisTimestamp = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func CreateBlock(data []byte, prevHash string) *Block {
 	block := &Block{
 		Timestamp:     time.Now(),
@@ -38,17 +57,145 @@ func CreateBlock(data []byte, prevHash string) *Block {
 		Nonce:         0,
 	}
 
-	pos := NewProof(block)
-	nonce, hash := pos.Run()
-	block.Hash = string(hash)
-	block.Nonce = nonce
+	block.seal()
 	return block
 }
 
+// seal hashes the block once every field covered by the hash is set, and before it is signed
+func (b *Block) seal() {
+	b.Nonce, b.Hash = NewProof(b).Run()
+}
+
+// BlockHeader is everything about a block except its Data
+// syncing nodes and light clients verify headers before fetching bodies
+type BlockHeader struct {
+	Height        int
+	Timestamp     time.Time
+	PrevBlockHash string
+	Hash          string
+	Nonce         int
+	Validators    []Validator
+	TxRoot        string
+	Proposer      string
+	Signature     []byte
+
+	ValidatorUpdates []ValidatorUpdate
+}
+
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Height:        b.Height,
+		Timestamp:     b.Timestamp,
+		PrevBlockHash: b.PrevBlockHash,
+		Hash:          b.Hash,
+		Nonce:         b.Nonce,
+		Validators:    b.Validators,
+		TxRoot:        b.TxRoot,
+		Proposer:      b.Proposer,
+		Signature:     b.Signature,
+
+		ValidatorUpdates: b.ValidatorUpdates,
+	}
+}
+
+// SigningBytes is the digest of every header field the proposer signs
+func (h BlockHeader) SigningBytes() []byte {
+	record := fmt.Sprintf("%d|%d|%s|%s|%d|%s|%s", h.Height, h.Timestamp.UnixNano(), h.PrevBlockHash, h.Hash, h.Nonce, h.TxRoot, h.Proposer)
+	for _, v := range h.Validators {
+		record += fmt.Sprintf("|%s:%d", v.UUID, v.Stake)
+	}
+	for _, u := range h.ValidatorUpdates {
+		record += fmt.Sprintf("|%s:%x:%t", u.UUID, u.PubKey, u.Removed)
+	}
+	digest := sha256.Sum256([]byte(record))
+	return digest[:]
+}
+
+// VerifySignature checks the header was signed by the proposer's key
+func (h BlockHeader) VerifySignature(pub ed25519.PublicKey) bool {
+	if len(pub) != ed25519.PublicKeySize || len(h.Signature) == 0 {
+		return false
+	}
+	return ed25519.Verify(pub, h.SigningBytes(), h.Signature)
+}
+
+// Sign records proposer as the block producer and signs the header
+func (b *Block) Sign(proposer string, key ed25519.PrivateKey) {
+	b.Proposer = proposer
+	b.Signature = ed25519.Sign(key, b.Header().SigningBytes())
+}
+
+// VerifyBody checks that the block's data is the body committed to by header
+func (b *Block) VerifyBody(h BlockHeader) error {
+	if b.Hash != h.Hash || b.PrevBlockHash != h.PrevBlockHash || b.Height != h.Height {
+		return fmt.Errorf("block %s does not match header %s", b.Hash, h.Hash)
+	}
+	if hash := NewProof(b).hash(b.Nonce); hash != h.Hash {
+		return fmt.Errorf("block %s data hashes to %s", h.Hash, hash)
+	}
+	txs, err := b.RideTxs()
+	if err != nil {
+		return fmt.Errorf("decode block %s: %w", h.Hash, err)
+	}
+	if root := TxRoot(txs); root != h.TxRoot {
+		return fmt.Errorf("block %s tx root %s does not match header %s", h.Hash, root, h.TxRoot)
+	}
+	return nil
+}
+
 func Genesis(data []byte) *Block {
 	return CreateBlock(data, "")
 }
 
+// NewGenesisBlock returns the empty genesis block shared by every RideChain node
+func NewGenesisBlock() *Block {
+	block := &Block{
+		Timestamp: genesisTimestamp,
+	}
+	block.seal()
+	return block
+}
+
+// NewRideBlock packs committed RideTxs into a block on top of prev
+func NewRideBlock(prev *Block, txs []RideTx, validators []Validator) (*Block, error) {
+	data, err := json.Marshal(txs)
+	if err != nil {
+		return nil, err
+	}
+	block := &Block{
+		Height:        prev.Height + 1,
+		Timestamp:     now(),
+		Data:          data,
+		PrevBlockHash: prev.Hash,
+		Validators:    validators,
+		TxRoot:        TxRoot(txs),
+	}
+	block.seal()
+	return block, nil
+}
+
+// RideTxs decodes Block.Data back into the RideTxs it carries
+func (b *Block) RideTxs() ([]RideTx, error) {
+	if len(b.Data) == 0 {
+		return nil, nil
+	}
+	var txs []RideTx
+	if err := json.Unmarshal(b.Data, &txs); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// ReportedStake is the total stake the proposer reported for the approving validators,
+// fork choice does not trust it and weighs approvers by their stake in the local ledger
+func (b *Block) ReportedStake() int {
+	weight := 0
+	for _, v := range b.Validators {
+		weight += v.Stake
+	}
+	return weight
+}
+
 func (b *Block) calculateHash() string {
 	var record string
 	record = fmt.Sprintf("%d%s%s%s", b.Nonce, b.Timestamp, b.Data, b.PrevBlockHash)
@@ -0,0 +1,177 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+type CancellationReason string
+
+const (
+	RiderCancelled  CancellationReason = "riderCancelled"
+	DriverCancelled CancellationReason = "driverCancelled"
+	RiderNoShow     CancellationReason = "riderNoShow"
+)
+
+// CancellationPolicy amounts are cents of the rider's payment unless noted
+type CancellationPolicy struct {
+	// RiderCancelGrace is how long after TimeRequested a rider can cancel for free
+	RiderCancelGrace time.Duration
+	// RiderCancelFee is paid to the driver when the rider cancels after the grace period
+	RiderCancelFee int
+	// DriverCancelPenalty is debited in tokens from a driver who cancels, the rider is fully refunded
+	DriverCancelPenalty int
+	// NoShowWait is how long after EstimatedPickup the driver has to wait before reporting a no-show
+	NoShowWait time.Duration
+	// NoShowFee is paid to the driver when the rider never shows up
+	NoShowFee int
+}
+
+func DefaultCancellationPolicy() CancellationPolicy {
+	return CancellationPolicy{
+		RiderCancelGrace:    2 * time.Minute,
+		RiderCancelFee:      500,
+		DriverCancelPenalty: 1,
+		NoShowWait:          5 * time.Minute,
+		NoShowFee:           500,
+	}
+}
+
+// PaymentInstruction tells the payment side what to do with the rider's payment
+type PaymentInstruction struct {
+	RefundToRider int `json:"refundToRider"` // cents returned to the rider
+	PayToDriver   int `json:"payToDriver"`   // cents the driver keeps as a cancellation fee
+	DriverPenalty int `json:"driverPenalty"` // tokens debited from the driver
+}
+
+// RideCancellation is recorded on-chain with the cancelled RideTx
+type RideCancellation struct {
+	Reason      CancellationReason `json:"reason"`
+	RequestedBy string             `json:"requestedBy"`
+	Timestamp   time.Time          `json:"timestamp"`
+	Instruction PaymentInstruction `json:"instruction"`
+}
+
+// CancellationSigningBytes is what the rider or driver signs to cancel their pending ride
+func CancellationSigningBytes(tx RideTx, reason CancellationReason, requestedBy string) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("cancel|%s|%s|%d|%s|%s",
+		tx.DriverUUID, tx.RiderUUID, tx.TimeRequested.UnixNano(), reason, requestedBy)))
+	return digest[:]
+}
+
+// CancelRide marks a pending ride cancelled with its payment instruction, requestedBy signs
+// CancellationSigningBytes with their registered key. Like a completed ride the cancellation is
+// only committed once ApprovalQuorum validators approve it with ApproveRideTx
+func (rc *RideChain) CancelRide(driverUUID string, reason CancellationReason, requestedBy string, signature []byte) (RideTx, error) {
+	tx, exists := rc.PendingRideTxs[driverUUID]
+	if !exists {
+		return RideTx{}, fmt.Errorf("no pending ride for driver %s", driverUUID)
+	}
+	if tx.DropoffConfirmed {
+		return RideTx{}, fmt.Errorf("ride for driver %s already dropped off", driverUUID)
+	}
+	if tx.Cancelled() {
+		return RideTx{}, fmt.Errorf("ride for driver %s already cancelled", driverUUID)
+	}
+
+	instruction, err := rc.CancellationPolicy.instruction(tx, reason, requestedBy, now())
+	if err != nil {
+		return RideTx{}, err
+	}
+	pub, ok := rc.AccountKeys[requestedBy]
+	if !ok {
+		return RideTx{}, fmt.Errorf("no public key registered for %s", requestedBy)
+	}
+	if !ed25519.Verify(pub, CancellationSigningBytes(tx, reason, requestedBy), signature) {
+		return RideTx{}, fmt.Errorf("invalid cancellation signature from %s", requestedBy)
+	}
+
+	cancellation := &RideCancellation{
+		Reason:      reason,
+		RequestedBy: requestedBy,
+		Timestamp:   now(),
+		Instruction: instruction,
+	}
+	tx.Cancellation = cancellation
+	tx.RideTxEvts = append(tx.RideTxEvts, RideTxEvt{
+		EventType: RideCancelled,
+		Timestamp: cancellation.Timestamp,
+		Metadata: map[string]interface{}{
+			"reason":        string(reason),
+			"requestedBy":   requestedBy,
+			"refundToRider": instruction.RefundToRider,
+			"payToDriver":   instruction.PayToDriver,
+			"driverPenalty": instruction.DriverPenalty,
+		},
+	})
+	// approvals given to the ride do not carry over to its cancellation
+	rc.PendingRideTxs[driverUUID] = tx
+	delete(rc.RideApprovals, driverUUID)
+	delete(rc.PendingTraces, driverUUID)
+	delete(rc.pickupSecrets, driverUUID)
+
+	fmt.Printf("Ride for driver %s cancelled (%s) by %s, awaiting approval: refund %d, driver fee %d, driver penalty %d\n",
+		driverUUID, reason, requestedBy, instruction.RefundToRider, instruction.PayToDriver, instruction.DriverPenalty)
+	return tx, nil
+}
+
+// instruction works out who pays what for a cancellation at time at
+func (p CancellationPolicy) instruction(tx RideTx, reason CancellationReason, requestedBy string, at time.Time) (PaymentInstruction, error) {
+	switch reason {
+	case RiderCancelled:
+		if requestedBy != tx.RiderUUID {
+			return PaymentInstruction{}, fmt.Errorf("only rider %s can cancel as the rider", tx.RiderUUID)
+		}
+		if tx.PickupConfirmed {
+			return PaymentInstruction{}, fmt.Errorf("ride already picked up, rider cannot cancel")
+		}
+		if at.Sub(tx.TimeRequested) <= p.RiderCancelGrace {
+			return PaymentInstruction{RefundToRider: tx.PaidAmount}, nil
+		}
+		fee := min(p.RiderCancelFee, tx.PaidAmount)
+		return PaymentInstruction{RefundToRider: tx.PaidAmount - fee, PayToDriver: fee}, nil
+
+	case DriverCancelled:
+		if requestedBy != tx.DriverUUID {
+			return PaymentInstruction{}, fmt.Errorf("only driver %s can cancel as the driver", tx.DriverUUID)
+		}
+		if tx.PickupConfirmed {
+			return PaymentInstruction{}, fmt.Errorf("ride already picked up, driver cannot cancel")
+		}
+		return PaymentInstruction{RefundToRider: tx.PaidAmount, DriverPenalty: p.DriverCancelPenalty}, nil
+
+	case RiderNoShow:
+		if requestedBy != tx.DriverUUID {
+			return PaymentInstruction{}, fmt.Errorf("only driver %s can report a no-show", tx.DriverUUID)
+		}
+		if tx.PickupConfirmed {
+			return PaymentInstruction{}, fmt.Errorf("rider was picked up, not a no-show")
+		}
+		if tx.EstimatedPickup.IsZero() || at.Before(tx.EstimatedPickup.Add(p.NoShowWait)) {
+			return PaymentInstruction{}, fmt.Errorf("driver must wait %s after the estimated pickup to report a no-show", p.NoShowWait)
+		}
+		fee := min(p.NoShowFee, tx.PaidAmount)
+		return PaymentInstruction{RefundToRider: tx.PaidAmount - fee, PayToDriver: fee}, nil
+	}
+	return PaymentInstruction{}, fmt.Errorf("unknown cancellation reason %q", reason)
+}
+
+// penalizeDriver debits up to amount tokens from the driver's balance
+func (rc *RideChain) penalizeDriver(driverUUID string, amount int) error {
+	rc.TokenLedger.mu.Lock()
+	penalty := min(amount, rc.TokenLedger.Balances[driverUUID])
+	rc.TokenLedger.Balances[driverUUID] -= penalty
+	rc.TokenLedger.mu.Unlock()
+
+	if penalty == 0 {
+		return nil
+	}
+	return rc.TokenLedger.SaveToFile()
+}
+
+// Cancelled reports whether the ride was cancelled instead of completed
+func (tx RideTx) Cancelled() bool {
+	return tx.Cancellation != nil
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideChain_CancelRide(t *testing.T) {
+	driver := "driver-cancel"
+	rider := "rider-cancel"
+
+	tests := []struct {
+		name        string
+		prepare     func(tx *RideTx)
+		pickedUp    bool
+		reason      CancellationReason
+		requestedBy string
+		forge       bool
+		want        PaymentInstruction
+		wantErr     error
+		wantBalance int
+	}{
+		{
+			name:        "rider cancels within the grace period for free",
+			prepare:     func(tx *RideTx) { tx.TimeRequested = time.Now() },
+			reason:      RiderCancelled,
+			requestedBy: rider,
+			want:        PaymentInstruction{RefundToRider: 2000},
+			wantBalance: 5,
+		},
+		{
+			name:        "rider cancels after the grace period and pays the fee",
+			prepare:     func(tx *RideTx) { tx.TimeRequested = time.Now().Add(-10 * time.Minute) },
+			reason:      RiderCancelled,
+			requestedBy: rider,
+			want:        PaymentInstruction{RefundToRider: 1500, PayToDriver: 500},
+			wantBalance: 5,
+		},
+		{
+			name:        "driver cannot cancel on the rider's behalf",
+			reason:      RiderCancelled,
+			requestedBy: driver,
+			wantErr:     errors.New("only rider rider-cancel can cancel as the rider"),
+			wantBalance: 5,
+		},
+		{
+			name:        "rider's cancellation signed by someone else",
+			reason:      RiderCancelled,
+			requestedBy: rider,
+			forge:       true,
+			wantErr:     errors.New("invalid cancellation signature from rider-cancel"),
+			wantBalance: 5,
+		},
+		{
+			name:        "driver cancels and is penalized",
+			reason:      DriverCancelled,
+			requestedBy: driver,
+			want:        PaymentInstruction{RefundToRider: 2000, DriverPenalty: 1},
+			wantBalance: 4,
+		},
+		{
+			name:        "driver cannot cancel once the rider is picked up",
+			pickedUp:    true,
+			reason:      DriverCancelled,
+			requestedBy: driver,
+			wantErr:     errors.New("ride already picked up, driver cannot cancel"),
+			wantBalance: 5,
+		},
+		{
+			name:        "no-show reported before the driver waited long enough",
+			prepare:     func(tx *RideTx) { tx.EstimatedPickup = time.Now().Add(-time.Minute) },
+			reason:      RiderNoShow,
+			requestedBy: driver,
+			wantErr:     errors.New("driver must wait 5m0s after the estimated pickup to report a no-show"),
+			wantBalance: 5,
+		},
+		{
+			name:        "no-show after waiting pays the driver the no-show fee",
+			prepare:     func(tx *RideTx) { tx.EstimatedPickup = time.Now().Add(-10 * time.Minute) },
+			reason:      RiderNoShow,
+			requestedBy: driver,
+			want:        PaymentInstruction{RefundToRider: 1500, PayToDriver: 500},
+			wantBalance: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			rc.TokenLedger.Mint(driver, 5)
+
+			tx := testRideTx(driver, rider)
+			tx.ComputedRoute.TravelMiles = 10
+			tx.ComputedRoute.TravelTime = 12
+			tx.PaidAmount = 2000
+			if tt.prepare != nil {
+				tt.prepare(&tx)
+			}
+			onboardTestDriver(t, rc, driver)
+			assert.Nil(t, rc.BecomeValidator("genesis-123"))
+			keys := registerTestKeys(t, rc, driver, rider)
+			tx, err = rc.SubmitPendingRideTx(tx)
+			assert.Nil(t, err)
+			if tt.pickedUp {
+				assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+				tx = rc.PendingRideTxs[driver]
+			}
+
+			key := keys[tt.requestedBy]
+			if tt.forge {
+				_, key, _ = GenerateKeyPair()
+			}
+			signature := ed25519.Sign(key, CancellationSigningBytes(tx, tt.reason, tt.requestedBy))
+			cancelled, err := rc.CancelRide(driver, tt.reason, tt.requestedBy, signature)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				assert.Contains(t, rc.PendingRideTxs, driver)
+				assert.Equal(t, tt.wantBalance, rc.TokenLedger.Balances[driver])
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, cancelled.Cancelled())
+			assert.Equal(t, tt.want, cancelled.Cancellation.Instruction)
+			assert.Equal(t, RideCancelled, cancelled.RideTxEvts[len(cancelled.RideTxEvts)-1].EventType)
+			assert.NotNil(t, rc.SubmitPickupProof(cancelled, cancelled.PickupCode, cancelled.PickupLocation), "a cancelled ride cannot be picked up")
+			assert.Equal(t, 5, rc.TokenLedger.Balances[driver], "nothing is charged before validators approve")
+
+			txID, err := rc.ApproveRideTx(cancelled, "genesis-123")
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantBalance, rc.TokenLedger.Balances[driver])
+			assert.False(t, rc.HasActiveRide(driver))
+			committed, err := rc.RideTx(txID)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.reason, committed.Cancellation.Reason)
+		})
+	}
+}
+
+// registerTestKeys registers a fresh key for each uuid and returns the private keys
+func registerTestKeys(t *testing.T, rc *RideChain, uuids ...string) map[string]ed25519.PrivateKey {
+	t.Helper()
+	keys := make(map[string]ed25519.PrivateKey)
+	for _, uuid := range uuids {
+		pub, key, err := GenerateKeyPair()
+		assert.Nil(t, err)
+		assert.Nil(t, rc.RegisterPublicKey(uuid, pub))
+		keys[uuid] = key
+	}
+	return keys
+}
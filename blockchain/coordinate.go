@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// coordinateScale stores degrees as integer microdegrees, about 11cm at the equator,
+// so a location always encodes and hashes the same way on every node
+const coordinateScale = 1_000_000
+
+// Coordinate is a latitude or longitude in fixed-precision microdegrees
+// it is encoded in JSON as a decimal string like "-86.7816" for older clients
+// and decodes from either a string or a number
+type Coordinate int64
+
+// NewCoordinate rounds degrees to the nearest microdegree
+func NewCoordinate(degrees float64) Coordinate {
+	return Coordinate(math.Round(degrees * coordinateScale))
+}
+
+// ParseCoordinate parses a decimal degrees string, an empty string is the zero coordinate
+func ParseCoordinate(s string) (Coordinate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	degrees, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(degrees) || math.IsInf(degrees, 0) {
+		return 0, fmt.Errorf("invalid coordinate %q", s)
+	}
+	return NewCoordinate(degrees), nil
+}
+
+func (c Coordinate) Degrees() float64 {
+	return float64(c) / coordinateScale
+}
+
+// String formats the coordinate with trailing zeros trimmed, e.g. "36.1627"
+func (c Coordinate) String() string {
+	sign := ""
+	micro := int64(c)
+	if micro < 0 {
+		sign = "-"
+		micro = -micro
+	}
+	whole, frac := micro/coordinateScale, micro%coordinateScale
+	if frac == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%06d", sign, whole, frac), "0")
+}
+
+func (c Coordinate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *Coordinate) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid coordinate %s", data)
+		}
+		s = n.String()
+	}
+	parsed, err := ParseCoordinate(s)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+type LatLng struct {
+	Lat Coordinate `json:"lat"`
+	Lng Coordinate `json:"lng"`
+}
+
+// NewLatLng builds a location from decimal degrees, call Validate before trusting it
+func NewLatLng(lat, lng float64) LatLng {
+	return LatLng{Lat: NewCoordinate(lat), Lng: NewCoordinate(lng)}
+}
+
+// IsZero reports whether the location was never set
+func (l LatLng) IsZero() bool {
+	return l.Lat == 0 && l.Lng == 0
+}
+
+// Validate range checks the latitude and longitude
+func (l LatLng) Validate() error {
+	if l.Lat < -90*coordinateScale || l.Lat > 90*coordinateScale {
+		return fmt.Errorf("latitude %s out of range", l.Lat)
+	}
+	if l.Lng < -180*coordinateScale || l.Lng > 180*coordinateScale {
+		return fmt.Errorf("longitude %s out of range", l.Lng)
+	}
+	return nil
+}
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371000
+
+// DistanceMeters is the great-circle distance between two locations
+func (l LatLng) DistanceMeters(o LatLng) float64 {
+	lat1, lat2 := l.Lat.Degrees()*math.Pi/180, o.Lat.Degrees()*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (o.Lng.Degrees() - l.Lng.Degrees()) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func (l LatLng) String() string {
+	return l.Lat.String() + "," + l.Lng.String()
+}
+
+func (l *LatLng) UnmarshalJSON(data []byte) error {
+	type latLng LatLng
+	var decoded latLng
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if err := LatLng(decoded).Validate(); err != nil {
+		return err
+	}
+	*l = LatLng(decoded)
+	return nil
+}
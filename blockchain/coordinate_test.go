@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatLng_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    LatLng
+		wantErr bool
+	}{
+		{
+			name: "legacy string encoding",
+			data: `{"lat":"36.1627","lng":"-86.7816"}`,
+			want: NewLatLng(36.1627, -86.7816),
+		},
+		{
+			name: "numbers",
+			data: `{"lat":36.1627,"lng":-86.7816}`,
+			want: NewLatLng(36.1627, -86.7816),
+		},
+		{
+			name: "extra precision is rounded to microdegrees",
+			data: `{"lat":"36.16270049","lng":"-86.78160051"}`,
+			want: LatLng{Lat: 36162700, Lng: -86781601},
+		},
+		{
+			name: "empty strings from unset locations",
+			data: `{"lat":"","lng":""}`,
+			want: LatLng{},
+		},
+		{
+			name:    "latitude out of range",
+			data:    `{"lat":"96.1627","lng":"-86.7816"}`,
+			wantErr: true,
+		},
+		{
+			name:    "longitude out of range",
+			data:    `{"lat":36.1627,"lng":-186.7816}`,
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			data:    `{"lat":"north","lng":"-86.7816"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got LatLng
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLatLng_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(NewLatLng(36.1627, -86.7816))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"lat":"36.1627","lng":"-86.7816"}`, string(data))
+
+	// the same location always hashes the same however it was written
+	var fromNumbers LatLng
+	assert.Nil(t, json.Unmarshal([]byte(`{"lat":36.16270000001,"lng":-86.7816}`), &fromNumbers))
+	again, err := json.Marshal(fromNumbers)
+	assert.Nil(t, err)
+	assert.Equal(t, data, again)
+
+	for _, c := range []Coordinate{0, 1, -1, 90000000, -180000000, 500000} {
+		parsed, err := ParseCoordinate(c.String())
+		assert.Nil(t, err)
+		assert.Equal(t, c, parsed)
+	}
+}
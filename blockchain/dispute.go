@@ -0,0 +1,374 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type DisputeStatus string
+
+const (
+	DisputeOpen     DisputeStatus = "open"
+	DisputeResolved DisputeStatus = "resolved"
+)
+
+type DisputeOutcome string
+
+const (
+	OutcomeDismissed     DisputeOutcome = "dismissed"
+	OutcomeRefund        DisputeOutcome = "refund"
+	OutcomePartialRefund DisputeOutcome = "partialRefund"
+	OutcomeDriverPenalty DisputeOutcome = "driverPenalty"
+)
+
+type Evidence struct {
+	SubmittedBy string    `json:"submittedBy"`
+	Description string    `json:"description"`
+	URI         string    `json:"uri"` // photo, receipt or trace stored off-chain
+	Timestamp   time.Time `json:"timestamp"`
+	Signature   []byte    `json:"signature"` // SubmittedBy's signature of EvidenceSigningBytes
+}
+
+type DisputeVote struct {
+	Validator string         `json:"validator"`
+	Outcome   DisputeOutcome `json:"outcome"`
+	Amount    int            `json:"amount"` // cents for a partial refund, tokens for a driver penalty
+	Timestamp time.Time      `json:"timestamp"`
+	Signature []byte         `json:"signature"` // Validator's signature of DisputeVoteSigningBytes
+}
+
+// Dispute is raised by a rider or driver against a committed or pending RideTx
+// and decided by a stake weighted random panel of validators, every change is
+// committed in a block as a RecordDispute so all nodes hold the same panel and votes
+type Dispute struct {
+	ID         string                 `json:"id"`
+	TxID       string                 `json:"txID"` // empty while the ride is still pending
+	DriverUUID string                 `json:"driverUUID"`
+	RiderUUID  string                 `json:"riderUUID"`
+	PaidAmount int                    `json:"paidAmount"`
+	OpenedBy   string                 `json:"openedBy"`
+	Reason     string                 `json:"reason"`
+	Signature  []byte                 `json:"signature"` // OpenedBy's signature of DisputeSigningBytes
+	OpenedAt   time.Time              `json:"openedAt"`
+	Status     DisputeStatus          `json:"status"`
+	Evidence   []Evidence             `json:"evidence"`
+	Panel      []string               `json:"panel"`
+	PanelHead  string                 `json:"panelHead"` // chain head the panel was drawn at
+	Votes      map[string]DisputeVote `json:"votes"`     // validatorUUID -> vote
+	Outcome    DisputeOutcome         `json:"outcome"`
+	Amount     int                    `json:"amount"`
+	ResolvedAt time.Time              `json:"resolvedAt"`
+}
+
+// DisputeSigningBytes is what the rider or driver signs to open a dispute, tx is the committed
+// ride or, before it is committed, the driver's pending ride
+func DisputeSigningBytes(tx RideTx, openedBy, reason string) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("dispute|%s|%s|%s|%d|%s|%s",
+		tx.TxID, tx.DriverUUID, tx.RiderUUID, tx.TimeRequested.UnixNano(), openedBy, reason)))
+	return digest[:]
+}
+
+// EvidenceSigningBytes is what the rider or driver signs to submit evidence
+func EvidenceSigningBytes(disputeID, submittedBy, description, uri string) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("evidence|%s|%s|%s|%s", disputeID, submittedBy, description, uri)))
+	return digest[:]
+}
+
+// DisputeVoteSigningBytes is what a panel member signs to vote
+func DisputeVoteSigningBytes(disputeID, validatorUUID string, outcome DisputeOutcome, amount int) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("vote|%s|%s|%s|%d", disputeID, validatorUUID, outcome, amount)))
+	return digest[:]
+}
+
+// OpenDispute starts a dispute on a committed ride by TxID or on the driver's pending ride,
+// openedBy signs DisputeSigningBytes of that ride with their registered key
+func (rc *RideChain) OpenDispute(tx RideTx, openedBy string, reason string, signature []byte) (*Dispute, error) {
+	if committed, ok := rc.RideLedger[tx.TxID]; ok && tx.TxID != "" {
+		tx = committed
+	} else if pending, ok := rc.PendingRideTxs[tx.DriverUUID]; ok {
+		tx = pending
+	} else {
+		return nil, fmt.Errorf("rideTx %v not found", tx.TxID)
+	}
+
+	if openedBy != tx.RiderUUID && openedBy != tx.DriverUUID {
+		return nil, fmt.Errorf("%s is not a party to the ride", openedBy)
+	}
+	if d := rc.openDisputeFor(tx); d != nil {
+		return nil, fmt.Errorf("dispute %s is already open for this ride", d.ID)
+	}
+	if err := rc.verifyAccountSignature(openedBy, DisputeSigningBytes(tx, openedBy, reason), signature); err != nil {
+		return nil, err
+	}
+	for _, d := range rc.Disputes {
+		if string(d.Signature) == string(signature) {
+			return nil, fmt.Errorf("dispute %s was already opened with this signature", d.ID)
+		}
+	}
+
+	openedAt := now()
+	d := &Dispute{
+		ID:         disputeID(tx, openedBy, openedAt),
+		TxID:       tx.TxID,
+		DriverUUID: tx.DriverUUID,
+		RiderUUID:  tx.RiderUUID,
+		PaidAmount: tx.PaidAmount,
+		OpenedBy:   openedBy,
+		Reason:     reason,
+		Signature:  signature,
+		OpenedAt:   openedAt,
+		Status:     DisputeOpen,
+		PanelHead:  rc.Chain.Head,
+		Votes:      make(map[string]DisputeVote),
+	}
+
+	panel, err := rc.drawPanel(d)
+	if err != nil {
+		return nil, err
+	}
+	d.Panel = panel
+	if err := rc.queueRecord(RecordDispute, d.ID, d); err != nil {
+		return nil, err
+	}
+	rc.Disputes[d.ID] = d
+
+	fmt.Printf("Dispute %s opened by %s, panel %v\n", d.ID, openedBy, panel)
+	return d, rc.flushRecords()
+}
+
+// disputeID derives the ID from the ride and who opened it when, so the panel draw
+// can be recomputed from the dispute record alone
+func disputeID(tx RideTx, openedBy string, openedAt time.Time) string {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%s|%d",
+		tx.TxID, tx.DriverUUID, tx.RiderUUID, tx.StripeSessionId, openedBy, openedAt.UnixNano())))
+	return hex.EncodeToString(digest[:16])
+}
+
+// openDisputeFor returns the unresolved dispute on tx if there is one
+func (rc *RideChain) openDisputeFor(tx RideTx) *Dispute {
+	for _, d := range rc.Disputes {
+		if d.Status != DisputeOpen {
+			continue
+		}
+		if tx.TxID != "" && d.TxID == tx.TxID {
+			return d
+		}
+		if tx.TxID == "" && d.TxID == "" && d.DriverUUID == tx.DriverUUID && d.RiderUUID == tx.RiderUUID {
+			return d
+		}
+	}
+	return nil
+}
+
+// drawPanel picks validators at random weighted by stake, excluding the ride's parties
+// the draw is seeded from the dispute ID and PanelHead so it can be audited, the panel
+// itself is committed with the dispute so every node holds the same one
+func (rc *RideChain) drawPanel(d *Dispute) ([]string, error) {
+	var candidates []string
+	for validator := range rc.Validators {
+		if validator != d.DriverUUID && validator != d.RiderUUID {
+			candidates = append(candidates, validator)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no validators available to hear dispute %s", d.ID)
+	}
+	sort.Strings(candidates)
+
+	seed := sha256.Sum256([]byte(d.ID + d.PanelHead))
+	rng := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:8]))))
+
+	size := min(rc.DisputePanelSize, len(candidates))
+	var panel []string
+	for len(panel) < size {
+		// every validator gets at least one ticket so unstaked genesis validators can serve
+		total := 0
+		weights := make([]int, len(candidates))
+		for i, c := range candidates {
+			weights[i] = max(rc.TokenLedger.GetStake(c)+rc.TokenLedger.DelegatedStake(c), 1)
+			total += weights[i]
+		}
+		pick := rng.Intn(total)
+		for i, w := range weights {
+			if pick < w {
+				panel = append(panel, candidates[i])
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+			pick -= w
+		}
+	}
+	return panel, nil
+}
+
+// SubmitEvidence adds evidence from the rider or driver while the dispute is open,
+// submittedBy signs EvidenceSigningBytes with their registered key
+func (rc *RideChain) SubmitEvidence(disputeID, submittedBy, description, uri string, signature []byte) error {
+	d, err := rc.openDispute(disputeID)
+	if err != nil {
+		return err
+	}
+	if submittedBy != d.RiderUUID && submittedBy != d.DriverUUID {
+		return fmt.Errorf("%s is not a party to dispute %s", submittedBy, disputeID)
+	}
+	if err := rc.verifyAccountSignature(submittedBy, EvidenceSigningBytes(disputeID, submittedBy, description, uri), signature); err != nil {
+		return err
+	}
+	for _, e := range d.Evidence {
+		if string(e.Signature) == string(signature) {
+			return fmt.Errorf("evidence already submitted to dispute %s", disputeID)
+		}
+	}
+	d.Evidence = append(d.Evidence, Evidence{
+		SubmittedBy: submittedBy,
+		Description: description,
+		URI:         uri,
+		Timestamp:   now(),
+		Signature:   signature,
+	})
+	if err := rc.queueRecord(RecordDispute, d.ID, d); err != nil {
+		return err
+	}
+	return rc.flushRecords()
+}
+
+// VoteDispute records a panel member's vote and resolves the dispute once a majority of
+// the panel agrees or every member has voted, the member signs DisputeVoteSigningBytes
+func (rc *RideChain) VoteDispute(disputeID, validatorUUID string, outcome DisputeOutcome, amount int, signature []byte) error {
+	d, err := rc.openDispute(disputeID)
+	if err != nil {
+		return err
+	}
+	if !contains(d.Panel, validatorUUID) {
+		return fmt.Errorf("%s is not on the panel for dispute %s", validatorUUID, disputeID)
+	}
+	if _, voted := d.Votes[validatorUUID]; voted {
+		return fmt.Errorf("%s already voted on dispute %s", validatorUUID, disputeID)
+	}
+	switch outcome {
+	case OutcomeDismissed, OutcomeRefund:
+		amount = 0
+	case OutcomePartialRefund:
+		if amount <= 0 || amount > d.PaidAmount {
+			return fmt.Errorf("partial refund must be between 1 and %d", d.PaidAmount)
+		}
+	case OutcomeDriverPenalty:
+		if amount <= 0 {
+			return fmt.Errorf("driver penalty must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown dispute outcome %q", outcome)
+	}
+
+	if err := rc.verifyAccountSignature(validatorUUID, DisputeVoteSigningBytes(disputeID, validatorUUID, outcome, amount), signature); err != nil {
+		return err
+	}
+
+	d.Votes[validatorUUID] = DisputeVote{
+		Validator: validatorUUID,
+		Outcome:   outcome,
+		Amount:    amount,
+		Timestamp: now(),
+		Signature: signature,
+	}
+
+	if outcome, amount, decided := d.tally(); decided {
+		if err := rc.resolveDispute(d, outcome, amount); err != nil {
+			// the vote can be cast again once whatever stopped the outcome is sorted out
+			delete(d.Votes, validatorUUID)
+			return err
+		}
+	}
+	if err := rc.queueRecord(RecordDispute, d.ID, d); err != nil {
+		return err
+	}
+	return rc.flushRecords()
+}
+
+// tally returns the majority outcome and the median amount voted for it
+// when every member voted without a majority the dispute is dismissed
+func (d *Dispute) tally() (DisputeOutcome, int, bool) {
+	byOutcome := make(map[DisputeOutcome][]int)
+	for _, v := range d.Votes {
+		byOutcome[v.Outcome] = append(byOutcome[v.Outcome], v.Amount)
+	}
+	for outcome, amounts := range byOutcome {
+		if len(amounts)*2 > len(d.Panel) {
+			sort.Ints(amounts)
+			return outcome, amounts[len(amounts)/2], true
+		}
+	}
+	if len(d.Votes) == len(d.Panel) {
+		return OutcomeDismissed, 0, true
+	}
+	return "", 0, false
+}
+
+// resolveDispute executes the outcome, penalties are taken from the driver's tokens and refunds
+// debit the driver's fiat Earnings, which may go negative, and return the fare through the payment adapter
+func (rc *RideChain) resolveDispute(d *Dispute, outcome DisputeOutcome, amount int) error {
+	switch outcome {
+	case OutcomeRefund:
+		amount = d.PaidAmount
+		fallthrough
+	case OutcomePartialRefund:
+		if err := rc.refundDispute(d, amount); err != nil {
+			return err
+		}
+	case OutcomeDriverPenalty:
+		if err := rc.penalizeDriver(d.DriverUUID, amount); err != nil {
+			return err
+		}
+	}
+
+	d.Status = DisputeResolved
+	d.Outcome = outcome
+	d.Amount = amount
+	d.ResolvedAt = now()
+
+	fmt.Printf("Dispute %s resolved: %s %d\n", d.ID, outcome, amount)
+	return nil
+}
+
+// adoptDispute takes a dispute committed by a block unless this node's copy is further along,
+// a driver penalty decided elsewhere is debited here too
+func (rc *RideChain) adoptDispute(d *Dispute) {
+	if local, ok := rc.Disputes[d.ID]; ok {
+		behind := local.Status == DisputeOpen &&
+			(d.Status == DisputeResolved || len(d.Votes)+len(d.Evidence) > len(local.Votes)+len(local.Evidence))
+		if !behind {
+			return
+		}
+	}
+	rc.Disputes[d.ID] = d
+	if d.Status == DisputeResolved && d.Outcome == OutcomeDriverPenalty {
+		if err := rc.penalizeDriver(d.DriverUUID, d.Amount); err != nil {
+			fmt.Printf("Dispute %s penalty not applied: %v\n", d.ID, err)
+		}
+	}
+}
+
+func (rc *RideChain) openDispute(disputeID string) (*Dispute, error) {
+	d, ok := rc.Disputes[disputeID]
+	if !ok {
+		return nil, fmt.Errorf("dispute %s not found", disputeID)
+	}
+	if d.Status != DisputeOpen {
+		return nil, fmt.Errorf("dispute %s is already %s", disputeID, d.Status)
+	}
+	return d, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
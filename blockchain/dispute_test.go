@@ -0,0 +1,288 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newDisputeChain commits one ride from driver and adds four staked validators to hear disputes,
+// the returned keys are registered for the driver, the rider and the validators
+func newDisputeChain(t *testing.T, driver, rider string) (*RideChain, string, map[string]ed25519.PrivateKey) {
+	t.Helper()
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	validators := make([]string, 0, 4)
+	for i := 1; i <= 4; i++ {
+		validator := fmt.Sprintf("validator-%d", i)
+		rc.TokenLedger.Mint(validator, 10*i)
+		assert.Nil(t, rc.StakeTokens(10*i, validator))
+		assert.Nil(t, rc.BecomeValidator(validator))
+		validators = append(validators, validator)
+	}
+	keys := registerTestKeys(t, rc, append(validators, driver, rider)...)
+	rc.TokenLedger.Mint(driver, 1000)
+	txID := completeTestRide(t, rc, testRideTx(driver, rider), driver)
+	return rc, txID, keys
+}
+
+// openTestDispute signs the dispute on the committed ride txID, or on driver's pending ride
+func openTestDispute(t *testing.T, rc *RideChain, keys map[string]ed25519.PrivateKey, txID, driver, openedBy, reason string) (*Dispute, error) {
+	t.Helper()
+	tx, ok := rc.PendingRideTxs[driver]
+	if txID != "" {
+		var err error
+		tx, err = rc.RideTx(txID)
+		assert.Nil(t, err)
+	} else {
+		assert.True(t, ok)
+	}
+	return rc.OpenDispute(RideTx{TxID: txID, DriverUUID: driver}, openedBy, reason, ed25519.Sign(testKey(t, keys, openedBy), DisputeSigningBytes(tx, openedBy, reason)))
+}
+
+func submitTestEvidence(t *testing.T, rc *RideChain, keys map[string]ed25519.PrivateKey, disputeID, submittedBy, description, uri string) error {
+	t.Helper()
+	return rc.SubmitEvidence(disputeID, submittedBy, description, uri, ed25519.Sign(testKey(t, keys, submittedBy), EvidenceSigningBytes(disputeID, submittedBy, description, uri)))
+}
+
+func voteTestDispute(t *testing.T, rc *RideChain, keys map[string]ed25519.PrivateKey, disputeID, validator string, outcome DisputeOutcome, amount int) error {
+	t.Helper()
+	return rc.VoteDispute(disputeID, validator, outcome, amount, ed25519.Sign(testKey(t, keys, validator), DisputeVoteSigningBytes(disputeID, validator, outcome, amount)))
+}
+
+// testKey returns uuid's registered key, or a key nobody registered for outsiders
+func testKey(t *testing.T, keys map[string]ed25519.PrivateKey, uuid string) ed25519.PrivateKey {
+	t.Helper()
+	if key, ok := keys[uuid]; ok {
+		return key
+	}
+	_, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	return key
+}
+
+func TestRideChain_OpenDispute(t *testing.T) {
+	driver, rider := "driver-dispute", "rider-dispute"
+	rc, txID, keys := newDisputeChain(t, driver, rider)
+
+	_, err := openTestDispute(t, rc, keys, txID, driver, "someone-else", "overcharged")
+	assert.NotNil(t, err)
+
+	d, err := openTestDispute(t, rc, keys, txID, driver, rider, "overcharged")
+	assert.Nil(t, err)
+	assert.Equal(t, txID, d.TxID)
+	assert.Len(t, d.Panel, 3)
+	assert.NotContains(t, d.Panel, driver)
+	assert.Equal(t, rc.Chain.Head, d.PanelHead)
+	redrawn, err := rc.drawPanel(d)
+	assert.Nil(t, err)
+	assert.Equal(t, d.Panel, redrawn, "the draw is reproducible from the dispute record")
+
+	_, err = openTestDispute(t, rc, keys, txID, driver, driver, "rider was rude")
+	assert.NotNil(t, err, "only one open dispute per ride")
+
+	assert.Nil(t, submitTestEvidence(t, rc, keys, d.ID, driver, "gps trace", "https://example.com/trace.json"))
+	assert.Nil(t, submitTestEvidence(t, rc, keys, d.ID, rider, "receipt", "https://example.com/receipt.png"))
+	assert.NotNil(t, submitTestEvidence(t, rc, keys, d.ID, d.Panel[0], "opinion", ""))
+	assert.Len(t, rc.Disputes[d.ID].Evidence, 2)
+
+	// a dispute on a pending ride holds back approval until it is resolved
+	onboardTestDriver(t, rc, driver)
+	tx, err := rc.SubmitPendingRideTx(testRideTx(driver, "rider-pending"))
+	assert.Nil(t, err)
+	pending, err := openTestDispute(t, rc, keys, "", driver, driver, "rider never showed")
+	assert.Nil(t, err)
+	assert.Empty(t, pending.TxID)
+	_, err = rc.ApproveRideTx(tx, driver)
+	assert.NotNil(t, err)
+
+	for _, validator := range pending.Panel[:2] {
+		assert.Nil(t, voteTestDispute(t, rc, keys, pending.ID, validator, OutcomeDismissed, 0))
+	}
+	assert.Equal(t, DisputeResolved, pending.Status)
+	_, err = rc.ApproveRideTx(tx, driver)
+	assert.Nil(t, err)
+}
+
+func TestRideChain_VoteDispute(t *testing.T) {
+	driver, rider := "driver-dispute", "rider-dispute"
+
+	tests := []struct {
+		name            string
+		votes           []DisputeVote
+		wantOutcome     DisputeOutcome
+		wantAmount      int
+		wantDriver      int // driver's tokens, only penalties take from them
+		wantRefund      int // refund authorized to the rider through the payment adapter
+		wantOpenAfter   int // votes after which the dispute is still open
+		wantVoteErrorAt int // index of a vote that should be rejected, -1 for none
+	}{
+		{
+			name: "majority refund returns the fare to the rider",
+			votes: []DisputeVote{
+				{Outcome: OutcomeRefund},
+				{Outcome: OutcomeRefund},
+			},
+			wantOutcome:     OutcomeRefund,
+			wantAmount:      500,
+			wantDriver:      1000,
+			wantRefund:      500,
+			wantOpenAfter:   1,
+			wantVoteErrorAt: -1,
+		},
+		{
+			name: "partial refund uses the median amount of the majority",
+			votes: []DisputeVote{
+				{Outcome: OutcomePartialRefund, Amount: 40},
+				{Outcome: OutcomeDismissed},
+				{Outcome: OutcomePartialRefund, Amount: 20},
+			},
+			wantOutcome:     OutcomePartialRefund,
+			wantAmount:      40,
+			wantDriver:      1000,
+			wantRefund:      40,
+			wantOpenAfter:   2,
+			wantVoteErrorAt: -1,
+		},
+		{
+			name: "driver penalty debits the driver only",
+			votes: []DisputeVote{
+				{Outcome: OutcomeDriverPenalty, Amount: 50},
+				{Outcome: OutcomeDriverPenalty, Amount: 50},
+			},
+			wantOutcome:     OutcomeDriverPenalty,
+			wantAmount:      50,
+			wantDriver:      950,
+			wantOpenAfter:   1,
+			wantVoteErrorAt: -1,
+		},
+		{
+			name: "split panel dismisses the dispute",
+			votes: []DisputeVote{
+				{Outcome: OutcomeRefund},
+				{Outcome: OutcomeDismissed},
+				{Outcome: OutcomeDriverPenalty, Amount: 5},
+			},
+			wantOutcome:     OutcomeDismissed,
+			wantDriver:      1000,
+			wantOpenAfter:   2,
+			wantVoteErrorAt: -1,
+		},
+		{
+			name: "partial refund larger than the fare is rejected",
+			votes: []DisputeVote{
+				{Outcome: OutcomePartialRefund, Amount: 1000},
+				{Outcome: OutcomeRefund},
+				{Outcome: OutcomeRefund},
+			},
+			wantOutcome:     OutcomeRefund,
+			wantAmount:      500,
+			wantDriver:      1000,
+			wantRefund:      500,
+			wantOpenAfter:   2,
+			wantVoteErrorAt: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, txID, keys := newDisputeChain(t, driver, rider)
+			d, err := openTestDispute(t, rc, keys, txID, driver, rider, "took a longer route")
+			assert.Nil(t, err)
+
+			assert.NotNil(t, voteTestDispute(t, rc, keys, d.ID, driver, OutcomeRefund, 0), "parties cannot vote")
+
+			for i, vote := range tt.votes {
+				err := voteTestDispute(t, rc, keys, d.ID, d.Panel[min(i, len(d.Panel)-1)], vote.Outcome, vote.Amount)
+				if i == tt.wantVoteErrorAt {
+					assert.NotNil(t, err)
+					continue
+				}
+				assert.Nil(t, err)
+				if i < tt.wantOpenAfter {
+					assert.Equal(t, DisputeOpen, d.Status)
+				}
+			}
+
+			assert.Equal(t, DisputeResolved, d.Status)
+			assert.Equal(t, tt.wantOutcome, d.Outcome)
+			assert.Equal(t, tt.wantAmount, d.Amount)
+			assert.Equal(t, tt.wantDriver, rc.TokenLedger.Balances[driver])
+			assert.Equal(t, 0, rc.TokenLedger.Balances[rider], "the rider is refunded by card, not in tokens")
+			refunded := 0
+			for _, r := range rc.Refunds {
+				assert.Equal(t, d.ID, r.DisputeID)
+				assert.Equal(t, RefundAuthorized, r.Status)
+				refunded += r.Amount
+			}
+			assert.Equal(t, tt.wantRefund, refunded)
+			assert.Equal(t, -tt.wantRefund, rc.Earnings[driver], "refunds are debited from the driver's fiat earnings")
+			assert.NotNil(t, voteTestDispute(t, rc, keys, d.ID, d.Panel[0], OutcomeRefund, 0), "resolved disputes take no votes")
+		})
+	}
+}
+
+func TestRideChain_Dispute_SignedAndCommitted(t *testing.T) {
+	driver, rider := "driver-dispute", "rider-dispute"
+	rc, txID, keys := newDisputeChain(t, driver, rider)
+	// the driver's node signs as a validator, so dispute records are committed as they arrive
+	assert.Nil(t, rc.SetSigner(driver, keys[driver]))
+	tx, err := rc.RideTx(txID)
+	assert.Nil(t, err)
+
+	forged := ed25519.Sign(keys[driver], DisputeSigningBytes(tx, rider, "overcharged"))
+	_, err = rc.OpenDispute(RideTx{TxID: txID}, rider, "overcharged", forged)
+	assert.NotNil(t, err, "the opener must sign the dispute")
+	_, err = rc.OpenDispute(RideTx{TxID: txID}, rider, "overcharged", nil)
+	assert.NotNil(t, err)
+
+	d, err := openTestDispute(t, rc, keys, txID, driver, rider, "overcharged")
+	assert.Nil(t, err)
+	opened, ok := rc.RecordBlock(RecordDispute, d.ID)
+	assert.True(t, ok, "the dispute is committed in a block")
+
+	assert.NotNil(t, rc.SubmitEvidence(d.ID, driver, "gps trace", "", ed25519.Sign(keys[rider], EvidenceSigningBytes(d.ID, driver, "gps trace", ""))))
+	assert.Nil(t, submitTestEvidence(t, rc, keys, d.ID, driver, "gps trace", ""))
+	assert.NotNil(t, rc.VoteDispute(d.ID, d.Panel[0], OutcomeDriverPenalty, 50, ed25519.Sign(keys[d.Panel[0]], DisputeVoteSigningBytes(d.ID, d.Panel[0], OutcomeDriverPenalty, 60))),
+		"the signature covers the amount")
+	assert.Empty(t, d.Votes)
+	for _, validator := range d.Panel[:2] {
+		assert.Nil(t, voteTestDispute(t, rc, keys, d.ID, validator, OutcomeDriverPenalty, 50))
+	}
+	assert.Equal(t, DisputeResolved, d.Status)
+	resolved, ok := rc.RecordBlock(RecordDispute, d.ID)
+	assert.True(t, ok)
+	assert.NotEqual(t, opened, resolved, "each change to the dispute is committed again")
+
+	// a node that did not hear the dispute adopts it from the blocks and applies the penalty once
+	peer, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	peer.TokenLedger.Mint(driver, 1000)
+	for _, hash := range []string{opened, resolved, resolved} {
+		b, _ := rc.Chain.Get(hash)
+		for _, r := range b.Records {
+			assert.Nil(t, peer.applyRecord(r, hash))
+		}
+	}
+	adopted, ok := peer.Disputes[d.ID]
+	assert.True(t, ok)
+	assert.Equal(t, DisputeResolved, adopted.Status)
+	assert.Equal(t, d.Panel, adopted.Panel)
+	assert.Len(t, adopted.Votes, 2)
+	assert.Len(t, adopted.Evidence, 1)
+	assert.Equal(t, 950, peer.TokenLedger.Balances[driver])
+}
+
+func TestRideChain_DrawPanel_Deterministic(t *testing.T) {
+	rc, _, _ := newDisputeChain(t, "driver-dispute", "rider-dispute")
+	d := &Dispute{ID: "dispute-1", DriverUUID: "driver-dispute", RiderUUID: "rider-dispute"}
+
+	first, err := rc.drawPanel(d)
+	assert.Nil(t, err)
+	second, err := rc.drawPanel(d)
+	assert.Nil(t, err)
+	assert.Equal(t, first, second)
+}
@@ -0,0 +1,196 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type ExpiryReason string
+
+const (
+	ExpiredNoPickup   ExpiryReason = "noPickup"
+	ExpiredNoDropoff  ExpiryReason = "noDropoff"
+	ExpiredNoApproval ExpiryReason = "noApproval"
+)
+
+// ExpiryPolicy sets how long a pending ride may sit in each lifecycle stage
+type ExpiryPolicy struct {
+	// PickupTTL is how long after EstimatedPickup, or TimeRequested, a ride may wait for pickup
+	PickupTTL time.Duration
+	// DropoffTTL is how long after EstimatedDropoff, or pickup, a ride may wait for dropoff
+	DropoffTTL time.Duration
+	// ApprovalTTL is how long after dropoff a ride may wait for validator approval
+	ApprovalTTL time.Duration
+	// VerificationTTL is how long a driver verification request may stay pending, see VerificationPolicy
+	// for how long an approval lasts
+	VerificationTTL time.Duration
+}
+
+func DefaultExpiryPolicy() ExpiryPolicy {
+	return ExpiryPolicy{
+		PickupTTL:       15 * time.Minute,
+		DropoffTTL:      2 * time.Hour,
+		ApprovalTTL:     30 * time.Minute,
+		VerificationTTL: 72 * time.Hour,
+	}
+}
+
+// ExpiryMetrics counts what the sweeper has expired since the node started
+type ExpiryMetrics struct {
+	Rides         map[ExpiryReason]int `json:"rides"`
+	Verifications int                  `json:"verifications"`
+	// Suspensions counts drivers whose approval expired
+	Suspensions int       `json:"suspensions"`
+	Sweeps      int       `json:"sweeps"`
+	LastSweep   time.Time `json:"lastSweep"`
+}
+
+// ExpiryMetrics returns a copy of the sweeper counters
+func (rc *RideChain) ExpiryMetrics() ExpiryMetrics {
+	metrics := rc.expiryMetrics
+	metrics.Rides = make(map[ExpiryReason]int, len(rc.expiryMetrics.Rides))
+	for reason, count := range rc.expiryMetrics.Rides {
+		metrics.Rides[reason] = count
+	}
+	return metrics
+}
+
+// RideExpiry is recorded on-chain with the expired RideTx
+type RideExpiry struct {
+	Reason      ExpiryReason       `json:"reason"`
+	Timestamp   time.Time          `json:"timestamp"`
+	Instruction PaymentInstruction `json:"instruction"`
+}
+
+// Expired reports whether the sweeper ended the ride
+func (tx RideTx) Expired() bool {
+	return tx.Expiry != nil
+}
+
+// expiryInstruction settles an expired ride's payment, a ride that never reached dropoff
+// is refunded, a dropped off ride the validators never approved pays the driver unless
+// it is still waiting on a review
+func expiryInstruction(tx RideTx, reason ExpiryReason) PaymentInstruction {
+	if reason == ExpiredNoApproval && !tx.NeedsReview() {
+		return PaymentInstruction{PayToDriver: tx.PaidAmount}
+	}
+	return PaymentInstruction{RefundToRider: tx.PaidAmount}
+}
+
+// SweepExpired marks stale pending rides expired with a payment instruction and expires
+// stale verification requests. Expired rides are committed like any other ride once
+// ApprovalQuorum validators approve them, a node signing as a validator approves its own sweep
+func (rc *RideChain) SweepExpired() (int, error) {
+	at := now()
+
+	var expired []RideTx
+	for driverUUID, tx := range rc.PendingRideTxs {
+		if tx.Cancelled() || tx.Expired() {
+			continue
+		}
+		reason, ok := rc.ExpiryPolicy.expired(tx, at)
+		if !ok || rc.openDisputeFor(tx) != nil {
+			continue
+		}
+		instruction := expiryInstruction(tx, reason)
+		tx.Expiry = &RideExpiry{Reason: reason, Timestamp: at, Instruction: instruction}
+		tx.RideTxEvts = append(tx.RideTxEvts, RideTxEvt{
+			EventType: Expired,
+			Timestamp: at,
+			Metadata: map[string]interface{}{
+				"reason":        string(reason),
+				"refundToRider": instruction.RefundToRider,
+				"payToDriver":   instruction.PayToDriver,
+			},
+		})
+		expired = append(expired, tx)
+
+		// approvals given to the ride do not carry over to its expiry
+		rc.PendingRideTxs[driverUUID] = tx
+		delete(rc.RideApprovals, driverUUID)
+		delete(rc.PendingTraces, driverUUID)
+		delete(rc.pickupSecrets, driverUUID)
+		if rc.expiryMetrics.Rides == nil {
+			rc.expiryMetrics.Rides = make(map[ExpiryReason]int)
+		}
+		rc.expiryMetrics.Rides[reason]++
+		fmt.Printf("Ride for driver %s expired: %s, refund %d, driver paid %d\n",
+			driverUUID, reason, instruction.RefundToRider, instruction.PayToDriver)
+	}
+
+	for driverUUID, request := range rc.PendingVerifications {
+		if request.Status == VerificationPending && at.Sub(request.Timestamp) > rc.ExpiryPolicy.VerificationTTL {
+			rc.expiryMetrics.Verifications++
+			fmt.Printf("Verification request for driver %s expired\n", driverUUID)
+			if len(request.Results) == 0 && request.VerifiedAt.IsZero() {
+				delete(rc.PendingVerifications, driverUUID)
+				continue
+			}
+			// a stalled re-verification falls back to the last decided outcome
+			request.Attestations = nil
+			request.Status = VerificationRejected
+			if !request.VerifiedAt.IsZero() {
+				request.Status = VerificationApproved
+			}
+		}
+		if request.Status == VerificationApproved && request.Expired(at) {
+			request.Status = VerificationExpired
+			rc.expiryMetrics.Suspensions++
+			fmt.Printf("Driver %s verification expired, suspended until re-verified\n", driverUUID)
+		}
+		rc.PendingVerifications[driverUUID] = request
+	}
+
+	rc.expiryMetrics.Sweeps++
+	rc.expiryMetrics.LastSweep = at
+
+	if !rc.IsValidator(rc.signerUUID) {
+		return len(expired), nil
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].DriverUUID < expired[j].DriverUUID })
+	for _, tx := range expired {
+		if _, err := rc.ApproveRideTx(tx, rc.signerUUID); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+// expired reports whether tx has outlived the TTL of its current stage at time at
+// rides without a timestamp for their stage are left alone
+func (p ExpiryPolicy) expired(tx RideTx, at time.Time) (ExpiryReason, bool) {
+	var since time.Time
+	var ttl time.Duration
+	var reason ExpiryReason
+
+	switch {
+	case tx.DropoffConfirmed:
+		since, ttl, reason = tx.DropoffTime, p.ApprovalTTL, ExpiredNoApproval
+	case tx.PickupConfirmed:
+		since, ttl, reason = tx.EstimatedDropoff, p.DropoffTTL, ExpiredNoDropoff
+		if since.IsZero() {
+			since = eventTime(tx, PickupVerified)
+		}
+	default:
+		since, ttl, reason = tx.EstimatedPickup, p.PickupTTL, ExpiredNoPickup
+		if since.IsZero() {
+			since = tx.TimeRequested
+		}
+	}
+
+	if since.IsZero() || at.Sub(since) <= ttl {
+		return "", false
+	}
+	return reason, true
+}
+
+// eventTime returns when the last event of eventType happened on tx
+func eventTime(tx RideTx, eventType RideTxEventType) time.Time {
+	for i := len(tx.RideTxEvts) - 1; i >= 0; i-- {
+		if tx.RideTxEvts[i].EventType == eventType {
+			return tx.RideTxEvts[i].Timestamp
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,189 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryPolicy_Expired(t *testing.T) {
+	at := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	policy := DefaultExpiryPolicy()
+
+	tests := []struct {
+		name       string
+		tx         RideTx
+		wantReason ExpiryReason
+		wantOK     bool
+	}{
+		{
+			name:   "waiting for pickup within the ttl",
+			tx:     RideTx{EstimatedPickup: at.Add(-10 * time.Minute)},
+			wantOK: false,
+		},
+		{
+			name:       "never picked up after the estimated pickup",
+			tx:         RideTx{EstimatedPickup: at.Add(-20 * time.Minute)},
+			wantReason: ExpiredNoPickup,
+			wantOK:     true,
+		},
+		{
+			name:       "falls back to the request time without an estimated pickup",
+			tx:         RideTx{TimeRequested: at.Add(-time.Hour)},
+			wantReason: ExpiredNoPickup,
+			wantOK:     true,
+		},
+		{
+			name: "picked up but never dropped off",
+			tx: RideTx{
+				PickupConfirmed: true,
+				RideTxEvts:      []RideTxEvt{{EventType: PickupVerified, Timestamp: at.Add(-3 * time.Hour)}},
+			},
+			wantReason: ExpiredNoDropoff,
+			wantOK:     true,
+		},
+		{
+			name: "dropped off and still within the approval ttl",
+			tx: RideTx{
+				PickupConfirmed:  true,
+				DropoffConfirmed: true,
+				DropoffTime:      at.Add(-10 * time.Minute),
+			},
+			wantOK: false,
+		},
+		{
+			name: "dropped off but never approved",
+			tx: RideTx{
+				PickupConfirmed:  true,
+				DropoffConfirmed: true,
+				DropoffTime:      at.Add(-time.Hour),
+			},
+			wantReason: ExpiredNoApproval,
+			wantOK:     true,
+		},
+		{
+			name:   "rides without timestamps are left alone",
+			tx:     RideTx{},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, ok := policy.expired(tt.tx, at)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantReason, reason)
+		})
+	}
+}
+
+func TestRideChain_SweepExpired(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+
+	stale := testRideTx("driver-stale", "rider-stale")
+	stale.EstimatedPickup = time.Now().Add(-time.Hour)
+	onboardTestDriver(t, rc, stale.DriverUUID)
+	_, err = rc.SubmitPendingRideTx(stale)
+	assert.Nil(t, err)
+
+	fresh := testRideTx("driver-fresh", "rider-fresh")
+	fresh.EstimatedPickup = time.Now()
+	onboardTestDriver(t, rc, fresh.DriverUUID)
+	_, err = rc.SubmitPendingRideTx(fresh)
+	assert.Nil(t, err)
+
+	assert.Nil(t, requestTestVerification(t, rc, map[string]ed25519.PrivateKey{}, "driver-new", "genesis-123"))
+	request := rc.PendingVerifications["driver-new"]
+	request.Timestamp = time.Now().Add(-100 * time.Hour)
+	rc.PendingVerifications["driver-new"] = request
+
+	expired, err := rc.SweepExpired()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, expired)
+
+	// a node that does not sign as a validator leaves the expiry to the approval quorum
+	pending := rc.PendingRideTxs["driver-stale"]
+	assert.True(t, pending.Expired())
+	assert.Equal(t, PaymentInstruction{RefundToRider: 500}, pending.Expiry.Instruction)
+	assert.NotNil(t, rc.SubmitPickupProof(pending, pending.PickupCode, pending.PickupLocation))
+	assert.Contains(t, rc.PendingRideTxs, "driver-fresh")
+	assert.NotContains(t, rc.PendingVerifications, "driver-new")
+
+	_, err = rc.ApproveRideTx(pending, "genesis-123")
+	assert.Nil(t, err)
+	assert.NotContains(t, rc.PendingRideTxs, "driver-stale")
+
+	// the expired ride is committed with its reason
+	committed, err := rc.Chain.HeadBlock().RideTxs()
+	assert.Nil(t, err)
+	assert.Len(t, committed, 1)
+	assert.False(t, eventTime(committed[0], Expired).IsZero())
+	assert.Equal(t, ExpiredNoPickup, committed[0].Expiry.Reason)
+	assert.Equal(t, 500, committed[0].Expiry.Instruction.RefundToRider)
+
+	metrics := rc.ExpiryMetrics()
+	assert.Equal(t, 1, metrics.Rides[ExpiredNoPickup])
+	assert.Equal(t, 1, metrics.Verifications)
+	assert.Equal(t, 1, metrics.Sweeps)
+
+	expired, err = rc.SweepExpired()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, expired)
+	assert.Equal(t, 2, rc.ExpiryMetrics().Sweeps)
+}
+
+func TestRideChain_SweepExpired_Signer(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	_, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SetSigner("genesis-123", key))
+	rc.TracePolicy.Action = GeofenceFlag
+
+	unapproved := testRideTx("driver-unapproved", "rider-unapproved")
+	onboardTestDriver(t, rc, unapproved.DriverUUID)
+	unapproved, err = rc.SubmitPendingRideTx(unapproved)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SubmitPickupProof(unapproved, unapproved.PickupCode, unapproved.PickupLocation))
+	assert.Nil(t, rc.SubmitDropoff(unapproved, NewLatLng(36.1584, -86.7760)))
+	tx := rc.PendingRideTxs[unapproved.DriverUUID]
+	tx.DropoffTime = time.Now().Add(-time.Hour)
+	rc.PendingRideTxs[unapproved.DriverUUID] = tx
+
+	expired, err := rc.SweepExpired()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Empty(t, rc.PendingRideTxs, "the signing validator approves its own sweep")
+
+	committed, err := rc.Chain.HeadBlock().RideTxs()
+	assert.Nil(t, err)
+	assert.Len(t, committed, 1)
+	assert.Equal(t, PaymentInstruction{RefundToRider: 500}, committed[0].Expiry.Instruction, "the ride was flagged without a gps trace")
+	assert.Equal(t, "genesis-123", rc.Chain.HeadBlock().Proposer)
+}
+
+func TestExpiryInstruction(t *testing.T) {
+	flagged := RideTx{PaidAmount: 500, RideTxEvts: []RideTxEvt{{EventType: FlaggedForReview}}}
+
+	tests := []struct {
+		name   string
+		tx     RideTx
+		reason ExpiryReason
+		want   PaymentInstruction
+	}{
+		{name: "never picked up", tx: RideTx{PaidAmount: 500}, reason: ExpiredNoPickup, want: PaymentInstruction{RefundToRider: 500}},
+		{name: "never dropped off", tx: RideTx{PaidAmount: 500}, reason: ExpiredNoDropoff, want: PaymentInstruction{RefundToRider: 500}},
+		{name: "dropped off but never approved", tx: RideTx{PaidAmount: 500}, reason: ExpiredNoApproval, want: PaymentInstruction{PayToDriver: 500}},
+		{name: "flagged and never approved", tx: flagged, reason: ExpiredNoApproval, want: PaymentInstruction{RefundToRider: 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, expiryInstruction(tt.tx, tt.reason))
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFareMismatch is returned when a ride's paid amount or quoted price is off from the fare engine
+var ErrFareMismatch = errors.New("fare mismatch")
+
+// FareSchedule prices a ride from its ComputedRoute, amounts are cents
+type FareSchedule struct {
+	BaseFare    int
+	PerMile     int
+	PerMinute   int
+	MinimumFare int
+	// IncludedPassengers ride without a surcharge, each extra passenger pays PassengerSurcharge
+	IncludedPassengers int
+	PassengerSurcharge int
+	// LuggageSurcharge is charged per bag
+	LuggageSurcharge int
+	// MaxSurge caps ComputedRoute.Surge, in percent
+	MaxSurge int
+	// TolerancePercent is how far PaidAmount may be from the computed fare
+	TolerancePercent int
+}
+
+func DefaultFareSchedule() FareSchedule {
+	return FareSchedule{
+		BaseFare:           200,
+		PerMile:            150,
+		PerMinute:          25,
+		MinimumFare:        500,
+		IncludedPassengers: 1,
+		PassengerSurcharge: 100,
+		LuggageSurcharge:   50,
+		MaxSurge:           300,
+		TolerancePercent:   10,
+	}
+}
+
+// Fare is the itemized price of a ride
+type Fare struct {
+	Base       int `json:"base"`
+	Distance   int `json:"distance"`
+	Time       int `json:"time"`
+	Surge      int `json:"surge"` // extra charged on base, distance and time
+	Passengers int `json:"passengers"`
+	Luggage    int `json:"luggage"`
+	Total      int `json:"total"`
+}
+
+// Quote computes the fare for a route in integer cents, so checkFare reproduces the
+// quoted total exactly when it checks PaidAmount
+// surge applies to base, distance and time, surcharges are added after and the
+// minimum fare applies to the total
+func (s FareSchedule) Quote(route ComputedRoute, passengers, luggage int) (Fare, error) {
+	if route.TravelMiles < 0 || route.TravelTime < 0 || passengers < 0 || luggage < 0 {
+		return Fare{}, errors.New("route, passengers and luggage cannot be negative")
+	}
+	surge := route.Surge
+	if surge == 0 {
+		surge = 100
+	}
+	if surge < 100 || surge > s.MaxSurge {
+		return Fare{}, fmt.Errorf("surge %d%% outside 100%%-%d%%", surge, s.MaxSurge)
+	}
+
+	fare := Fare{
+		Base:     s.BaseFare,
+		Distance: route.TravelMiles * s.PerMile,
+		Time:     route.TravelTime * s.PerMinute,
+		Luggage:  luggage * s.LuggageSurcharge,
+	}
+	if passengers > s.IncludedPassengers {
+		fare.Passengers = (passengers - s.IncludedPassengers) * s.PassengerSurcharge
+	}
+
+	metered := fare.Base + fare.Distance + fare.Time
+	// round half up to the cent
+	fare.Surge = (metered*(surge-100) + 50) / 100
+	fare.Total = max(metered+fare.Surge+fare.Passengers+fare.Luggage, s.MinimumFare)
+	return fare, nil
+}
+
+// checkFare rejects a ride whose PaidAmount or quoted Price is outside tolerance of the fare
+func (s FareSchedule) checkFare(tx RideTx) error {
+	fare, err := s.Quote(tx.ComputedRoute, tx.Passengers, tx.Luggage)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFareMismatch, err)
+	}
+	tolerance := fare.Total * s.TolerancePercent / 100
+
+	if diff := tx.PaidAmount - fare.Total; diff > tolerance || -diff > tolerance {
+		return fmt.Errorf("%w: paid %d, fare is %d", ErrFareMismatch, tx.PaidAmount, fare.Total)
+	}
+	if price := tx.ComputedRoute.Price; price != 0 {
+		if diff := price - fare.Total; diff > tolerance || -diff > tolerance {
+			return fmt.Errorf("%w: quoted %d, fare is %d", ErrFareMismatch, price, fare.Total)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFareSchedule_Quote(t *testing.T) {
+	schedule := DefaultFareSchedule()
+
+	tests := []struct {
+		name       string
+		route      ComputedRoute
+		passengers int
+		luggage    int
+		want       Fare
+		wantErr    bool
+	}{
+		{
+			name:  "short hop pays the minimum fare",
+			route: ComputedRoute{TravelMiles: 1, TravelTime: 4},
+			want:  Fare{Base: 200, Distance: 150, Time: 100, Total: 500},
+		},
+		{
+			name:  "metered by miles and minutes",
+			route: ComputedRoute{TravelMiles: 10, TravelTime: 12},
+			want:  Fare{Base: 200, Distance: 1500, Time: 300, Total: 2000},
+		},
+		{
+			name:  "surge multiplies the metered fare",
+			route: ComputedRoute{TravelMiles: 10, TravelTime: 12, Surge: 150},
+			want:  Fare{Base: 200, Distance: 1500, Time: 300, Surge: 1000, Total: 3000},
+		},
+		{
+			name:       "extra passengers and luggage are not surged",
+			route:      ComputedRoute{TravelMiles: 10, TravelTime: 12, Surge: 150},
+			passengers: 3,
+			luggage:    2,
+			want:       Fare{Base: 200, Distance: 1500, Time: 300, Surge: 1000, Passengers: 200, Luggage: 100, Total: 3300},
+		},
+		{
+			name:    "surge above the cap",
+			route:   ComputedRoute{TravelMiles: 10, Surge: 500},
+			wantErr: true,
+		},
+		{
+			name:    "negative miles",
+			route:   ComputedRoute{TravelMiles: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schedule.Quote(tt.route, tt.passengers, tt.luggage)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateRideTx_Fare(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(tx *RideTx)
+		wantErr bool
+	}{
+		{
+			name:    "paid the fare",
+			prepare: func(tx *RideTx) {},
+		},
+		{
+			name:    "paid within tolerance",
+			prepare: func(tx *RideTx) { tx.PaidAmount = 540 },
+		},
+		{
+			name:    "underpaid",
+			prepare: func(tx *RideTx) { tx.PaidAmount = 100 },
+			wantErr: true,
+		},
+		{
+			name: "quoted price far from the fare",
+			prepare: func(tx *RideTx) {
+				tx.ComputedRoute.Price = 5000
+			},
+			wantErr: true,
+		},
+		{
+			name: "long surged ride paid in full",
+			prepare: func(tx *RideTx) {
+				tx.ComputedRoute.TravelMiles = 10
+				tx.ComputedRoute.TravelTime = 12
+				tx.ComputedRoute.Surge = 150
+				tx.ComputedRoute.Price = 3000
+				tx.PaidAmount = 3000
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := testRideTx("driver-fare", "rider-fare")
+			tt.prepare(&tx)
+			err := ValidateRideTx(tx, DefaultFareSchedule())
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrFareMismatch), "got %v", err)
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+}
@@ -0,0 +1,437 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownParent is returned when a block's PrevBlockHash does not match any known block
+	ErrUnknownParent = errors.New("unknown parent block")
+	// ErrDuplicateBlock is returned when a block with the same hash was already added
+	ErrDuplicateBlock = errors.New("block already known")
+	// ErrConflictsFinalized is returned when a block would fork below the latest finalized block
+	ErrConflictsFinalized = errors.New("block conflicts with finalized chain")
+	// ErrUntrustedBlock is returned for peer blocks that are unsigned, signed by an unknown proposer or do not hash to Hash
+	ErrUntrustedBlock = errors.New("untrusted block")
+)
+
+// BlockTree keeps every known block, including the ones on competing forks,
+// so a node can choose the canonical chain with ChooseHead
+type BlockTree struct {
+	mu        sync.RWMutex
+	Blocks    map[string]*Block   // hash -> block
+	Children  map[string][]string // hash -> child hashes
+	Genesis   string              // hash of the genesis block
+	Finalized string              // hash of the latest finalized block
+	Head      string              // hash of the canonical head
+	// Weigh is a block's fork-choice weight, by default one per approving validator
+	Weigh func(b *Block) int `json:"-"`
+}
+
+func NewBlockTree(genesis *Block) *BlockTree {
+	return &BlockTree{
+		Blocks:    map[string]*Block{genesis.Hash: genesis},
+		Children:  make(map[string][]string),
+		Genesis:   genesis.Hash,
+		Finalized: genesis.Hash,
+		Head:      genesis.Hash,
+		Weigh:     func(b *Block) int { return len(b.Validators) },
+	}
+}
+
+// AddBlock links b to its parent through PrevBlockHash
+// forked is true when the parent already had another child, meaning b competes with an existing chain
+func (bt *BlockTree) AddBlock(b *Block) (forked bool, err error) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if _, ok := bt.Blocks[b.Hash]; ok {
+		return false, fmt.Errorf("%w: %s", ErrDuplicateBlock, b.Hash)
+	}
+	parent, ok := bt.Blocks[b.PrevBlockHash]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrUnknownParent, b.PrevBlockHash)
+	}
+	if b.Height != parent.Height+1 {
+		return false, fmt.Errorf("block %s has height %d, expected %d", b.Hash, b.Height, parent.Height+1)
+	}
+	// anything that does not build on the finalized block can never become canonical
+	if !bt.isAncestor(bt.Finalized, parent.Hash) {
+		return false, fmt.Errorf("%w: %s", ErrConflictsFinalized, b.Hash)
+	}
+
+	forked = len(bt.Children[parent.Hash]) > 0
+	bt.Blocks[b.Hash] = b
+	bt.Children[parent.Hash] = append(bt.Children[parent.Hash], b.Hash)
+	return forked, nil
+}
+
+func (bt *BlockTree) Get(hash string) (*Block, bool) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	b, ok := bt.Blocks[hash]
+	return b, ok
+}
+
+// HeadBlock returns the current canonical head
+func (bt *BlockTree) HeadBlock() *Block {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.Blocks[bt.Head]
+}
+
+// Weight is the total fork-choice weight from genesis up to and including hash
+func (bt *BlockTree) Weight(hash string) int {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.weight(hash)
+}
+
+func (bt *BlockTree) weight(hash string) int {
+	weight := 0
+	for b, ok := bt.Blocks[hash]; ok; b, ok = bt.Blocks[b.PrevBlockHash] {
+		weight += bt.Weigh(b)
+	}
+	return weight
+}
+
+// Finalize marks hash, and therefore all of its ancestors, as final
+// a finalized block can never be rolled back by a reorg
+func (bt *BlockTree) Finalize(hash string) error {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if _, ok := bt.Blocks[hash]; !ok {
+		return fmt.Errorf("block %s not found", hash)
+	}
+	if !bt.isAncestor(bt.Finalized, hash) {
+		return fmt.Errorf("%w: %s", ErrConflictsFinalized, hash)
+	}
+	bt.Finalized = hash
+	return nil
+}
+
+// ChooseHead applies the fork-choice rule
+// finalized first: only chains that contain the latest finalized block are eligible,
+// then the heaviest stake-weighted chain wins, then the longest, then the lowest hash
+func (bt *BlockTree) ChooseHead() string {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	best := bt.Finalized
+	for _, leaf := range bt.leaves(bt.Finalized) {
+		if bt.heavier(leaf, best) {
+			best = leaf
+		}
+	}
+	return best
+}
+
+func (bt *BlockTree) heavier(a, b string) bool {
+	wa, wb := bt.weight(a), bt.weight(b)
+	if wa != wb {
+		return wa > wb
+	}
+	ha, hb := bt.Blocks[a].Height, bt.Blocks[b].Height
+	if ha != hb {
+		return ha > hb
+	}
+	return a < b
+}
+
+// leaves returns every chain tip descending from hash
+func (bt *BlockTree) leaves(hash string) []string {
+	children := bt.Children[hash]
+	if len(children) == 0 {
+		return []string{hash}
+	}
+	var leaves []string
+	for _, child := range children {
+		leaves = append(leaves, bt.leaves(child)...)
+	}
+	return leaves
+}
+
+func (bt *BlockTree) isAncestor(ancestor, hash string) bool {
+	for b, ok := bt.Blocks[hash]; ok; b, ok = bt.Blocks[b.PrevBlockHash] {
+		if b.Hash == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// CommonAncestor returns the hash of the most recent block shared by both chains
+func (bt *BlockTree) CommonAncestor(a, b string) string {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+
+	for blk, ok := bt.Blocks[a]; ok; blk, ok = bt.Blocks[blk.PrevBlockHash] {
+		if bt.isAncestor(blk.Hash, b) {
+			return blk.Hash
+		}
+	}
+	return ""
+}
+
+// Path returns the blocks after ancestor up to and including hash, oldest first
+func (bt *BlockTree) Path(ancestor, hash string) []*Block {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.path(ancestor, hash)
+}
+
+func (bt *BlockTree) path(ancestor, hash string) []*Block {
+	var path []*Block
+	for b, ok := bt.Blocks[hash]; ok && b.Hash != ancestor; b, ok = bt.Blocks[b.PrevBlockHash] {
+		path = append([]*Block{b}, path...)
+	}
+	return path
+}
+
+// CanonicalChain returns the blocks from genesis to the current head
+func (bt *BlockTree) CanonicalChain() []*Block {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return append([]*Block{bt.Blocks[bt.Genesis]}, bt.path(bt.Genesis, bt.Head)...)
+}
+
+func (bt *BlockTree) setHead(hash string) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	bt.Head = hash
+}
+
+// ReceiveBlock adds a block from a peer and reorganizes the chain when the fork-choice rule picks a new head
+// the block must hash to its Hash, be signed by a proposer whose key this node knows and be
+// timestamped within MaxBlockClockSkew of when it arrives
+func (rc *RideChain) ReceiveBlock(b *Block) error {
+	return rc.receiveBlock(b, true)
+}
+
+// receiveBlock adds a peer block, blocks a syncing node catches up on are older than the
+// clock skew so only live blocks are held to it, every block must be timestamped after its parent
+func (rc *RideChain) receiveBlock(b *Block, live bool) error {
+	if hash := NewProof(b).hash(b.Nonce); hash != b.Hash {
+		return fmt.Errorf("%w: block %s hashes to %s", ErrUntrustedBlock, b.Hash, hash)
+	}
+	if err := rc.verifyHeader(b.Header()); err != nil {
+		return fmt.Errorf("%w: %v", ErrUntrustedBlock, err)
+	}
+	if err := rc.checkBlockTime(b, live); err != nil {
+		return fmt.Errorf("%w: %v", ErrUntrustedBlock, err)
+	}
+	return rc.addBlock(b)
+}
+
+// checkBlockTime rejects blocks timestamped in the future, before their parent or,
+// for live blocks, further in the past than MaxBlockClockSkew
+func (rc *RideChain) checkBlockTime(b *Block, live bool) error {
+	at := now()
+	if b.Timestamp.After(at.Add(rc.MaxBlockClockSkew)) {
+		return fmt.Errorf("block %s timestamp %s is in the future", b.Hash, b.Timestamp.Format(time.RFC3339))
+	}
+	if live && at.Sub(b.Timestamp) > rc.MaxBlockClockSkew {
+		return fmt.Errorf("block %s timestamp %s is more than %s old", b.Hash, b.Timestamp.Format(time.RFC3339), rc.MaxBlockClockSkew)
+	}
+	if parent, ok := rc.Chain.Get(b.PrevBlockHash); ok && b.Timestamp.Before(parent.Timestamp) {
+		return fmt.Errorf("block %s timestamp %s is before its parent %s", b.Hash, b.Timestamp.Format(time.RFC3339), parent.Hash)
+	}
+	return nil
+}
+
+// blockWeight is the ledger stake, including delegations, of the block's approvers that are validators here
+// approvers the proposer made up or padded with stake add nothing, every validator counts for at least 1
+func (rc *RideChain) blockWeight(b *Block) int {
+	return rc.approverWeight(b.Validators)
+}
+
+func (rc *RideChain) approverWeight(approvers []Validator) int {
+	weight := 0
+	seen := make(map[string]bool)
+	for _, v := range approvers {
+		if seen[v.UUID] || !rc.IsValidator(v.UUID) {
+			continue
+		}
+		seen[v.UUID] = true
+		weight += max(rc.TokenLedger.GetStake(v.UUID)+rc.TokenLedger.DelegatedStake(v.UUID), 1)
+	}
+	return weight
+}
+
+// addBlock adds a block this node produced or replayed from its data directory
+func (rc *RideChain) addBlock(b *Block) error {
+	if err := rc.checkRequirements(b); err != nil {
+		return err
+	}
+	forked, err := rc.Chain.AddBlock(b)
+	if err != nil {
+		return err
+	}
+	if forked {
+		fmt.Printf("Fork detected: block %s competes at height %d on parent %s\n", b.Hash, b.Height, b.PrevBlockHash)
+	}
+	return rc.updateHead()
+}
+
+// updateHead runs fork choice and moves the ledger onto the winning chain
+// blocks leaving the canonical chain are rolled back newest first and
+// their RideTxs return to the mempool unless the new chain also committed them
+func (rc *RideChain) updateHead() error {
+	oldHead := rc.Chain.Head
+	newHead := rc.Chain.ChooseHead()
+	if newHead == oldHead {
+		return nil
+	}
+
+	ancestor := rc.Chain.CommonAncestor(oldHead, newHead)
+	orphaned := rc.Chain.Path(ancestor, oldHead)
+	adopted := rc.Chain.Path(ancestor, newHead)
+
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		if err := rc.rollbackBlock(orphaned[i]); err != nil {
+			return err
+		}
+	}
+	for _, b := range adopted {
+		if err := rc.applyBlock(b); err != nil {
+			return err
+		}
+	}
+	rc.Chain.setHead(newHead)
+
+	if len(orphaned) > 0 {
+		fmt.Printf("Reorg: rolled back %d blocks and applied %d blocks from common ancestor %s\n", len(orphaned), len(adopted), ancestor)
+	}
+
+	for _, b := range orphaned {
+		txs, err := b.RideTxs()
+		if err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			if _, ok := rc.RideLedger[tx.TxID]; ok {
+				continue
+			}
+			rc.returnToMempool(tx)
+		}
+		rc.requeueRecords(b)
+	}
+
+	return rc.finalizeDepth()
+}
+
+// TokenChange is a balance change a block made when it was applied
+type TokenChange struct {
+	UUID   string `json:"uuid"`
+	Amount int    `json:"amount"`
+}
+
+// applyBlock commits the block's RideTxs to the ledger and debits the penalties of its cancelled rides
+func (rc *RideChain) applyBlock(b *Block) error {
+	txs, err := b.RideTxs()
+	if err != nil {
+		return fmt.Errorf("decode block %s: %w", b.Hash, err)
+	}
+	// a block replayed from the data directory already moved the saved token ledger
+	if _, applied := rc.blockTokenChanges[b.Hash]; !applied {
+		rc.blockTokenChanges[b.Hash] = rc.applyTokenChanges(txs)
+	}
+	for _, tx := range txs {
+		rc.RideLedger[tx.TxID] = tx
+		// the ride may still be waiting on approvals locally
+		if pending, ok := rc.PendingRideTxs[tx.DriverUUID]; ok && sameRide(pending, tx) {
+			delete(rc.PendingRideTxs, tx.DriverUUID)
+			delete(rc.RideApprovals, tx.DriverUUID)
+		}
+	}
+	for _, r := range b.Records {
+		if err := rc.applyRecord(r, b.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyTokenChanges debits up to each cancelled ride's driver penalty from the driver's tokens
+func (rc *RideChain) applyTokenChanges(txs []RideTx) []TokenChange {
+	rc.TokenLedger.mu.Lock()
+	defer rc.TokenLedger.mu.Unlock()
+
+	changes := []TokenChange{}
+	for _, tx := range txs {
+		if !tx.Cancelled() || tx.Cancellation.Instruction.DriverPenalty <= 0 {
+			continue
+		}
+		penalty := min(tx.Cancellation.Instruction.DriverPenalty, max(rc.TokenLedger.Balances[tx.DriverUUID], 0))
+		if penalty == 0 {
+			continue
+		}
+		rc.TokenLedger.Balances[tx.DriverUUID] -= penalty
+		changes = append(changes, TokenChange{UUID: tx.DriverUUID, Amount: -penalty})
+	}
+	return changes
+}
+
+// rollbackBlock removes the block's RideTxs from the ledger and undoes its token changes
+func (rc *RideChain) rollbackBlock(b *Block) error {
+	txs, err := b.RideTxs()
+	if err != nil {
+		return fmt.Errorf("decode block %s: %w", b.Hash, err)
+	}
+	rc.TokenLedger.mu.Lock()
+	for _, change := range rc.blockTokenChanges[b.Hash] {
+		rc.TokenLedger.Balances[change.UUID] -= change.Amount
+	}
+	rc.TokenLedger.mu.Unlock()
+	delete(rc.blockTokenChanges, b.Hash)
+
+	for _, tx := range txs {
+		delete(rc.RideLedger, tx.TxID)
+	}
+	for _, r := range b.Records {
+		rc.rollbackRecord(r)
+	}
+	return nil
+}
+
+// returnToMempool puts an orphaned RideTx back up for approval
+// the driver may already have a newer pending ride, in that case the
+// orphan is parked in OrphanedRideTxs so it is not silently dropped
+func (rc *RideChain) returnToMempool(tx RideTx) {
+	tx.TxID = ""
+	if _, ok := rc.PendingRideTxs[tx.DriverUUID]; ok {
+		rc.OrphanedRideTxs = append(rc.OrphanedRideTxs, tx)
+		fmt.Printf("Orphaned rideTx for driver %s parked, driver has another pending ride\n", tx.DriverUUID)
+		return
+	}
+	rc.PendingRideTxs[tx.DriverUUID] = tx
+	fmt.Printf("Orphaned rideTx for driver %s returned to mempool\n", tx.DriverUUID)
+}
+
+// finalizeDepth finalizes the canonical block FinalityDepth blocks below the head
+func (rc *RideChain) finalizeDepth() error {
+	if rc.FinalityDepth <= 0 {
+		return nil
+	}
+	chain := rc.Chain.CanonicalChain()
+	idx := len(chain) - 1 - rc.FinalityDepth
+	if idx <= 0 {
+		return nil
+	}
+	target := chain[idx]
+	if finalized, _ := rc.Chain.Get(rc.Chain.Finalized); finalized != nil && finalized.Height >= target.Height {
+		return nil
+	}
+	return rc.Chain.Finalize(target.Hash)
+}
+
+// sameRide reports whether two RideTxs describe the same ride regardless of TxID
+func sameRide(a, b RideTx) bool {
+	return a.DriverUUID == b.DriverUUID &&
+		a.RiderUUID == b.RiderUUID &&
+		a.StripeSessionId == b.StripeSessionId
+}
@@ -0,0 +1,289 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBlock(t *testing.T, parent *Block, stake int, txs ...RideTx) *Block {
+	t.Helper()
+	b, err := NewRideBlock(parent, txs, []Validator{{UUID: "peer-validator", Stake: stake}})
+	assert.Nil(t, err)
+	return b
+}
+
+func TestBlockTree_ChooseHead(t *testing.T) {
+	genesis := NewGenesisBlock()
+
+	tests := []struct {
+		name    string
+		build   func(bt *BlockTree) (want string)
+		wantErr error
+	}{
+		{
+			name: "heaviest stake-weighted chain beats the longer chain",
+			build: func(bt *BlockTree) string {
+				a1 := testBlock(t, genesis, 1)
+				a2 := testBlock(t, a1, 1)
+				b1 := testBlock(t, genesis, 5)
+				for _, b := range []*Block{a1, a2, b1} {
+					_, err := bt.AddBlock(b)
+					assert.Nil(t, err)
+				}
+				return b1.Hash
+			},
+		},
+		{
+			name: "equal weight falls back to the longest chain",
+			build: func(bt *BlockTree) string {
+				a1 := testBlock(t, genesis, 2)
+				b1 := testBlock(t, genesis, 1)
+				b2 := testBlock(t, b1, 1)
+				for _, b := range []*Block{a1, b1, b2} {
+					_, err := bt.AddBlock(b)
+					assert.Nil(t, err)
+				}
+				return b2.Hash
+			},
+		},
+		{
+			name: "finalized chain wins even when a fork is heavier",
+			build: func(bt *BlockTree) string {
+				a1 := testBlock(t, genesis, 1)
+				b1 := testBlock(t, genesis, 10)
+				for _, b := range []*Block{a1, b1} {
+					_, err := bt.AddBlock(b)
+					assert.Nil(t, err)
+				}
+				assert.Nil(t, bt.Finalize(a1.Hash))
+				return a1.Hash
+			},
+		},
+		{
+			name: "blocks forking below the finalized block are rejected",
+			build: func(bt *BlockTree) string {
+				a1 := testBlock(t, genesis, 1)
+				_, err := bt.AddBlock(a1)
+				assert.Nil(t, err)
+				assert.Nil(t, bt.Finalize(a1.Hash))
+
+				_, err = bt.AddBlock(testBlock(t, genesis, 10))
+				assert.True(t, errors.Is(err, ErrConflictsFinalized))
+				return a1.Hash
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := NewBlockTree(genesis)
+			bt.Weigh = (*Block).ReportedStake
+			want := tt.build(bt)
+			assert.Equal(t, want, bt.ChooseHead())
+		})
+	}
+}
+
+func TestBlockTree_AddBlock(t *testing.T) {
+	genesis := NewGenesisBlock()
+	bt := NewBlockTree(genesis)
+
+	a1 := testBlock(t, genesis, 1)
+	forked, err := bt.AddBlock(a1)
+	assert.Nil(t, err)
+	assert.False(t, forked)
+
+	forked, err = bt.AddBlock(testBlock(t, genesis, 1))
+	assert.Nil(t, err)
+	assert.True(t, forked)
+
+	_, err = bt.AddBlock(a1)
+	assert.True(t, errors.Is(err, ErrDuplicateBlock))
+
+	_, err = bt.AddBlock(testBlock(t, &Block{Hash: "nowhere", Height: 4}, 1))
+	assert.True(t, errors.Is(err, ErrUnknownParent))
+}
+
+// peerBlock is a block a peer validator proposed and signed on top of parent
+func peerBlock(t *testing.T, parent *Block, proposer string, key ed25519.PrivateKey, approvers ...Validator) *Block {
+	t.Helper()
+	b, err := NewRideBlock(parent, nil, approvers)
+	assert.Nil(t, err)
+	b.Sign(proposer, key)
+	return b
+}
+
+func TestRideChain_ReceiveBlock_Reorg(t *testing.T) {
+	driver, peer := "genesis-123", "peer-validator"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	rc.TokenLedger.Mint(peer, 10)
+	assert.Nil(t, rc.StakeTokens(10, peer))
+	assert.Nil(t, rc.BecomeValidator(peer))
+	pub, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey(peer, pub))
+
+	genesis := rc.Chain.HeadBlock()
+	txID := completeTestRide(t, rc, testRideTx(driver, "rider-reorg"), driver)
+	_, ok := rc.RideLedger[txID]
+	assert.True(t, ok)
+	assert.Empty(t, rc.PendingRideTxs)
+
+	// a heavier competing chain that never saw the ride arrives from a peer
+	heavier := peerBlock(t, genesis, peer, key, Validator{UUID: peer, Stake: 10})
+	assert.Nil(t, rc.ReceiveBlock(heavier))
+
+	assert.Equal(t, heavier.Hash, rc.Chain.Head)
+	_, ok = rc.RideLedger[txID]
+	assert.False(t, ok, "orphaned ride should be rolled back from the ledger")
+
+	pending, ok := rc.PendingRideTxs[driver]
+	assert.True(t, ok, "orphaned ride should return to the mempool")
+	assert.Empty(t, pending.TxID)
+	assert.True(t, pending.DropoffConfirmed)
+
+	// the ride can be approved again on top of the new head
+	txID, err = rc.ApproveRideTx(pending, driver)
+	assert.Nil(t, err)
+	_, ok = rc.RideLedger[txID]
+	assert.True(t, ok)
+	assert.Equal(t, heavier.Hash, rc.Chain.HeadBlock().PrevBlockHash)
+}
+
+func TestRideChain_ReceiveBlock_ReorgRestoresTokens(t *testing.T) {
+	driver, rider, peer := "genesis-123", "rider-reorg", "peer-validator"
+	dir := t.TempDir()
+	rc, err := OpenRideChain(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	rc.TokenLedger.Mint(peer, 10)
+	assert.Nil(t, rc.StakeTokens(10, peer))
+	assert.Nil(t, rc.BecomeValidator(peer))
+	onboardTestDriver(t, rc, driver)
+	keys := registerTestKeys(t, rc, driver, rider)
+	_, peerKey, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	peerPub := peerKey.Public().(ed25519.PublicKey)
+	assert.Nil(t, rc.RegisterPublicKey(peer, peerPub))
+	rc.TokenLedger.Mint(driver, 5)
+
+	genesis := rc.Chain.HeadBlock()
+	tx, err := rc.SubmitPendingRideTx(testRideTx(driver, rider))
+	assert.Nil(t, err)
+	cancelled, err := rc.CancelRide(driver, DriverCancelled, driver, ed25519.Sign(keys[driver], CancellationSigningBytes(tx, DriverCancelled, driver)))
+	assert.Nil(t, err)
+	_, err = rc.ApproveRideTx(cancelled, driver)
+	assert.Nil(t, err)
+	penalized := rc.TokenLedger.Balances[driver]
+	assert.Less(t, penalized, 5)
+
+	// replaying the saved blocks does not charge the penalty twice
+	assert.Nil(t, rc.Save())
+	reopened, err := OpenRideChain(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, penalized, reopened.TokenLedger.Balances[driver])
+
+	// a heavier chain without the cancelled ride gives the penalty back
+	assert.Nil(t, rc.ReceiveBlock(peerBlock(t, genesis, peer, peerKey, Validator{UUID: peer, Stake: 10})))
+	assert.Equal(t, 5, rc.TokenLedger.Balances[driver])
+
+	pending, ok := rc.PendingRideTxs[driver]
+	assert.True(t, ok)
+	_, err = rc.ApproveRideTx(pending, driver)
+	assert.Nil(t, err)
+	assert.Equal(t, penalized, rc.TokenLedger.Balances[driver])
+}
+
+func TestRideChain_ReceiveBlock_Untrusted(t *testing.T) {
+	driver, peer := "genesis-123", "peer-validator"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	pub, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey(peer, pub))
+	_, unknownKey, err := GenerateKeyPair()
+	assert.Nil(t, err)
+
+	genesis := rc.Chain.HeadBlock()
+	completeTestRide(t, rc, testRideTx(driver, "rider-untrusted"), driver)
+	head := rc.Chain.Head
+
+	tests := []struct {
+		name    string
+		block   func() *Block
+		wantErr error
+	}{
+		{
+			name: "unsigned",
+			block: func() *Block {
+				b, err := NewRideBlock(genesis, nil, []Validator{{UUID: driver}})
+				assert.Nil(t, err)
+				return b
+			},
+			wantErr: ErrUntrustedBlock,
+		},
+		{
+			name:    "signed by a proposer with no registered key",
+			block:   func() *Block { return peerBlock(t, genesis, "stranger", unknownKey, Validator{UUID: driver}) },
+			wantErr: ErrUntrustedBlock,
+		},
+		{
+			name: "approvers changed after the block was hashed",
+			block: func() *Block {
+				b := peerBlock(t, genesis, peer, key)
+				b.Validators = []Validator{{UUID: driver, Stake: 1000}}
+				b.Sign(peer, key)
+				return b
+			},
+			wantErr: ErrUntrustedBlock,
+		},
+		{
+			// a validator whose verification lapsed cannot pass as an older block
+			name: "backdated",
+			block: func() *Block {
+				b := peerBlock(t, genesis, peer, key)
+				b.Timestamp = time.Now().Add(-time.Hour)
+				b.seal()
+				b.Sign(peer, key)
+				return b
+			},
+			wantErr: ErrUntrustedBlock,
+		},
+		{
+			name: "future dated",
+			block: func() *Block {
+				b := peerBlock(t, genesis, peer, key)
+				b.Timestamp = time.Now().Add(time.Hour)
+				b.seal()
+				b.Sign(peer, key)
+				return b
+			},
+			wantErr: ErrUntrustedBlock,
+		},
+		{
+			// the peer is not a validator here, its claimed stake weighs nothing
+			name: "claims stake it does not hold",
+			block: func() *Block {
+				return peerBlock(t, genesis, peer, key, Validator{UUID: peer, Stake: 1000}, Validator{UUID: "made-up", Stake: 1000})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rc.ReceiveBlock(tt.block())
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, head, rc.Chain.Head, "no reorg")
+		})
+	}
+}
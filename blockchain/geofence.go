@@ -0,0 +1,129 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOutsideGeofence is returned when a reported position is too far from where the ride says it should be
+var ErrOutsideGeofence = errors.New("outside geofence")
+
+type GeofenceAction string
+
+const (
+	// GeofenceReject refuses the pickup or dropoff
+	GeofenceReject GeofenceAction = "reject"
+	// GeofenceFlag accepts it but records a FlaggedForReview event for validators
+	GeofenceFlag GeofenceAction = "flag"
+)
+
+// GeofencePolicy sets how close the driver has to be at pickup and dropoff
+type GeofencePolicy struct {
+	// PickupRadius is meters allowed between the driver and PickupLocation
+	PickupRadius float64
+	// DropoffRadius is meters allowed between the dropoff and the ComputedRoute destination
+	DropoffRadius float64
+	Action        GeofenceAction
+}
+
+func DefaultGeofencePolicy() GeofencePolicy {
+	return GeofencePolicy{
+		PickupRadius:  200,
+		DropoffRadius: 500,
+		Action:        GeofenceReject,
+	}
+}
+
+// checkGeofence compares got against want for a ride stage
+// rides with no coordinates to compare against are rejected, or flagged under GeofenceFlag
+func (p GeofencePolicy) checkGeofence(tx *RideTx, stage string, want, got LatLng, radius float64) error {
+	if want.IsZero() {
+		reason := fmt.Sprintf("no %s coordinates to check against", stage)
+		if p.Action != GeofenceFlag {
+			return fmt.Errorf("%w: %s", ErrOutsideGeofence, reason)
+		}
+		flagForReview(tx, stage, reason, nil)
+		return nil
+	}
+
+	distance := want.DistanceMeters(got)
+	if distance <= radius {
+		return nil
+	}
+
+	reason := fmt.Sprintf("%s is %.0fm away, limit %.0fm", stage, distance, radius)
+	if p.Action != GeofenceFlag {
+		return fmt.Errorf("%w: %s", ErrOutsideGeofence, reason)
+	}
+	flagForReview(tx, stage, reason, map[string]interface{}{
+		"distanceMeters": int(distance),
+		"radiusMeters":   int(radius),
+	})
+	return nil
+}
+
+func flagForReview(tx *RideTx, stage, reason string, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	metadata["stage"] = stage
+	metadata["reason"] = reason
+	tx.RideTxEvts = append(tx.RideTxEvts, RideTxEvt{
+		EventType: FlaggedForReview,
+		Timestamp: time.Now(),
+		Metadata:  metadata,
+	})
+	fmt.Printf("Ride for driver %s flagged for review: %s\n", tx.DriverUUID, reason)
+}
+
+// FlaggedForReview reports whether anything about the ride was ever flagged for validators to review
+func (tx RideTx) FlaggedForReview() bool {
+	for _, evt := range tx.RideTxEvts {
+		if evt.EventType == FlaggedForReview {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsReview reports whether the ride has a flag no validator has cleared yet
+func (tx RideTx) NeedsReview() bool {
+	needs := false
+	for _, evt := range tx.RideTxEvts {
+		switch evt.EventType {
+		case FlaggedForReview:
+			needs = true
+		case ReviewCleared:
+			needs = false
+		}
+	}
+	return needs
+}
+
+// ClearReview lets a validator who is not a party to the ride clear its flags after
+// looking at them, flagged rides cannot be approved until then
+func (rc *RideChain) ClearReview(driverUUID, validatorUUID, note string) error {
+	if !rc.IsValidator(validatorUUID) {
+		return fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	tx, exists := rc.PendingRideTxs[driverUUID]
+	if !exists {
+		return fmt.Errorf("no pending ride for driver %s", driverUUID)
+	}
+	if validatorUUID == tx.DriverUUID || validatorUUID == tx.RiderUUID {
+		return fmt.Errorf("%s cannot review their own ride", validatorUUID)
+	}
+	if !tx.NeedsReview() {
+		return fmt.Errorf("ride for driver %s has nothing to review", driverUUID)
+	}
+	tx.RideTxEvts = append(tx.RideTxEvts, RideTxEvt{
+		EventType: ReviewCleared,
+		Timestamp: now(),
+		Validator: validatorUUID,
+		Metadata:  map[string]interface{}{"note": note},
+	})
+	rc.PendingRideTxs[driverUUID] = tx
+	fmt.Printf("Review of ride for driver %s cleared by %s\n", driverUUID, validatorUUID)
+	return nil
+}
@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatLng_DistanceMeters(t *testing.T) {
+	broadway := NewLatLng(36.1584, -86.7760)
+	assert.Equal(t, 0.0, broadway.DistanceMeters(broadway))
+	// one degree of latitude is about 111km
+	assert.InDelta(t, 111195, NewLatLng(36, -86.7760).DistanceMeters(NewLatLng(37, -86.7760)), 1)
+}
+
+func TestRideChain_Geofence(t *testing.T) {
+	driver, rider := "driver-geo", "rider-geo"
+	pickup := NewLatLng(36.1627, -86.7816)
+	destination := NewLatLng(36.1584, -86.7760)
+
+	tests := []struct {
+		name        string
+		action      GeofenceAction
+		destination LatLng
+		atPickup    LatLng
+		atDropoff   LatLng
+		wantSubmit  error
+		wantPickup  error
+		wantDropoff error
+		wantFlags   int
+	}{
+		{
+			name:        "driver at the pickup and destination",
+			destination: destination,
+			atPickup:    NewLatLng(36.1630, -86.7812),
+			atDropoff:   NewLatLng(36.1590, -86.7770),
+		},
+		{
+			name:        "pickup reported across town is rejected",
+			destination: destination,
+			atPickup:    NewLatLng(36.1263, -86.6774),
+			wantPickup:  ErrOutsideGeofence,
+		},
+		{
+			name:        "dropoff far from the destination is rejected",
+			destination: destination,
+			atPickup:    pickup,
+			atDropoff:   NewLatLng(36.1263, -86.6774),
+			wantDropoff: ErrOutsideGeofence,
+		},
+		{
+			name:        "flag mode accepts both but flags them for review",
+			action:      GeofenceFlag,
+			destination: destination,
+			atPickup:    NewLatLng(36.1263, -86.6774),
+			atDropoff:   NewLatLng(36.1263, -86.6774),
+			wantFlags:   2,
+		},
+		{
+			name:       "rides without destination coordinates are rejected",
+			wantSubmit: ErrOutsideGeofence,
+		},
+		{
+			name:      "flag mode flags rides without destination coordinates",
+			action:    GeofenceFlag,
+			atPickup:  pickup,
+			atDropoff: destination,
+			wantFlags: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			if tt.action != "" {
+				rc.GeofencePolicy.Action = tt.action
+			}
+
+			tx := testRideTx(driver, rider)
+			tx.ComputedRoute.DestinationLocation = tt.destination
+			onboardTestDriver(t, rc, driver)
+			tx, err = rc.SubmitPendingRideTx(tx)
+			assert.True(t, errors.Is(err, tt.wantSubmit), "submit error %v", err)
+			if tt.wantSubmit != nil {
+				assert.NotContains(t, rc.PendingRideTxs, driver)
+				return
+			}
+
+			err = rc.SubmitPickupProof(tx, tx.PickupCode, tt.atPickup)
+			assert.True(t, errors.Is(err, tt.wantPickup), "pickup error %v", err)
+			if tt.wantPickup != nil {
+				assert.False(t, rc.PendingRideTxs[driver].PickupConfirmed)
+				return
+			}
+
+			trace := straightTrace(tx.PickupLocation, tt.atDropoff, time.Now().Add(-time.Hour), 100, 30*time.Second)
+			assert.Nil(t, rc.SubmitTrace(driver, trace))
+			err = rc.SubmitDropoff(tx, tt.atDropoff)
+			assert.True(t, errors.Is(err, tt.wantDropoff), "dropoff error %v", err)
+			if tt.wantDropoff != nil {
+				assert.False(t, rc.PendingRideTxs[driver].DropoffConfirmed)
+				return
+			}
+
+			flags := 0
+			for _, evt := range rc.PendingRideTxs[driver].RideTxEvts {
+				if evt.EventType == FlaggedForReview {
+					flags++
+				}
+			}
+			assert.Equal(t, tt.wantFlags, flags)
+			assert.Equal(t, tt.wantFlags > 0, rc.PendingRideTxs[driver].FlaggedForReview())
+		})
+	}
+}
+
+func TestRideChain_ClearReview(t *testing.T) {
+	driver, rider := "driver-review", "rider-review"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	rc.TokenLedger.Mint(driver, 10)
+	assert.Nil(t, rc.StakeTokens(10, driver))
+	assert.Nil(t, rc.BecomeValidator(driver))
+	rc.GeofencePolicy.Action = GeofenceFlag
+
+	onboardTestDriver(t, rc, driver)
+	tx, err := rc.SubmitPendingRideTx(testRideTx(driver, rider))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, NewLatLng(36.1263, -86.6774)))
+	driveTestRide(t, rc, tx, tx.ComputedRoute.DestinationLocation)
+	assert.True(t, rc.PendingRideTxs[driver].NeedsReview())
+
+	_, err = rc.ApproveRideTx(tx, "genesis-123")
+	assert.NotNil(t, err, "flagged rides wait for a review")
+	assert.NotNil(t, rc.ClearReview(driver, "someone", "looks fine"), "only validators review")
+	assert.NotNil(t, rc.ClearReview(driver, driver, "looks fine"), "drivers cannot clear their own ride")
+
+	assert.Nil(t, rc.ClearReview(driver, "genesis-123", "pickup was across the street"))
+	assert.False(t, rc.PendingRideTxs[driver].NeedsReview())
+	assert.True(t, rc.PendingRideTxs[driver].FlaggedForReview())
+	assert.NotNil(t, rc.ClearReview(driver, "genesis-123", "again"), "nothing left to review")
+
+	txID, err := rc.ApproveRideTx(tx, "genesis-123")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, txID)
+}
@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// GenerateKeyPair creates a new ed25519 key pair for a driver, rider or validator
+func GenerateKeyPair() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// ParsePublicKey decodes a hex encoded ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length %d", len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// RegisterPublicKey records the key used to check signatures made by uuid
+func (rc *RideChain) RegisterPublicKey(uuid string, pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key for %s", uuid)
+	}
+	if existing, ok := rc.AccountKeys[uuid]; ok && !existing.Equal(pub) {
+		return fmt.Errorf("a different public key is already registered for %s", uuid)
+	}
+	rc.AccountKeys[uuid] = pub
+	return nil
+}
+
+// BindPublicKey records uuid's key on a validator's word, for accounts that already hold
+// state and so cannot register a key themselves, see HasAccountState
+func (rc *RideChain) BindPublicKey(uuid string, pub ed25519.PublicKey, validatorUUID string) error {
+	if !rc.IsValidator(validatorUUID) {
+		return fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	if validatorUUID == uuid {
+		return fmt.Errorf("validator %s cannot bind their own key", validatorUUID)
+	}
+	if _, ok := rc.AccountKeys[uuid]; ok {
+		return fmt.Errorf("a public key is already registered for %s", uuid)
+	}
+	if err := rc.RegisterPublicKey(uuid, pub); err != nil {
+		return err
+	}
+	fmt.Printf("Public key for %s bound by validator %s\n", uuid, validatorUUID)
+	return nil
+}
+
+// SetSigner makes this node sign the blocks it produces as validatorUUID
+func (rc *RideChain) SetSigner(validatorUUID string, key ed25519.PrivateKey) error {
+	pub, ok := key.Public().(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("invalid signing key for %s", validatorUUID)
+	}
+	if err := rc.RegisterPublicKey(validatorUUID, pub); err != nil {
+		return err
+	}
+	rc.signerUUID = validatorUUID
+	rc.signerKey = key
+	return nil
+}
+
+// verifyAccountSignature checks uuid signed msg with their registered key
+func (rc *RideChain) verifyAccountSignature(uuid string, msg, signature []byte) error {
+	pub, ok := rc.AccountKeys[uuid]
+	if !ok {
+		return fmt.Errorf("no public key registered for %s", uuid)
+	}
+	if !ed25519.Verify(pub, msg, signature) {
+		return fmt.Errorf("invalid signature from %s", uuid)
+	}
+	return nil
+}
+
+// verifyHeader checks the header is signed by a proposer with a known key
+func (rc *RideChain) verifyHeader(h BlockHeader) error {
+	pub, ok := rc.AccountKeys[h.Proposer]
+	if !ok {
+		return fmt.Errorf("unknown proposer %q for block %s", h.Proposer, h.Hash)
+	}
+	if !h.VerifySignature(pub) {
+		return fmt.Errorf("invalid proposer signature on block %s", h.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// RideTxProof shows that a RideTx was committed by the block with BlockHash
+// a light client checks it against the TxRoot of a header it already verified
+type RideTxProof struct {
+	Tx        RideTx   `json:"tx"`
+	BlockHash string   `json:"blockHash"`
+	Height    int      `json:"height"`
+	Index     int      `json:"index"`    // position of Tx in the block
+	Siblings  []string `json:"siblings"` // hex encoded sibling hashes, leaf level first
+}
+
+// TxRoot is the merkle root of the RideTxs committed by a block
+func TxRoot(txs []RideTx) string {
+	leaves := make([][]byte, len(txs))
+	for i, tx := range txs {
+		leaves[i] = txLeaf(tx)
+	}
+	return hex.EncodeToString(merkleRoot(leaves))
+}
+
+// txLeaf hashes the full RideTx, including its RideTxEvts, as a merkle leaf
+func txLeaf(tx RideTx) []byte {
+	data, _ := json.Marshal(tx)
+	leaf := sha256.Sum256(data)
+	return leaf[:]
+}
+
+// merkleRoot pairs and hashes leaves level by level
+// an odd node out is paired with itself
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return level[0]
+}
+
+func nextMerkleLevel(level [][]byte) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		next = append(next, hashPair(level[i], right))
+	}
+	return next
+}
+
+func hashPair(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleProof returns the sibling hashes needed to rebuild the root from leaves[index]
+func merkleProof(leaves [][]byte, index int) [][]byte {
+	var siblings [][]byte
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		siblings = append(siblings, level[sibling])
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+	return siblings
+}
+
+// Verify rebuilds the merkle root from the proven RideTx and compares it to txRoot
+func (p RideTxProof) Verify(txRoot string) error {
+	node := txLeaf(p.Tx)
+	index := p.Index
+	for _, s := range p.Siblings {
+		sibling, err := hex.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("invalid sibling hash %q: %w", s, err)
+		}
+		if index%2 == 0 {
+			node = hashPair(node, sibling)
+		} else {
+			node = hashPair(sibling, node)
+		}
+		index /= 2
+	}
+	root, err := hex.DecodeString(txRoot)
+	if err != nil {
+		return fmt.Errorf("invalid tx root %q: %w", txRoot, err)
+	}
+	if !bytes.Equal(node, root) {
+		return fmt.Errorf("rideTx %s is not included in block %s", p.Tx.TxID, p.BlockHash)
+	}
+	return nil
+}
+
+// RideTxProof builds an inclusion proof for a RideTx committed on the canonical chain
+func (rc *RideChain) RideTxProof(txID string) (RideTxProof, error) {
+	for _, b := range rc.Chain.CanonicalChain() {
+		txs, err := b.RideTxs()
+		if err != nil {
+			continue
+		}
+		for i, tx := range txs {
+			if tx.TxID != txID {
+				continue
+			}
+			leaves := make([][]byte, len(txs))
+			for j := range txs {
+				leaves[j] = txLeaf(txs[j])
+			}
+			var siblings []string
+			for _, s := range merkleProof(leaves, i) {
+				siblings = append(siblings, hex.EncodeToString(s))
+			}
+			return RideTxProof{
+				Tx:        tx,
+				BlockHash: b.Hash,
+				Height:    b.Height,
+				Index:     i,
+				Siblings:  siblings,
+			}, nil
+		}
+	}
+	return RideTxProof{}, fmt.Errorf("rideTx %s not committed on the canonical chain", txID)
+}
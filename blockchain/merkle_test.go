@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideTxProof_Verify(t *testing.T) {
+	for size := 1; size <= 7; size++ {
+		var txs []RideTx
+		for i := 0; i < size; i++ {
+			tx := testRideTx("driver-merkle", fmt.Sprintf("rider-%d", i))
+			tx.TxID = generateRideHash(tx)
+			txs = append(txs, tx)
+		}
+		root := TxRoot(txs)
+
+		leaves := make([][]byte, len(txs))
+		for i := range txs {
+			leaves[i] = txLeaf(txs[i])
+		}
+
+		for i, tx := range txs {
+			t.Run(fmt.Sprintf("%d leaves index %d", size, i), func(t *testing.T) {
+				var siblings []string
+				for _, s := range merkleProof(leaves, i) {
+					siblings = append(siblings, hex.EncodeToString(s))
+				}
+				proof := RideTxProof{Tx: tx, Index: i, Siblings: siblings}
+				assert.Nil(t, proof.Verify(root))
+
+				// a rider cannot swap in a different fare
+				proof.Tx.PaidAmount++
+				assert.NotNil(t, proof.Verify(root))
+			})
+		}
+	}
+}
+
+func TestRideChain_RideTxProof(t *testing.T) {
+	driver := "genesis-123"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+
+	txID := completeTestRide(t, rc, testRideTx(driver, "rider-proof"), driver)
+
+	proof, err := rc.RideTxProof(txID)
+	assert.Nil(t, err)
+	block, err := rc.BlockByHash(proof.BlockHash)
+	assert.Nil(t, err)
+	assert.Nil(t, proof.Verify(block.TxRoot))
+
+	_, err = rc.RideTxProof("missing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,280 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPaymentNotVerified is returned when the payment provider does not show the ride paid in full
+	ErrPaymentNotVerified = errors.New("payment not verified")
+	// ErrPaymentReused is returned when the session already paid for another ride
+	ErrPaymentReused = errors.New("payment session already used")
+	// ErrPaymentUnavailable is returned when the payment provider could not be reached
+	ErrPaymentUnavailable = errors.New("payment provider unavailable")
+)
+
+// PaymentStatusPaid is the Stripe payment_status of a checkout session that collected the money
+const PaymentStatusPaid = "paid"
+
+// PaymentSession is the provider's record of a rider's checkout, amounts are cents
+type PaymentSession struct {
+	ID            string `json:"id"`
+	AmountTotal   int    `json:"amount_total"`
+	Currency      string `json:"currency"`
+	PaymentStatus string `json:"payment_status"` // paid, unpaid or no_payment_required
+	Status        string `json:"status"`         // open, complete or expired
+	PaymentIntent string `json:"payment_intent"`
+}
+
+// PaymentVerifier looks up the checkout session a rider paid a ride with
+type PaymentVerifier interface {
+	Session(ctx context.Context, sessionID string) (PaymentSession, error)
+}
+
+// StripePaymentVerifier reads checkout sessions from GET {BaseURL}/v1/checkout/sessions/{id}
+// and issues refunds with POST {BaseURL}/v1/refunds
+type StripePaymentVerifier struct {
+	BaseURL   string
+	SecretKey string
+	HTTP      *http.Client
+}
+
+// NewStripePaymentVerifier talks to https://api.stripe.com when baseURL is empty
+func NewStripePaymentVerifier(baseURL, secretKey string) *StripePaymentVerifier {
+	if baseURL == "" {
+		baseURL = "https://api.stripe.com"
+	}
+	return &StripePaymentVerifier{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		SecretKey: secretKey,
+		HTTP:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *StripePaymentVerifier) Session(ctx context.Context, sessionID string) (PaymentSession, error) {
+	endpoint := s.BaseURL + "/v1/checkout/sessions/" + url.PathEscape(sessionID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return PaymentSession{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return PaymentSession{}, fmt.Errorf("%w: %v", ErrPaymentUnavailable, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return PaymentSession{}, fmt.Errorf("%w: session %s not found", ErrPaymentNotVerified, sessionID)
+	case resp.StatusCode != http.StatusOK:
+		return PaymentSession{}, fmt.Errorf("%w: stripe returned status %d", ErrPaymentUnavailable, resp.StatusCode)
+	}
+
+	var session PaymentSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return PaymentSession{}, fmt.Errorf("stripe returned an unreadable session: %v", err)
+	}
+	return session, nil
+}
+
+// Refund asks Stripe to refund the session's payment intent, the refund ID is sent as the
+// Idempotency-Key so a retried instruction refunds once
+func (s *StripePaymentVerifier) Refund(ctx context.Context, instruction RefundInstruction) (string, error) {
+	if instruction.PaymentIntent == "" {
+		session, err := s.Session(ctx, instruction.SessionID)
+		if err != nil {
+			return "", err
+		}
+		instruction.PaymentIntent = session.PaymentIntent
+	}
+	form := url.Values{}
+	form.Set("payment_intent", instruction.PaymentIntent)
+	form.Set("amount", strconv.Itoa(instruction.Amount))
+	form.Set("metadata[refund_id]", instruction.RefundID)
+	form.Set("metadata[reason]", instruction.Reason)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+"/v1/refunds", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", instruction.RefundID)
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPaymentUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: stripe refused refund %s with status %d", ErrPaymentUnavailable, instruction.RefundID, resp.StatusCode)
+	}
+
+	var refund struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&refund); err != nil {
+		return "", fmt.Errorf("stripe returned an unreadable refund: %v", err)
+	}
+	return refund.ID, nil
+}
+
+// checkPayment makes sure the ride's session is not paying for another ride, a driver may
+// resubmit their own pending ride, and when a PaymentVerifier is configured that it was paid for PaidAmount
+func (rc *RideChain) checkPayment(tx RideTx) error {
+	if other, ok := rc.rideForSession(tx.StripeSessionId); ok && (other.TxID != "" || other.DriverUUID != tx.DriverUUID) {
+		return fmt.Errorf("%w: session %s paid for ride %s of driver %s", ErrPaymentReused, tx.StripeSessionId, other.TxID, other.DriverUUID)
+	}
+	if rc.PaymentVerifier == nil {
+		return nil
+	}
+	session, err := rc.PaymentVerifier.Session(context.Background(), tx.StripeSessionId)
+	if err != nil {
+		return err
+	}
+	if reason := paymentMismatch(tx, session, rc.SettlementPolicy.Currency); reason != "" {
+		return fmt.Errorf("%w: session %s %s", ErrPaymentNotVerified, tx.StripeSessionId, reason)
+	}
+	return nil
+}
+
+// rideForSession finds the pending or canonical ride paid with the session, cancelled rides keep their session
+func (rc *RideChain) rideForSession(sessionID string) (RideTx, bool) {
+	for _, tx := range rc.PendingRideTxs {
+		if tx.StripeSessionId == sessionID {
+			return tx, true
+		}
+	}
+	for _, b := range rc.Chain.CanonicalChain() {
+		txs, err := b.RideTxs()
+		if err != nil {
+			continue
+		}
+		for _, tx := range txs {
+			if tx.StripeSessionId == sessionID {
+				return tx, true
+			}
+		}
+	}
+	return RideTx{}, false
+}
+
+// paymentMismatch explains how the session diverges from the ride, empty when it matches
+// PaidAmount is in cents of currency, the currency drivers are settled in
+func paymentMismatch(tx RideTx, session PaymentSession, currency string) string {
+	switch {
+	case session.PaymentStatus != PaymentStatusPaid:
+		return fmt.Sprintf("is %s", session.PaymentStatus)
+	case !strings.EqualFold(session.Currency, currency):
+		return fmt.Sprintf("paid in %q, rides settle in %q", session.Currency, currency)
+	case session.AmountTotal != tx.PaidAmount:
+		return fmt.Sprintf("paid %d, ride says %d", session.AmountTotal, tx.PaidAmount)
+	}
+	return ""
+}
+
+// PaymentDiscrepancy is a committed ride whose payment does not match the provider's record
+type PaymentDiscrepancy struct {
+	TxID          string `json:"txID"`
+	DriverUUID    string `json:"driverUUID"`
+	RiderUUID     string `json:"riderUUID"`
+	SessionID     string `json:"sessionId"`
+	PaidAmount    int    `json:"paidAmount"`
+	SessionAmount int    `json:"sessionAmount"`
+	PaymentStatus string `json:"paymentStatus"`
+	Reason        string `json:"reason"`
+}
+
+// ReconciliationReport lists the canonical rides whose payment state diverges from the provider
+type ReconciliationReport struct {
+	GeneratedAt   time.Time            `json:"generatedAt"`
+	RidesChecked  int                  `json:"ridesChecked"`
+	Discrepancies []PaymentDiscrepancy `json:"discrepancies"`
+}
+
+// ReconcilePayments checks every ride on the canonical chain against the PaymentVerifier,
+// a session that paid for more than one ride is reported on each of them
+func (rc *RideChain) ReconcilePayments(ctx context.Context) (ReconciliationReport, error) {
+	reconciliation, err := rc.NewPaymentReconciliation()
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	return reconciliation.Run(ctx)
+}
+
+// PaymentReconciliation is a snapshot of the canonical rides to check against the payment provider,
+// Run makes one provider call per ride and does not touch the RideChain, so callers serializing
+// access to the chain only need to hold their lock for NewPaymentReconciliation
+type PaymentReconciliation struct {
+	verifier PaymentVerifier
+	currency string
+	rides    []RideTx
+	sessions map[string]int // sessionID -> rides paid with it
+}
+
+// NewPaymentReconciliation snapshots the canonical rides and the settlement currency
+func (rc *RideChain) NewPaymentReconciliation() (*PaymentReconciliation, error) {
+	if rc.PaymentVerifier == nil {
+		return nil, errors.New("no payment verifier configured")
+	}
+	p := &PaymentReconciliation{
+		verifier: rc.PaymentVerifier,
+		currency: rc.SettlementPolicy.Currency,
+		sessions: make(map[string]int),
+	}
+	for _, b := range rc.Chain.CanonicalChain() {
+		txs, err := b.RideTxs()
+		if err != nil {
+			continue
+		}
+		for _, tx := range txs {
+			p.rides = append(p.rides, tx)
+			p.sessions[tx.StripeSessionId]++
+		}
+	}
+	return p, nil
+}
+
+// Run checks the snapshot's rides against the payment provider
+func (p *PaymentReconciliation) Run(ctx context.Context) (ReconciliationReport, error) {
+	report := ReconciliationReport{GeneratedAt: now().UTC(), Discrepancies: []PaymentDiscrepancy{}}
+	for _, tx := range p.rides {
+		report.RidesChecked++
+		discrepancy := PaymentDiscrepancy{
+			TxID:       tx.TxID,
+			DriverUUID: tx.DriverUUID,
+			RiderUUID:  tx.RiderUUID,
+			SessionID:  tx.StripeSessionId,
+			PaidAmount: tx.PaidAmount,
+		}
+		session, err := p.verifier.Session(ctx, tx.StripeSessionId)
+		switch {
+		case errors.Is(err, ErrPaymentNotVerified):
+			discrepancy.Reason = "session not found"
+		case err != nil:
+			return ReconciliationReport{}, err
+		default:
+			discrepancy.SessionAmount = session.AmountTotal
+			discrepancy.PaymentStatus = session.PaymentStatus
+			discrepancy.Reason = paymentMismatch(tx, session, p.currency)
+		}
+		if p.sessions[tx.StripeSessionId] > 1 && discrepancy.Reason == "" {
+			discrepancy.Reason = fmt.Sprintf("session paid for %d rides", p.sessions[tx.StripeSessionId])
+		}
+		if discrepancy.Reason != "" {
+			report.Discrepancies = append(report.Discrepancies, discrepancy)
+		}
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		return report.Discrepancies[i].TxID < report.Discrepancies[j].TxID
+	})
+	fmt.Printf("Reconciled %d rides, %d discrepancies\n", report.RidesChecked, len(report.Discrepancies))
+	return report, nil
+}
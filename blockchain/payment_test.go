@@ -0,0 +1,249 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeStripeKey = "sk_test_blockshare"
+
+// fakeStripeServer answers GET /v1/checkout/sessions/{id} the way Stripe does from canned sessions
+// and records POST /v1/refunds by Idempotency-Key
+type fakeStripeServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	sessions map[string]PaymentSession
+	refunds  map[string]url.Values // Idempotency-Key -> refund form
+	down     bool
+}
+
+func newFakeStripeServer(t *testing.T) *fakeStripeServer {
+	f := &fakeStripeServer{sessions: make(map[string]PaymentSession), refunds: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/checkout/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeStripeKey {
+			http.Error(w, `{"error":{"type":"invalid_request_error"}}`, http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		session, ok := f.sessions[r.PathValue("id")]
+		down := f.down
+		f.mu.Unlock()
+		switch {
+		case down:
+			http.Error(w, `{"error":{"type":"api_error"}}`, http.StatusInternalServerError)
+		case !ok:
+			http.Error(w, `{"error":{"type":"invalid_request_error","code":"resource_missing"}}`, http.StatusNotFound)
+		default:
+			_ = json.NewEncoder(w).Encode(session)
+		}
+	})
+	mux.HandleFunc("POST /v1/refunds", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeStripeKey {
+			http.Error(w, `{"error":{"type":"invalid_request_error"}}`, http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("payment_intent") == "" {
+			http.Error(w, `{"error":{"type":"invalid_request_error","param":"payment_intent"}}`, http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.down {
+			http.Error(w, `{"error":{"type":"api_error"}}`, http.StatusInternalServerError)
+			return
+		}
+		key := r.Header.Get("Idempotency-Key")
+		f.refunds[key] = r.PostForm
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "re_" + key})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// pay records a completed checkout for amount cents
+func (f *fakeStripeServer) pay(sessionID string, amount int) {
+	f.set(PaymentSession{ID: sessionID, AmountTotal: amount, Currency: "usd", PaymentStatus: PaymentStatusPaid, Status: "complete", PaymentIntent: "pi_" + sessionID})
+}
+
+// refunded returns the refund form Stripe received for the idempotency key
+func (f *fakeStripeServer) refunded(key string) (url.Values, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	form, ok := f.refunds[key]
+	return form, ok
+}
+
+// restore ends an outage
+func (f *fakeStripeServer) restore() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = false
+}
+
+func (f *fakeStripeServer) set(session PaymentSession) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions[session.ID] = session
+}
+
+func (f *fakeStripeServer) remove(sessionID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sessions, sessionID)
+}
+
+// outage makes every request fail with a 500
+func (f *fakeStripeServer) outage() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = true
+}
+
+func (f *fakeStripeServer) verifier() *StripePaymentVerifier {
+	return NewStripePaymentVerifier(f.URL, fakeStripeKey)
+}
+
+func TestRideChain_SubmitPendingRideTx_Payment(t *testing.T) {
+	driver, rider := "driver-payment", "rider-payment"
+
+	tests := []struct {
+		name    string
+		prepare func(rc *RideChain, stripe *fakeStripeServer, tx RideTx)
+		wantErr error
+	}{
+		{
+			name: "session paid for the fare",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {
+				stripe.pay(tx.StripeSessionId, tx.PaidAmount)
+			},
+		},
+		{
+			name:    "unknown session",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {},
+			wantErr: ErrPaymentNotVerified,
+		},
+		{
+			name: "session not paid",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {
+				stripe.set(PaymentSession{ID: tx.StripeSessionId, AmountTotal: tx.PaidAmount, PaymentStatus: "unpaid", Status: "open"})
+			},
+			wantErr: ErrPaymentNotVerified,
+		},
+		{
+			name: "session paid less than the ride claims",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {
+				stripe.pay(tx.StripeSessionId, tx.PaidAmount-100)
+			},
+			wantErr: ErrPaymentNotVerified,
+		},
+		{
+			name: "session paid the fare in another currency",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {
+				stripe.set(PaymentSession{ID: tx.StripeSessionId, AmountTotal: tx.PaidAmount, Currency: "jpy", PaymentStatus: PaymentStatusPaid, Status: "complete"})
+			},
+			wantErr: ErrPaymentNotVerified,
+		},
+		{
+			name: "stripe unavailable",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {
+				stripe.pay(tx.StripeSessionId, tx.PaidAmount)
+				stripe.outage()
+			},
+			wantErr: ErrPaymentUnavailable,
+		},
+		{
+			name: "session already paid for another driver's pending ride",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {
+				stripe.pay(tx.StripeSessionId, tx.PaidAmount)
+				other := testRideTx("driver-payment-other", rider)
+				other.StripeSessionId = tx.StripeSessionId
+				onboardTestDriver(t, rc, other.DriverUUID)
+				_, err := rc.SubmitPendingRideTx(other)
+				assert.Nil(t, err)
+			},
+			wantErr: ErrPaymentReused,
+		},
+		{
+			name: "session already paid for a committed ride",
+			prepare: func(rc *RideChain, stripe *fakeStripeServer, tx RideTx) {
+				stripe.pay(tx.StripeSessionId, tx.PaidAmount)
+				completeTestRide(t, rc, tx, "genesis-123")
+			},
+			wantErr: ErrPaymentReused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			assert.Nil(t, rc.BecomeValidator("genesis-123"))
+			stripe := newFakeStripeServer(t)
+			rc.PaymentVerifier = stripe.verifier()
+			onboardTestDriver(t, rc, driver)
+
+			tx := testRideTx(driver, rider)
+			tt.prepare(rc, stripe, tx)
+			_, err = rc.SubmitPendingRideTx(tx)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, rc.HasActiveRide(driver))
+		})
+	}
+}
+
+func TestRideChain_ReconcilePayments(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+
+	_, err = rc.ReconcilePayments(context.Background())
+	assert.NotNil(t, err, "no verifier configured")
+
+	stripe := newFakeStripeServer(t)
+	txIDs := make(map[string]string) // driver -> TxID
+	for _, driver := range []string{"driver-reconciled", "driver-refunded", "driver-unpaid", "driver-missing"} {
+		tx := testRideTx(driver, "rider-reconcile")
+		stripe.pay(tx.StripeSessionId, tx.PaidAmount)
+		txIDs[driver] = completeTestRide(t, rc, tx, "genesis-123")
+	}
+	// the provider's records drift after the rides were committed
+	refunded := testRideTx("driver-refunded", "rider-reconcile")
+	stripe.pay(refunded.StripeSessionId, refunded.PaidAmount-200)
+	unpaid := testRideTx("driver-unpaid", "rider-reconcile")
+	stripe.set(PaymentSession{ID: unpaid.StripeSessionId, AmountTotal: unpaid.PaidAmount, PaymentStatus: "unpaid"})
+	missing := testRideTx("driver-missing", "rider-reconcile")
+	stripe.remove(missing.StripeSessionId)
+
+	rc.PaymentVerifier = stripe.verifier()
+	report, err := rc.ReconcilePayments(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 4, report.RidesChecked)
+
+	reasons := make(map[string]string)
+	for _, d := range report.Discrepancies {
+		reasons[d.TxID] = d.Reason
+	}
+	assert.Len(t, reasons, 3)
+	assert.NotContains(t, reasons, txIDs["driver-reconciled"])
+	assert.Equal(t, "paid 300, ride says 500", reasons[txIDs["driver-refunded"]])
+	assert.Equal(t, "is unpaid", reasons[txIDs["driver-unpaid"]])
+	assert.Equal(t, "session not found", reasons[txIDs["driver-missing"]])
+
+	stripe.outage()
+	_, err = rc.ReconcilePayments(context.Background())
+	assert.True(t, errors.Is(err, ErrPaymentUnavailable), "got %v", err)
+}
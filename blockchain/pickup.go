@@ -0,0 +1,100 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrInvalidPickupCode is returned when the code given at pickup does not match the commitment
+	ErrInvalidPickupCode = errors.New("invalid pickup code")
+	// ErrPickupLocked is returned once a ride has used up its pickup attempts
+	ErrPickupLocked = errors.New("too many failed pickup attempts")
+)
+
+const (
+	pickupCodeDigits = 6
+	pickupSaltBytes  = 16
+)
+
+// pickupSecret is kept by the node that issued a pickup code and never goes on-chain
+// without the salt nobody reading the chain can brute force the commitment
+type pickupSecret struct {
+	Salt           string `json:"salt"`
+	FailedAttempts int    `json:"failedAttempts"`
+}
+
+// issuePickupCode generates a new code for the driver's ride, stores its salted
+// commitment on tx and returns the code for the rider's app
+func (rc *RideChain) issuePickupCode(tx *RideTx) (string, error) {
+	code, err := randomDigits(pickupCodeDigits)
+	if err != nil {
+		return "", err
+	}
+	salt := make([]byte, pickupSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	secret := &pickupSecret{Salt: hex.EncodeToString(salt)}
+	tx.PickupCodeCommitment = secret.commit(code)
+	rc.pickupSecrets[tx.DriverUUID] = secret
+	return code, nil
+}
+
+// ReissuePickupCode gives the rider a fresh code and resets failed attempts
+func (rc *RideChain) ReissuePickupCode(driverUUID, riderUUID string) (string, error) {
+	tx, exists := rc.PendingRideTxs[driverUUID]
+	if !exists {
+		return "", fmt.Errorf("no pending ride for driver %s", driverUUID)
+	}
+	if tx.RiderUUID != riderUUID {
+		return "", fmt.Errorf("only rider %s can reissue the pickup code", tx.RiderUUID)
+	}
+	if tx.PickupConfirmed {
+		return "", fmt.Errorf("pickup already confirmed for driver %s", driverUUID)
+	}
+
+	code, err := rc.issuePickupCode(&tx)
+	if err != nil {
+		return "", err
+	}
+	rc.PendingRideTxs[driverUUID] = tx
+	return code, nil
+}
+
+// checkPickupCode verifies code against the ride's commitment, counting failures
+func (rc *RideChain) checkPickupCode(tx RideTx, code string) error {
+	secret, ok := rc.pickupSecrets[tx.DriverUUID]
+	if !ok {
+		return fmt.Errorf("no pickup code issued for driver %s", tx.DriverUUID)
+	}
+	if secret.FailedAttempts >= rc.MaxPickupAttempts {
+		return fmt.Errorf("%w for driver %s, the rider must reissue the code", ErrPickupLocked, tx.DriverUUID)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret.commit(code)), []byte(tx.PickupCodeCommitment)) != 1 {
+		secret.FailedAttempts++
+		return fmt.Errorf("%w, %d attempts left", ErrInvalidPickupCode, rc.MaxPickupAttempts-secret.FailedAttempts)
+	}
+	return nil
+}
+
+func (s *pickupSecret) commit(code string) string {
+	hash := sha256.Sum256([]byte(s.Salt + code))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomDigits(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
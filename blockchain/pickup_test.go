@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideChain_PickupCode(t *testing.T) {
+	driver, rider := "driver-code", "rider-code"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	rc.MaxPickupAttempts = 3
+
+	onboardTestDriver(t, rc, driver)
+	tx, err := rc.SubmitPendingRideTx(testRideTx(driver, rider))
+	assert.Nil(t, err)
+	assert.Len(t, tx.PickupCode, pickupCodeDigits)
+
+	// the code never reaches the mempool, the chain state or the TxID hash
+	pending := rc.PendingRideTxs[driver]
+	assert.Empty(t, pending.PickupCode)
+	assert.NotEmpty(t, pending.PickupCodeCommitment)
+	data, err := json.Marshal(tx)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), tx.PickupCode)
+
+	wrong := "000000"
+	if tx.PickupCode == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < rc.MaxPickupAttempts; i++ {
+		err = rc.SubmitPickupProof(tx, wrong, tx.PickupLocation)
+		assert.True(t, errors.Is(err, ErrInvalidPickupCode), "got %v", err)
+	}
+	err = rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation)
+	assert.True(t, errors.Is(err, ErrPickupLocked), "the right code is refused once locked, got %v", err)
+
+	// the attempt count survives a restart
+	assert.Nil(t, rc.Save())
+	reopened, err := OpenRideChain(filepath.Dir(rc.TokenLedger.filename))
+	assert.Nil(t, err)
+	reopened.MaxPickupAttempts = rc.MaxPickupAttempts
+	err = reopened.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation)
+	assert.True(t, errors.Is(err, ErrPickupLocked))
+
+	_, err = reopened.ReissuePickupCode(driver, driver)
+	assert.NotNil(t, err, "only the rider can reissue")
+	code, err := reopened.ReissuePickupCode(driver, rider)
+	assert.Nil(t, err)
+	assert.NotNil(t, reopened.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation), "old code is replaced")
+	assert.Nil(t, reopened.SubmitPickupProof(tx, code, tx.PickupLocation))
+	assert.True(t, reopened.PendingRideTxs[driver].PickupConfirmed)
+	assert.NotContains(t, reopened.pickupSecrets, driver)
+}
@@ -5,11 +5,19 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math"
+	"sort"
+	"strings"
+	"time"
 )
 
+// ErrRequirementsNotMet is returned for blocks proposed or approved by a validator
+// whose verification was flagged, lapsed or never approved
+var ErrRequirementsNotMet = errors.New("validator requirements not met")
+
 // Pull the data from the block
 // Create a counter nonce starts at 0
 // Create hash of the data + the counter
@@ -22,8 +30,9 @@ const Difficulty = 1
 
 // ProofOfStake contains the data required to validate block
 type ProofOfStake struct {
-	Block        *Block
-	Requirements *Requirements
+	Block *Block
+	// Requirements of the block proposer and each approving validator, keyed by uuid
+	Requirements map[string]Requirements
 }
 
 type Requirements struct {
@@ -33,26 +42,50 @@ type Requirements struct {
 	// is not already on the platform
 }
 
+// Met reports whether a validator may propose or approve blocks
+func (r Requirements) Met() bool {
+	return !r.OnOffenderList && r.CarInsurance && r.ConfirmRequirements
+}
+
+func (r Requirements) String() string {
+	var unmet []string
+	if r.OnOffenderList {
+		unmet = append(unmet, "flagged by a background check")
+	}
+	if !r.CarInsurance {
+		unmet = append(unmet, "no current car insurance")
+	}
+	if !r.ConfirmRequirements {
+		unmet = append(unmet, "verification not approved")
+	}
+	return strings.Join(unmet, ", ")
+}
+
+// Run hashes the block, requirements are about the validators not the nonce
+// so they are checked by Validate rather than by searching for another nonce
 func (pos *ProofOfStake) Run() (int, string) {
-	var hashed []byte
-	var nonce int
-	for nonce < math.MaxInt64 {
-		data := pos.InitData(nonce)
-		record := fmt.Sprintf("%d%s%s%s", pos.Block.Nonce, pos.Block.Timestamp, data, pos.Block.PrevBlockHash)
-		h := sha256.New()
-		h.Write([]byte(record))
-		hashed = h.Sum(nil)
-
-		// if the requirements are met the break
-		// else try again with another nonce
-		if hasCarInsurance() {
-			break
-		} else {
-			nonce++
-		}
+	nonce := 0
+	return nonce, pos.hash(nonce)
+}
 
+// hash the block data with nonce and every header field except the proposer's signature
+// the timestamp is hashed as unix nanos so the hash survives encoding round trips
+func (pos *ProofOfStake) hash(nonce int) string {
+	b := pos.Block
+	data := pos.InitData(nonce)
+	record := fmt.Sprintf("%d%d%d%s%s%s", nonce, b.Height, b.Timestamp.UnixNano(), data, b.PrevBlockHash, b.TxRoot)
+	for _, v := range b.Validators {
+		record += fmt.Sprintf("|%s:%d", v.UUID, v.Stake)
 	}
-	return nonce, hex.EncodeToString(hashed)
+	for _, u := range b.ValidatorUpdates {
+		record += fmt.Sprintf("|%s:%x:%t", u.UUID, u.PubKey, u.Removed)
+	}
+	for _, r := range b.Records {
+		record += r.digest()
+	}
+	h := sha256.New()
+	h.Write([]byte(record))
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 func NewProof(b *Block) *ProofOfStake {
@@ -74,18 +107,100 @@ func (pos *ProofOfStake) InitData(nonce int) []byte {
 	return data
 }
 
-func isOnOffenderList() bool {
+// isOnOffenderList reports whether a background check on the request had flagged the driver by the given time
+func isOnOffenderList(request DriverVerificationRequest, at time.Time) bool {
+	for _, result := range request.Results {
+		if result.Check == CheckBackground && !result.Passed && !result.CheckedAt.After(at) {
+			return true
+		}
+	}
 	return false
 }
 
-// hasCarInsurance determines if the driver has the required car insurance for this month
-// todo random true false for the proof of stake
-func hasCarInsurance() bool {
-	return true
+// hasCarInsurance determines if the driver had the required car insurance at the given time
+// a verification approved without an insurance provider counts as the validator confirming it
+func hasCarInsurance(request DriverVerificationRequest, at time.Time) bool {
+	for _, result := range request.Results {
+		if result.Check != CheckInsurance {
+			continue
+		}
+		return result.Passed && (result.ExpiresAt.IsZero() || result.ExpiresAt.After(at))
+	}
+	return request.Active(at)
 }
 
-func validatorHasConfirmedRequirements() bool {
-	return true
+// validatorHasConfirmedRequirements reports whether the driver held an unexpired approval at the given time
+func validatorHasConfirmedRequirements(request DriverVerificationRequest, at time.Time) bool {
+	return request.Active(at)
+}
+
+// requirements checks the validator's committed verification at the given time
+func requirements(request DriverVerificationRequest, at time.Time) Requirements {
+	return Requirements{
+		OnOffenderList:      isOnOffenderList(request, at),
+		CarInsurance:        hasCarInsurance(request, at),
+		ConfirmRequirements: validatorHasConfirmedRequirements(request, at),
+	}
+}
+
+// committedVerification finds the latest verification round of uuid committed by b or
+// its ancestors, rounds this node holds that are not on chain do not count
+func (rc *RideChain) committedVerification(b *Block, uuid string) (DriverVerificationRequest, bool) {
+	for ok := true; ok; b, ok = rc.Chain.Get(b.PrevBlockHash) {
+		for i := len(b.Records) - 1; i >= 0; i-- {
+			r := b.Records[i]
+			if r.Kind != RecordVerification || r.ID != uuid {
+				continue
+			}
+			var request DriverVerificationRequest
+			if err := json.Unmarshal(r.Data, &request); err != nil {
+				fmt.Printf("Verification record %s in block %s is unreadable: %v\n", r.ID, b.Hash, err)
+				continue
+			}
+			return request, true
+		}
+	}
+	return DriverVerificationRequest{}, false
+}
+
+// ProofOfStake gathers the requirements of the block's proposer and approving validators from the
+// verification rounds committed up to the block, checked at the block's Timestamp
+// validators that were never verified are only held to requirements when RequireVerifiedValidators is set
+func (rc *RideChain) ProofOfStake(b *Block) *ProofOfStake {
+	pos := NewProof(b)
+	pos.Requirements = make(map[string]Requirements)
+
+	uuids := make([]string, 0, len(b.Validators)+1)
+	if b.Proposer != "" {
+		uuids = append(uuids, b.Proposer)
+	}
+	for _, v := range b.Validators {
+		uuids = append(uuids, v.UUID)
+	}
+	for _, uuid := range uuids {
+		request, ok := rc.committedVerification(b, uuid)
+		if (!ok || len(request.Results) == 0 && request.VerifiedAt.IsZero()) && !rc.RequireVerifiedValidators {
+			continue
+		}
+		pos.Requirements[uuid] = requirements(request, b.Timestamp)
+	}
+	return pos
+}
+
+// checkRequirements names every validator behind the block that fails its requirements
+func (rc *RideChain) checkRequirements(b *Block) error {
+	pos := rc.ProofOfStake(b)
+	if pos.Validate() {
+		return nil
+	}
+	var unmet []string
+	for uuid, r := range pos.Requirements {
+		if !r.Met() {
+			unmet = append(unmet, fmt.Sprintf("%s: %s", uuid, r))
+		}
+	}
+	sort.Strings(unmet)
+	return fmt.Errorf("%w for block %s: %s", ErrRequirementsNotMet, b.Hash, strings.Join(unmet, "; "))
 }
 
 func ToHex(i int64) []byte {
@@ -96,8 +211,14 @@ func ToHex(i int64) []byte {
 	return buff.Bytes()
 }
 
+// Validate checks the proposer and every approving validator meet their requirements
 func (pos *ProofOfStake) Validate() bool {
-	return !isOnOffenderList() && hasCarInsurance()
+	for _, r := range pos.Requirements {
+		if !r.Met() {
+			return false
+		}
+	}
+	return true
 	// TODO this is from Tensor revisit this?
 	// var intHash big.Int
 	// data := pos.InitData(pos.Block.Nonce)
@@ -1,5 +1,15 @@
 package blockchain
 
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
 // func TestNewProof(t *testing.T) {
 // 	tests := []struct {
 // 		name  string
@@ -19,3 +29,114 @@ package blockchain
 // 		})
 // 	}
 // }
+
+func TestRideChain_ProofOfStakeRequirements(t *testing.T) {
+	validator := "genesis-123"
+	lapsed := time.Now().Add(-24 * time.Hour)
+	current := time.Now().Add(180 * 24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		strict  bool
+		results []VerificationResult
+		status  string
+		local   bool // the round is only in this node's PendingVerifications
+		wantErr bool
+	}{
+		{
+			name: "never verified",
+		},
+		{
+			name:    "never verified when verification is required",
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:   "verified with current insurance",
+			strict: true,
+			results: []VerificationResult{
+				{Check: CheckBackground, Passed: true},
+				{Check: CheckInsurance, Passed: true, ExpiresAt: current},
+			},
+			status: VerificationApproved,
+		},
+		{
+			name: "insurance lapsed since verification",
+			results: []VerificationResult{
+				{Check: CheckBackground, Passed: true},
+				{Check: CheckInsurance, Passed: true, ExpiresAt: lapsed},
+			},
+			status:  VerificationApproved,
+			wantErr: true,
+		},
+		{
+			name: "flagged by the background check",
+			results: []VerificationResult{
+				{Check: CheckBackground, Passed: false, Reason: "registry match"},
+				{Check: CheckInsurance, Passed: true, ExpiresAt: current},
+			},
+			status:  VerificationRejected,
+			wantErr: true,
+		},
+		{
+			name: "flag held by this node but never committed",
+			results: []VerificationResult{
+				{Check: CheckBackground, Passed: false, Reason: "registry match"},
+			},
+			status: VerificationRejected,
+			local:  true,
+		},
+		{
+			name: "flag raised after the block",
+			results: []VerificationResult{
+				{Check: CheckBackground, Passed: false, Reason: "registry match", CheckedAt: current},
+				{Check: CheckInsurance, Passed: true, ExpiresAt: current},
+			},
+			status: VerificationApproved,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			assert.Nil(t, rc.BecomeValidator(validator))
+			height := 0
+			if tt.results != nil {
+				request := DriverVerificationRequest{
+					DriverUUID: validator,
+					Status:     tt.status,
+					Results:    tt.results,
+				}
+				if tt.status == VerificationApproved {
+					request.VerifiedAt = time.Now()
+				}
+				rc.PendingVerifications[validator] = request
+				if !tt.local {
+					assert.Nil(t, rc.queueRecord(RecordVerification, validator, request))
+					// another validator commits the round, a block counts the rounds it carries itself
+					rc.TokenLedger.Mint("validator-2", 10)
+					assert.Nil(t, rc.StakeTokens(10, "validator-2"))
+					assert.Nil(t, rc.BecomeValidator("validator-2"))
+					assert.Nil(t, rc.commitRideTxs(nil, map[string]bool{"validator-2": true}))
+					height++
+				}
+			}
+			rc.RequireVerifiedValidators = tt.strict
+
+			driver := "driver-" + uuid.NewString()
+			onboardTestDriver(t, rc, driver)
+			tx, err := rc.SubmitPendingRideTx(testRideTx(driver, "rider-pos"))
+			assert.Nil(t, err)
+			assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+			driveTestRide(t, rc, tx, tx.ComputedRoute.DestinationLocation)
+			_, err = rc.ApproveRideTx(tx, validator)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrRequirementsNotMet), "got %v", err)
+				assert.Equal(t, height, rc.Chain.HeadBlock().Height)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, height+1, rc.Chain.HeadBlock().Height)
+		})
+	}
+}
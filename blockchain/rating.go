@@ -0,0 +1,201 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Rating is a signed 1-5 star review one party of a committed ride leaves for the other
+type Rating struct {
+	TxID      string    `json:"txID"`
+	Rater     string    `json:"rater"`
+	Ratee     string    `json:"ratee"`
+	Stars     int       `json:"stars"`
+	Comment   string    `json:"comment"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature []byte    `json:"signature"`
+}
+
+// ReputationPolicy sets how ratings become a reputation
+type ReputationPolicy struct {
+	// HalfLife is how long until a rating counts half as much
+	HalfLife time.Duration
+	// PriorStars and PriorWeight pull reputations with few ratings toward average
+	PriorStars  float64
+	PriorWeight float64
+	// MaxClockSkew is how far a rating's signed Timestamp may be from the node's clock
+	MaxClockSkew time.Duration
+}
+
+func DefaultReputationPolicy() ReputationPolicy {
+	return ReputationPolicy{
+		HalfLife:     90 * 24 * time.Hour,
+		PriorStars:   3,
+		PriorWeight:  2,
+		MaxClockSkew: 5 * time.Minute,
+	}
+}
+
+// Reputation is the decayed aggregate of the ratings a driver or rider received
+type Reputation struct {
+	UUID      string  `json:"uuid"`
+	Ratings   int     `json:"ratings"`
+	AvgRating float64 `json:"avgRating"` // decay weighted stars, 0 without ratings
+	// TrustScore is between 0 and 1, new accounts start at the prior
+	TrustScore float64 `json:"trustScore"`
+}
+
+// NewRating builds a rating for the other party of a ride, sign it with Sign before submitting
+func NewRating(txID, rater, ratee string, stars int, comment string) Rating {
+	return Rating{
+		TxID:      txID,
+		Rater:     rater,
+		Ratee:     ratee,
+		Stars:     stars,
+		Comment:   comment,
+		Timestamp: now().UTC(),
+	}
+}
+
+// SigningBytes is the digest the rater signs
+func (r Rating) SigningBytes() []byte {
+	record := fmt.Sprintf("%s|%s|%s|%d|%s|%d", r.TxID, r.Rater, r.Ratee, r.Stars, r.Comment, r.Timestamp.UnixNano())
+	digest := sha256.Sum256([]byte(record))
+	return digest[:]
+}
+
+func (r *Rating) Sign(key ed25519.PrivateKey) {
+	r.Signature = ed25519.Sign(key, r.SigningBytes())
+}
+
+// SubmitRating queues a signed rating from the rider or driver of a completed ride to be
+// committed in a block, each party rates the other once per ride. Only committed ratings
+// are in Ratings and count toward reputations
+func (rc *RideChain) SubmitRating(r Rating) error {
+	tx, err := rc.RideTx(r.TxID)
+	if err != nil {
+		return err
+	}
+	if !tx.Completed() {
+		return fmt.Errorf("ride %s was not completed", r.TxID)
+	}
+	switch {
+	case r.Rater == tx.RiderUUID && r.Ratee == tx.DriverUUID:
+	case r.Rater == tx.DriverUUID && r.Ratee == tx.RiderUUID:
+	default:
+		return fmt.Errorf("%s and %s are not the rider and driver of ride %s", r.Rater, r.Ratee, r.TxID)
+	}
+	if r.Stars < 1 || r.Stars > 5 {
+		return fmt.Errorf("rating must be 1 to 5 stars, got %d", r.Stars)
+	}
+	pub, ok := rc.AccountKeys[r.Rater]
+	if !ok {
+		return fmt.Errorf("no public key registered for %s", r.Rater)
+	}
+	if len(r.Signature) == 0 || !ed25519.Verify(pub, r.SigningBytes(), r.Signature) {
+		return fmt.Errorf("invalid signature on rating from %s", r.Rater)
+	}
+	// the timestamp sets the rating's weight, so it must be close to when the node received it
+	if skew := now().Sub(r.Timestamp); skew > rc.ReputationPolicy.MaxClockSkew || -skew > rc.ReputationPolicy.MaxClockSkew {
+		return fmt.Errorf("rating timestamp %s is more than %s from now", r.Timestamp.Format(time.RFC3339), rc.ReputationPolicy.MaxClockSkew)
+	}
+	id := ratingID(r.TxID, r.Rater)
+	if _, rated := rc.Ratings[r.TxID][r.Rater]; rated || rc.recordQueued(RecordRating, id) {
+		return fmt.Errorf("%s already rated ride %s", r.Rater, r.TxID)
+	}
+
+	if err := rc.queueRecord(RecordRating, id, r); err != nil {
+		return err
+	}
+	fmt.Printf("Ride %s rated %d stars by %s\n", r.TxID, r.Stars, r.Rater)
+	return rc.flushRecords()
+}
+
+func ratingID(txID, rater string) string {
+	return txID + ":" + rater
+}
+
+// Reputation aggregates the committed ratings uuid received, weighting each by its age
+func (rc *RideChain) Reputation(uuid string) Reputation {
+	p := rc.ReputationPolicy
+	at := now()
+
+	rep := Reputation{UUID: uuid}
+	var weighted, weights float64
+	for _, byRater := range rc.Ratings {
+		for _, r := range byRater {
+			if r.Ratee != uuid {
+				continue
+			}
+			age := at.Sub(r.Timestamp)
+			weight := math.Pow(0.5, max(age, 0).Hours()/p.HalfLife.Hours())
+			weighted += weight * float64(r.Stars)
+			weights += weight
+			rep.Ratings++
+		}
+	}
+
+	if weights > 0 {
+		rep.AvgRating = weighted / weights
+	}
+	smoothed := (weighted + p.PriorStars*p.PriorWeight) / (weights + p.PriorWeight)
+	rep.TrustScore = (smoothed - 1) / 4
+	return rep
+}
+
+// ValidatorProfile builds a profile from the canonical chain, ratings, verification and stake
+func (rc *RideChain) ValidatorProfile(uuid string) ValidatorProfile {
+	profile := ValidatorProfile{UUID: uuid, Stake: rc.TokenLedger.GetStake(uuid)}
+	for _, b := range rc.Chain.CanonicalChain() {
+		txs, err := b.RideTxs()
+		if err != nil {
+			continue
+		}
+		for _, tx := range txs {
+			if !tx.Completed() {
+				continue
+			}
+			if tx.DriverUUID == uuid {
+				profile.RidesServed++
+			}
+			if tx.RiderUUID == uuid {
+				profile.RidesTaken++
+			}
+		}
+	}
+	rep := rc.Reputation(uuid)
+	profile.AvgRating = rep.AvgRating
+	profile.TrustScore = rep.TrustScore
+
+	if request, ok := rc.PendingVerifications[uuid]; ok {
+		profile.Verified = request.Active(now())
+		profile.LastKYC = request.VerifiedAt
+	}
+	return profile
+}
+
+// RankRoutes fills in each route's driver TrustScore, ComputedRoute.UUID being the driver,
+// and orders them most trusted first, then closest
+func (rc *RideChain) RankRoutes(routes []ComputedRoute) []ComputedRoute {
+	ranked := make([]ComputedRoute, len(routes))
+	copy(ranked, routes)
+	for i := range ranked {
+		ranked[i].TrustScore = rc.Reputation(ranked[i].UUID).TrustScore
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].TrustScore != ranked[j].TrustScore {
+			return ranked[i].TrustScore > ranked[j].TrustScore
+		}
+		return ranked[i].MinutesAway < ranked[j].MinutesAway
+	})
+	return ranked
+}
+
+// Completed reports whether the ride was dropped off and committed, not cancelled or expired
+func (tx RideTx) Completed() bool {
+	return tx.DropoffConfirmed && !tx.Cancelled() && !tx.Expired()
+}
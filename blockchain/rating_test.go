@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideChain_SubmitRating(t *testing.T) {
+	driver, rider := "genesis-123", "rider-rating"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	txID := completeTestRide(t, rc, testRideTx(driver, rider), driver)
+
+	riderPub, riderKey, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey(rider, riderPub))
+	_, driverKey, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	// the driver's node signs as a validator, so ratings are committed as they arrive
+	assert.Nil(t, rc.SetSigner(driver, driverKey))
+
+	tests := []struct {
+		name    string
+		rating  func() Rating
+		wantErr bool
+	}{
+		{
+			name:    "unsigned rating",
+			rating:  func() Rating { return NewRating(txID, rider, driver, 5, "") },
+			wantErr: true,
+		},
+		{
+			name: "rating signed by the other party",
+			rating: func() Rating {
+				r := NewRating(txID, rider, driver, 5, "")
+				r.Sign(driverKey)
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "rating someone who was not on the ride",
+			rating: func() Rating {
+				r := NewRating(txID, rider, "stranger", 1, "")
+				r.Sign(riderKey)
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "six stars",
+			rating: func() Rating {
+				r := NewRating(txID, rider, driver, 6, "")
+				r.Sign(riderKey)
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "backdated rating",
+			rating: func() Rating {
+				r := NewRating(txID, rider, driver, 1, "")
+				r.Timestamp = r.Timestamp.Add(-24 * time.Hour)
+				r.Sign(riderKey)
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "future dated rating",
+			rating: func() Rating {
+				r := NewRating(txID, rider, driver, 5, "")
+				r.Timestamp = r.Timestamp.Add(24 * time.Hour)
+				r.Sign(riderKey)
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "rider rates the driver",
+			rating: func() Rating {
+				r := NewRating(txID, rider, driver, 5, "smooth ride")
+				r.Sign(riderKey)
+				return r
+			},
+		},
+		{
+			name: "rider rates the driver twice",
+			rating: func() Rating {
+				r := NewRating(txID, rider, driver, 1, "changed my mind")
+				r.Sign(riderKey)
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "driver rates the rider",
+			rating: func() Rating {
+				r := NewRating(txID, driver, rider, 4, "")
+				r.Sign(driverKey)
+				return r
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rc.SubmitRating(tt.rating())
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+
+	_, ok := rc.RecordBlock(RecordRating, ratingID(txID, rider))
+	assert.True(t, ok, "the rating is committed in a block")
+
+	assert.Nil(t, rc.Save())
+	reopened, err := OpenRideChain(filepath.Dir(rc.TokenLedger.filename))
+	assert.Nil(t, err)
+	assert.Len(t, reopened.Ratings[txID], 2)
+
+	profile := reopened.ValidatorProfile(driver)
+	assert.Equal(t, 1, profile.RidesServed)
+	assert.InDelta(t, 5.0, profile.AvgRating, 0.001)
+	assert.InDelta(t, 2.0/3, profile.TrustScore, 0.001, "one 5 star rating against the 3 star prior")
+}
+
+func TestRideChain_Reputation(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return start.Add(90 * 24 * time.Hour) }
+
+	rc.Ratings["ride-old"] = map[string]Rating{
+		"rider-a": {TxID: "ride-old", Rater: "rider-a", Ratee: "driver-rep", Stars: 1, Timestamp: start},
+	}
+	rc.Ratings["ride-new"] = map[string]Rating{
+		"rider-b": {TxID: "ride-new", Rater: "rider-b", Ratee: "driver-rep", Stars: 5, Timestamp: now()},
+	}
+
+	rep := rc.Reputation("driver-rep")
+	assert.Equal(t, 2, rep.Ratings)
+	// the old rating is one half-life old so counts half as much
+	assert.InDelta(t, (0.5*1+5)/1.5, rep.AvgRating, 0.001)
+
+	fresh := rc.Reputation("driver-new")
+	assert.Equal(t, 0.0, fresh.AvgRating)
+	assert.Equal(t, 0.5, fresh.TrustScore)
+
+	ranked := rc.RankRoutes([]ComputedRoute{
+		{UUID: "driver-new", MinutesAway: 2},
+		{UUID: "driver-rep", MinutesAway: 5},
+		{UUID: "driver-other", MinutesAway: 1},
+	})
+	assert.Equal(t, "driver-rep", ranked[0].UUID)
+	assert.Equal(t, "driver-other", ranked[1].UUID)
+	assert.Equal(t, "driver-new", ranked[2].UUID)
+	assert.Equal(t, rep.TrustScore, ranked[0].TrustScore)
+}
@@ -0,0 +1,187 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// RecordAdjustment is a settled tip, toll or wait time, see Adjustment
+	RecordAdjustment = "adjustment"
+	// RecordRating is a signed rating, see SubmitRating
+	RecordRating = "rating"
+	// RecordDispute is a dispute with its signed evidence and votes, see OpenDispute
+	RecordDispute = "dispute"
+	// RecordVerification is a driver's verification round with its attestations, see RequestDriverVerification
+	RecordVerification = "verification"
+	// RecordVehicleClaim is a driver's vehicle registration waiting for a validator, see RegisterVehicle
+	RecordVehicleClaim = "vehicleClaim"
+	// RecordVehicle is a confirmed vehicle with its owners and insurance, see ConfirmVehicle
+	RecordVehicle = "vehicle"
+	// RecordRefund is an authorized or issued refund, see ApproveRefund
+	RecordRefund = "refund"
+	// RecordSettlement is a period's PayoutBatch, see SettleEarnings
+	RecordSettlement = "settlement"
+)
+
+// Record is ride state other than a RideTx that a block commits, Data is the
+// json encoded record of Kind, e.g. an Adjustment for RecordAdjustment
+type Record struct {
+	Kind string
+	ID   string
+	Data json.RawMessage
+}
+
+func (r Record) key() string {
+	return r.Kind + "/" + r.ID
+}
+
+// digest is what the block hash covers for the record
+func (r Record) digest() string {
+	sum := sha256.Sum256(r.Data)
+	return fmt.Sprintf("|%s:%s:%x", r.Kind, r.ID, sum)
+}
+
+// queueRecord records v in the next block this node produces, a record queued
+// again before then replaces the earlier version
+func (rc *RideChain) queueRecord(kind, id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	record := Record{Kind: kind, ID: id, Data: data}
+	for i, pending := range rc.pendingRecords {
+		if pending.key() == record.key() {
+			rc.pendingRecords[i] = record
+			return nil
+		}
+	}
+	rc.pendingRecords = append(rc.pendingRecords, record)
+	return nil
+}
+
+// recordQueued reports whether the record is waiting for the next block
+func (rc *RideChain) recordQueued(kind, id string) bool {
+	for _, pending := range rc.pendingRecords {
+		if pending.Kind == kind && pending.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dropRecord takes a record that could not be committed back out of the queue
+func (rc *RideChain) dropRecord(kind, id string) {
+	for i, pending := range rc.pendingRecords {
+		if pending.Kind == kind && pending.ID == id {
+			rc.pendingRecords = append(rc.pendingRecords[:i], rc.pendingRecords[i+1:]...)
+			return
+		}
+	}
+}
+
+// flushRecords commits the queued records right away when this node signs as a validator,
+// otherwise they wait for the next block this node produces
+func (rc *RideChain) flushRecords() error {
+	if len(rc.pendingRecords) == 0 || !rc.IsValidator(rc.signerUUID) {
+		return nil
+	}
+	return rc.commitRideTxs(nil, map[string]bool{rc.signerUUID: true})
+}
+
+// RecordBlock returns the hash of the canonical block that committed the record
+func (rc *RideChain) RecordBlock(kind, id string) (string, bool) {
+	hash, ok := rc.committedRecords[kind+"/"+id]
+	return hash, ok
+}
+
+// applyRecord adopts a record committed by a block, records this node already
+// holds are kept as they are
+func (rc *RideChain) applyRecord(r Record, blockHash string) error {
+	rc.committedRecords[r.key()] = blockHash
+	switch r.Kind {
+	case RecordAdjustment:
+		if _, ok := rc.Adjustments[r.ID]; ok {
+			return nil
+		}
+		var adj Adjustment
+		if err := json.Unmarshal(r.Data, &adj); err != nil {
+			return fmt.Errorf("decode adjustment %s: %w", r.ID, err)
+		}
+		rc.Adjustments[r.ID] = &adj
+	case RecordRating:
+		var rating Rating
+		if err := json.Unmarshal(r.Data, &rating); err != nil {
+			return fmt.Errorf("decode rating %s: %w", r.ID, err)
+		}
+		if rc.Ratings[rating.TxID] == nil {
+			rc.Ratings[rating.TxID] = make(map[string]Rating)
+		}
+		rc.Ratings[rating.TxID][rating.Rater] = rating
+	case RecordDispute:
+		var d Dispute
+		if err := json.Unmarshal(r.Data, &d); err != nil {
+			return fmt.Errorf("decode dispute %s: %w", r.ID, err)
+		}
+		rc.adoptDispute(&d)
+	case RecordVerification:
+		var request DriverVerificationRequest
+		if err := json.Unmarshal(r.Data, &request); err != nil {
+			return fmt.Errorf("decode verification %s: %w", r.ID, err)
+		}
+		rc.adoptVerification(request)
+	case RecordVehicleClaim, RecordVehicle:
+		var record VehicleRecord
+		if err := json.Unmarshal(r.Data, &record); err != nil {
+			return fmt.Errorf("decode vehicle %s: %w", r.ID, err)
+		}
+		if r.Kind == RecordVehicleClaim {
+			rc.adoptVehicleClaim(r.ID, &record)
+		} else {
+			rc.adoptVehicle(&record)
+		}
+	case RecordRefund:
+		var refund Refund
+		if err := json.Unmarshal(r.Data, &refund); err != nil {
+			return fmt.Errorf("decode refund %s: %w", r.ID, err)
+		}
+		rc.adoptRefund(&refund)
+	case RecordSettlement:
+		if _, ok := rc.Payouts[r.ID]; ok {
+			return nil
+		}
+		var batch PayoutBatch
+		if err := json.Unmarshal(r.Data, &batch); err != nil {
+			return fmt.Errorf("decode payout batch %s: %w", r.ID, err)
+		}
+		rc.applyPayoutBatch(&batch)
+	}
+	return nil
+}
+
+// rollbackRecord forgets a record whose block left the canonical chain, ratings stop
+// counting toward reputations until they are committed again
+func (rc *RideChain) rollbackRecord(r Record) {
+	delete(rc.committedRecords, r.key())
+	if r.Kind != RecordRating {
+		return
+	}
+	var rating Rating
+	if err := json.Unmarshal(r.Data, &rating); err == nil {
+		delete(rc.Ratings[rating.TxID], rating.Rater)
+	}
+}
+
+// requeueRecords puts records of orphaned blocks back in the queue unless the
+// new canonical chain also committed them
+func (rc *RideChain) requeueRecords(b *Block) {
+	for _, r := range b.Records {
+		if _, ok := rc.committedRecords[r.key()]; ok {
+			continue
+		}
+		if !rc.recordQueued(r.Kind, r.ID) {
+			rc.pendingRecords = append(rc.pendingRecords, r)
+		}
+	}
+}
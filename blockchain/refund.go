@@ -0,0 +1,353 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrRefundExceedsPayment is returned when refunds on a ride would add up to more than the rider paid
+var ErrRefundExceedsPayment = errors.New("refund exceeds payment")
+
+type RefundStatus string
+
+const (
+	// RefundProposed waits for ApprovalQuorum validators
+	RefundProposed RefundStatus = "proposed"
+	// RefundAuthorized has debited the driver and waits for the payment provider to accept the instruction
+	RefundAuthorized RefundStatus = "authorized"
+	// RefundIssued was accepted by the payment provider
+	RefundIssued RefundStatus = "issued"
+	// RefundRejected was withdrawn by its proposer or rejected by a validator before quorum
+	RefundRejected RefundStatus = "rejected"
+)
+
+// Refund returns part or all of a ride's fare to the rider, amounts are cents
+type Refund struct {
+	ID         string `json:"id"`
+	TxID       string `json:"txID"` // empty only for a dispute decided while the ride was pending
+	SessionID  string `json:"sessionId"`
+	DriverUUID string `json:"driverUUID"`
+	RiderUUID  string `json:"riderUUID"`
+	PaidAmount int    `json:"paidAmount"` // the fare refunds on the ride add up to at most
+	Amount     int    `json:"amount"`
+	Reason     string `json:"reason"`
+	// RequestedBy proposed the refund, DisputeID is set instead when a dispute panel decided it
+	RequestedBy  string          `json:"requestedBy,omitempty"`
+	DisputeID    string          `json:"disputeID,omitempty"`
+	Timestamp    time.Time       `json:"timestamp"`
+	Approvals    map[string]bool `json:"approvals,omitempty"` // validatorUUID -> approved
+	RejectedBy   string          `json:"rejectedBy,omitempty"`
+	Status       RefundStatus    `json:"status"`
+	AuthorizedAt time.Time       `json:"authorizedAt"`
+	// ProviderRefundID is the payment provider's reference once the instruction was accepted
+	ProviderRefundID string    `json:"providerRefundId,omitempty"`
+	IssuedAt         time.Time `json:"issuedAt"`
+	LastError        string    `json:"lastError,omitempty"`
+}
+
+// RefundInstruction asks the payment provider to return Amount cents of the session to the rider
+type RefundInstruction struct {
+	// RefundID is the idempotency key, sending the same instruction twice refunds once
+	RefundID      string `json:"refundId"`
+	SessionID     string `json:"sessionId"`
+	PaymentIntent string `json:"paymentIntent,omitempty"` // known once the paid webhook arrived
+	Amount        int    `json:"amount"`
+	Reason        string `json:"reason"`
+}
+
+// PaymentRefunder is the payment adapter that carries out refund instructions
+type PaymentRefunder interface {
+	Refund(ctx context.Context, instruction RefundInstruction) (providerRefundID string, err error)
+}
+
+// ProposeRefund asks validators to refund amount cents of a committed ride, zero refunds
+// everything not already refunded, the rider, the driver or a validator may propose
+func (rc *RideChain) ProposeRefund(txID string, amount int, requestedBy, reason string) (*Refund, error) {
+	tx, err := rc.RideTx(txID)
+	if err != nil {
+		return nil, err
+	}
+	if requestedBy != tx.RiderUUID && requestedBy != tx.DriverUUID && !rc.IsValidator(requestedBy) {
+		return nil, fmt.Errorf("%s cannot propose a refund on ride %s", requestedBy, txID)
+	}
+	refund, err := rc.newRefund(txID, tx.StripeSessionId, tx.DriverUUID, tx.RiderUUID, tx.PaidAmount, amount, reason)
+	if err != nil {
+		return nil, err
+	}
+	refund.RequestedBy = requestedBy
+	refund.Approvals = make(map[string]bool)
+	rc.Refunds[refund.ID] = refund
+	fmt.Printf("Refund %s proposed by %s: %d on ride %s\n", refund.ID, requestedBy, refund.Amount, txID)
+	return refund, nil
+}
+
+// ApproveRefund records a validator's approval and authorizes the refund at ApprovalQuorum,
+// the rider cannot approve their own refund
+func (rc *RideChain) ApproveRefund(refundID, validatorUUID string) error {
+	if !rc.IsValidator(validatorUUID) {
+		return fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	refund, ok := rc.Refunds[refundID]
+	if !ok {
+		return fmt.Errorf("refund %s not found", refundID)
+	}
+	if refund.Status != RefundProposed {
+		return fmt.Errorf("refund %s already %s", refundID, refund.Status)
+	}
+	if validatorUUID == refund.RiderUUID || validatorUUID == refund.RequestedBy {
+		return fmt.Errorf("validator %s cannot approve their own refund", validatorUUID)
+	}
+	if refund.Approvals[validatorUUID] {
+		return fmt.Errorf("validator %s already approved refund %s", validatorUUID, refundID)
+	}
+	// another refund on the ride may have been authorized since this one was proposed
+	if remaining := rc.refundable(refund.TxID, refund.SessionID, refund.PaidAmount); refund.Amount > remaining {
+		return fmt.Errorf("%w: refund %s asks for %d, %d left to refund", ErrRefundExceedsPayment, refundID, refund.Amount, remaining)
+	}
+	refund.Approvals[validatorUUID] = true
+
+	if len(refund.Approvals) < rc.ApprovalQuorum {
+		return nil
+	}
+	if err := rc.authorizeRefund(refund); err != nil {
+		delete(refund.Approvals, validatorUUID)
+		return err
+	}
+	// the approving validators commit the refund right away
+	if err := rc.commitRideTxs(nil, refund.Approvals); err != nil {
+		rc.unauthorizeRefund(refund)
+		delete(refund.Approvals, validatorUUID)
+		return err
+	}
+	rc.debitRefund(refund)
+	return nil
+}
+
+// RejectRefund withdraws a proposed refund when its proposer asks, or rejects it when a validator
+// other than the ride's rider or driver does, nothing is debited
+func (rc *RideChain) RejectRefund(refundID, uuid string) error {
+	refund, ok := rc.Refunds[refundID]
+	if !ok {
+		return fmt.Errorf("refund %s not found", refundID)
+	}
+	if refund.Status != RefundProposed {
+		return fmt.Errorf("refund %s already %s", refundID, refund.Status)
+	}
+	if uuid != refund.RequestedBy {
+		if !rc.IsValidator(uuid) {
+			return fmt.Errorf("%s is not a validator", uuid)
+		}
+		if uuid == refund.RiderUUID || uuid == refund.DriverUUID {
+			return fmt.Errorf("validator %s cannot reject a refund on their own ride", uuid)
+		}
+	}
+	refund.Status = RefundRejected
+	refund.RejectedBy = uuid
+	fmt.Printf("Refund %s rejected by %s\n", refund.ID, uuid)
+	return nil
+}
+
+// refundDispute authorizes the refund a dispute panel decided on
+func (rc *RideChain) refundDispute(d *Dispute, amount int) error {
+	sessionID := ""
+	if tx, err := rc.RideTx(d.TxID); err == nil {
+		sessionID = tx.StripeSessionId
+	} else if pending, ok := rc.PendingRideTxs[d.DriverUUID]; ok && pending.RiderUUID == d.RiderUUID {
+		sessionID = pending.StripeSessionId
+	}
+	// the panel cannot refund what validators already refunded
+	remaining := rc.refundable(d.TxID, sessionID, d.PaidAmount)
+	if remaining <= 0 {
+		return fmt.Errorf("%w: dispute %s decided on %d, the fare of %d was already refunded", ErrRefundExceedsPayment, d.ID, amount, d.PaidAmount)
+	}
+	refund, err := rc.newRefund(d.TxID, sessionID, d.DriverUUID, d.RiderUUID, d.PaidAmount, min(amount, remaining), d.Reason)
+	if err != nil {
+		return err
+	}
+	refund.DisputeID = d.ID
+	rc.Refunds[refund.ID] = refund
+	if err := rc.authorizeRefund(refund); err != nil {
+		delete(rc.Refunds, refund.ID)
+		return err
+	}
+	if err := rc.flushRecords(); err != nil {
+		rc.unauthorizeRefund(refund)
+		delete(rc.Refunds, refund.ID)
+		return err
+	}
+	rc.debitRefund(refund)
+	return nil
+}
+
+// newRefund checks amount against what is left of paid after earlier refunds on the session
+func (rc *RideChain) newRefund(txID, sessionID, driverUUID, riderUUID string, paid, amount int, reason string) (*Refund, error) {
+	remaining := rc.refundable(txID, sessionID, paid)
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return nil, fmt.Errorf("%w: %d requested, %d of %d left to refund", ErrRefundExceedsPayment, amount, remaining, paid)
+	}
+	return &Refund{
+		ID:         uuid.NewString(),
+		TxID:       txID,
+		SessionID:  sessionID,
+		DriverUUID: driverUUID,
+		RiderUUID:  riderUUID,
+		PaidAmount: paid,
+		Amount:     amount,
+		Reason:     reason,
+		Timestamp:  now().UTC(),
+		Status:     RefundProposed,
+	}, nil
+}
+
+// refundable is what is left of paid after the authorized and issued refunds on the ride or session,
+// proposals only count once validators approve them
+func (rc *RideChain) refundable(txID, sessionID string, paid int) int {
+	remaining := paid
+	for _, r := range rc.Refunds {
+		if r.Status != RefundAuthorized && r.Status != RefundIssued {
+			continue
+		}
+		if (txID != "" && r.TxID == txID) || (sessionID != "" && r.SessionID == sessionID) {
+			remaining -= r.Amount
+		}
+	}
+	return remaining
+}
+
+// authorizeRefund marks the refund authorized and queues it to be committed,
+// the driver is debited with debitRefund once the refund is committed
+func (rc *RideChain) authorizeRefund(refund *Refund) error {
+	refund.Status = RefundAuthorized
+	refund.AuthorizedAt = now()
+	if err := rc.queueRecord(RecordRefund, refund.ID, refund); err != nil {
+		rc.unauthorizeRefund(refund)
+		return err
+	}
+	return nil
+}
+
+// unauthorizeRefund undoes authorizeRefund when the refund could not be committed
+func (rc *RideChain) unauthorizeRefund(refund *Refund) {
+	rc.dropRecord(RecordRefund, refund.ID)
+	refund.Status = RefundProposed
+	refund.AuthorizedAt = time.Time{}
+}
+
+// debitRefund debits the driver's fiat Earnings, not their tokens, and sends the instruction to the
+// payment adapter, an instruction the adapter could not take stays authorized until IssueRefund is retried
+func (rc *RideChain) debitRefund(refund *Refund) {
+	rc.Earnings[refund.DriverUUID] -= refund.Amount
+	fmt.Printf("Refund %s authorized: %d cents debited from driver %s\n", refund.ID, refund.Amount, refund.DriverUUID)
+
+	if err := rc.issueRefund(refund); err != nil {
+		fmt.Printf("Refund %s not issued yet: %v\n", refund.ID, err)
+	}
+}
+
+// adoptRefund takes a refund committed by a block unless this node's copy is as far along,
+// a refund authorized elsewhere debits the driver's Earnings here too so settlements agree
+func (rc *RideChain) adoptRefund(r *Refund) {
+	local, ok := rc.Refunds[r.ID]
+	if ok && refundProgress(local.Status) >= refundProgress(r.Status) {
+		return
+	}
+	if refundProgress(r.Status) >= refundProgress(RefundAuthorized) && (!ok || refundProgress(local.Status) < refundProgress(RefundAuthorized)) {
+		rc.Earnings[r.DriverUUID] -= r.Amount
+	}
+	if ok {
+		*local = *r
+		return
+	}
+	rc.Refunds[r.ID] = r
+}
+
+// refundProgress orders refund statuses, a rejected refund never goes further
+func refundProgress(status RefundStatus) int {
+	switch status {
+	case RefundRejected:
+		return 1
+	case RefundAuthorized:
+		return 2
+	case RefundIssued:
+		return 3
+	}
+	return 0
+}
+
+// IssueRefund retries sending an authorized refund's instruction to the payment adapter
+func (rc *RideChain) IssueRefund(refundID string) error {
+	refund, ok := rc.Refunds[refundID]
+	if !ok {
+		return fmt.Errorf("refund %s not found", refundID)
+	}
+	if refund.Status != RefundAuthorized {
+		return fmt.Errorf("refund %s is %s, not awaiting the payment provider", refundID, refund.Status)
+	}
+	return rc.issueRefund(refund)
+}
+
+func (rc *RideChain) issueRefund(refund *Refund) error {
+	if rc.PaymentRefunder == nil {
+		refund.LastError = "no payment adapter configured"
+		return errors.New(refund.LastError)
+	}
+	instruction := refund.Instruction()
+	if instruction.PaymentIntent == "" {
+		instruction.PaymentIntent = rc.paymentIntent(refund.SessionID)
+	}
+	providerID, err := rc.PaymentRefunder.Refund(context.Background(), instruction)
+	if err != nil {
+		refund.LastError = err.Error()
+		return err
+	}
+	refund.Status = RefundIssued
+	refund.ProviderRefundID = providerID
+	refund.IssuedAt = now()
+	refund.LastError = ""
+	fmt.Printf("Refund %s issued as %s\n", refund.ID, providerID)
+	// the issued status goes out with the next block this node produces
+	return rc.queueRecord(RecordRefund, refund.ID, refund)
+}
+
+// Instruction is what the payment adapter is asked to do for the refund
+func (r Refund) Instruction() RefundInstruction {
+	return RefundInstruction{
+		RefundID:  r.ID,
+		SessionID: r.SessionID,
+		Amount:    r.Amount,
+		Reason:    r.Reason,
+	}
+}
+
+// paymentIntent returns the payment intent a paid webhook recorded for the session
+func (rc *RideChain) paymentIntent(sessionID string) string {
+	for _, evt := range rc.Payments[sessionID] {
+		if evt.PaymentIntent != "" {
+			return evt.PaymentIntent
+		}
+	}
+	return ""
+}
+
+// rideRefunds returns the authorized and issued refunds on a ride in time order
+func (rc *RideChain) rideRefunds(tx RideTx) []Refund {
+	var refunds []Refund
+	for _, r := range rc.Refunds {
+		if r.Status != RefundAuthorized && r.Status != RefundIssued {
+			continue
+		}
+		if r.TxID == tx.TxID || (r.TxID == "" && r.SessionID == tx.StripeSessionId) {
+			refunds = append(refunds, *r)
+		}
+	}
+	sort.Slice(refunds, func(i, j int) bool { return refunds[i].Timestamp.Before(refunds[j].Timestamp) })
+	return refunds
+}
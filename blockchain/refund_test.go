@@ -0,0 +1,227 @@
+package blockchain
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideChain_ProposeRefund(t *testing.T) {
+	driver, rider := "driver-refund", "rider-refund"
+
+	tests := []struct {
+		name        string
+		earlier     []int // refunds already authorized on the ride
+		proposed    []int // refunds still waiting for approval
+		requestedBy string
+		amount      int
+		wantErr     bool
+		wantErrIs   error
+		wantAmount  int
+	}{
+		{name: "rider asks for everything", requestedBy: rider, wantAmount: 500},
+		{name: "driver offers a partial refund", requestedBy: driver, amount: 150, wantAmount: 150},
+		{name: "validator proposes the rest after a partial refund", earlier: []int{150}, requestedBy: "validator-1", wantAmount: 350},
+		{name: "pending proposals do not hold back the rider", proposed: []int{500}, requestedBy: rider, wantAmount: 500},
+		{name: "more than the fare", requestedBy: rider, amount: 600, wantErr: true, wantErrIs: ErrRefundExceedsPayment},
+		{name: "more than is left", earlier: []int{400}, requestedBy: rider, amount: 200, wantErr: true, wantErrIs: ErrRefundExceedsPayment},
+		{name: "nothing left", earlier: []int{500}, requestedBy: rider, wantErr: true, wantErrIs: ErrRefundExceedsPayment},
+		{name: "not a party or validator", requestedBy: "someone-else", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, txID, _ := newDisputeChain(t, driver, rider)
+			for _, amount := range tt.earlier {
+				earlier, err := rc.ProposeRefund(txID, amount, rider, "earlier")
+				assert.Nil(t, err)
+				assert.Nil(t, rc.ApproveRefund(earlier.ID, "validator-1"))
+			}
+			for _, amount := range tt.proposed {
+				_, err := rc.ProposeRefund(txID, amount, driver, "offered")
+				assert.Nil(t, err)
+			}
+
+			refund, err := rc.ProposeRefund(txID, tt.amount, tt.requestedBy, "driver took a detour")
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				if tt.wantErrIs != nil {
+					assert.True(t, errors.Is(err, tt.wantErrIs), "got %v", err)
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantAmount, refund.Amount)
+			assert.Equal(t, RefundProposed, refund.Status)
+			assert.Equal(t, -sum(tt.earlier), rc.Earnings[driver], "nothing more is debited before validators approve")
+		})
+	}
+}
+
+func TestRideChain_ApproveRefund(t *testing.T) {
+	driver, rider := "driver-refund", "rider-refund"
+	rc, txID, keys := newDisputeChain(t, driver, rider)
+	rc.ApprovalQuorum = 2
+	stripe := newFakeStripeServer(t)
+	tx, err := rc.RideTx(txID)
+	assert.Nil(t, err)
+	stripe.pay(tx.StripeSessionId, tx.PaidAmount)
+	rc.PaymentRefunder = stripe.verifier()
+
+	refund, err := rc.ProposeRefund(txID, 200, rider, "driver took a detour")
+	assert.Nil(t, err)
+	assert.NotNil(t, rc.ApproveRefund(refund.ID, rider), "not a validator")
+	assert.Nil(t, rc.ApproveRefund(refund.ID, "validator-1"))
+	assert.NotNil(t, rc.ApproveRefund(refund.ID, "validator-1"), "approved twice")
+	assert.Equal(t, RefundProposed, refund.Status)
+
+	// stripe is down when quorum is reached, the refund is authorized on-chain regardless
+	stripe.outage()
+	assert.Nil(t, rc.ApproveRefund(refund.ID, "validator-2"))
+	assert.Equal(t, RefundAuthorized, refund.Status)
+	assert.NotEmpty(t, refund.LastError)
+	assert.Equal(t, -200, rc.Earnings[driver])
+	assert.Equal(t, 1000, rc.TokenLedger.Balances[driver], "refunds are fiat and leave the driver's tokens alone")
+	assert.NotNil(t, rc.ApproveRefund(refund.ID, "validator-3"), "already authorized")
+
+	stripe.restore()
+	assert.Nil(t, rc.IssueRefund(refund.ID))
+	assert.Equal(t, RefundIssued, refund.Status)
+	assert.Equal(t, "re_"+refund.ID, refund.ProviderRefundID)
+	assert.Empty(t, refund.LastError)
+	form, ok := stripe.refunded(refund.ID)
+	assert.True(t, ok)
+	assert.Equal(t, "pi_"+tx.StripeSessionId, form.Get("payment_intent"))
+	assert.Equal(t, "200", form.Get("amount"))
+	assert.NotNil(t, rc.IssueRefund(refund.ID), "already issued")
+
+	history, err := rc.RideHistory(txID)
+	assert.Nil(t, err)
+	assert.Len(t, history.Refunds, 1)
+	assert.Equal(t, 300, history.DriverEarned)
+
+	_, err = rc.ProposeRefund(txID, 400, rider, "")
+	assert.True(t, errors.Is(err, ErrRefundExceedsPayment), "only 300 is left to refund")
+
+	// two proposals for the rest, only the first one approved fits
+	first, err := rc.ProposeRefund(txID, 0, rider, "")
+	assert.Nil(t, err)
+	second, err := rc.ProposeRefund(txID, 300, "validator-3", "")
+	assert.Nil(t, err)
+	assert.Nil(t, rc.ApproveRefund(first.ID, "validator-1"))
+	assert.Nil(t, rc.ApproveRefund(first.ID, "validator-2"))
+	err = rc.ApproveRefund(second.ID, "validator-1")
+	assert.True(t, errors.Is(err, ErrRefundExceedsPayment), "got %v", err)
+	assert.Nil(t, rc.RejectRefund(second.ID, "validator-1"))
+	assert.Equal(t, -500, rc.Earnings[driver])
+
+	// a dispute panel cannot refund the fare again
+	d, err := openTestDispute(t, rc, keys, txID, driver, rider, "took a longer route")
+	assert.Nil(t, err)
+	assert.Nil(t, voteTestDispute(t, rc, keys, d.ID, d.Panel[0], OutcomeRefund, 0))
+	err = voteTestDispute(t, rc, keys, d.ID, d.Panel[1], OutcomeRefund, 0)
+	assert.True(t, errors.Is(err, ErrRefundExceedsPayment), "got %v", err)
+	assert.Equal(t, DisputeOpen, d.Status)
+}
+
+func TestRideChain_RefundRecords(t *testing.T) {
+	driver, rider := "driver-refund", "rider-refund"
+	rc, txID, _ := newDisputeChain(t, driver, rider)
+	stripe := newFakeStripeServer(t)
+	tx, err := rc.RideTx(txID)
+	assert.Nil(t, err)
+	stripe.pay(tx.StripeSessionId, tx.PaidAmount)
+	rc.PaymentRefunder = stripe.verifier()
+	refund, err := rc.ProposeRefund(txID, 200, rider, "driver took a detour")
+	assert.Nil(t, err)
+
+	// a refund whose block is refused is not authorized and debits nothing
+	finalized := rc.Chain.Finalized
+	rc.Chain.Finalized = "not-a-block"
+	assert.NotNil(t, rc.ApproveRefund(refund.ID, "validator-1"))
+	assert.Equal(t, RefundProposed, refund.Status)
+	assert.Empty(t, refund.Approvals)
+	assert.Equal(t, 0, rc.Earnings[driver])
+	assert.False(t, rc.recordQueued(RecordRefund, refund.ID))
+	_, ok := stripe.refunded(refund.ID)
+	assert.False(t, ok, "nothing is sent to the payment provider")
+	rc.Chain.Finalized = finalized
+
+	assert.Nil(t, rc.ApproveRefund(refund.ID, "validator-1"))
+	assert.Equal(t, RefundIssued, refund.Status)
+	assert.Equal(t, -200, rc.Earnings[driver], "debited once although the producer applied its own block")
+	hash, ok := rc.RecordBlock(RecordRefund, refund.ID)
+	assert.True(t, ok, "the authorized refund is committed in a block")
+
+	// a node that did not approve the refund debits the driver from the block
+	peer, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	authorized, _ := rc.Chain.Get(hash)
+	for _, r := range authorized.Records {
+		assert.Nil(t, peer.applyRecord(r, hash))
+		assert.Nil(t, peer.applyRecord(r, hash))
+	}
+	assert.Equal(t, RefundAuthorized, peer.Refunds[refund.ID].Status)
+	assert.Equal(t, -200, peer.Earnings[driver], "applying the record again debits nothing more")
+
+	// the issued status follows with the next block
+	assert.True(t, rc.recordQueued(RecordRefund, refund.ID))
+	assert.Nil(t, rc.commitRideTxs(nil, map[string]bool{"validator-1": true}))
+	issued, _ := rc.Chain.Get(rc.Chain.Head)
+	for _, r := range issued.Records {
+		assert.Nil(t, peer.applyRecord(r, issued.Hash))
+	}
+	assert.Equal(t, RefundIssued, peer.Refunds[refund.ID].Status)
+	assert.Equal(t, "re_"+refund.ID, peer.Refunds[refund.ID].ProviderRefundID)
+	assert.Equal(t, -200, peer.Earnings[driver])
+}
+
+func TestRideChain_RejectRefund(t *testing.T) {
+	driver, rider := "driver-refund", "rider-refund"
+
+	tests := []struct {
+		name        string
+		requestedBy string
+		rejectedBy  string
+		wantErr     bool
+	}{
+		{name: "rider withdraws their proposal", requestedBy: rider, rejectedBy: rider},
+		{name: "validator rejects the rider's proposal", requestedBy: rider, rejectedBy: "validator-1"},
+		{name: "validator withdraws their own proposal", requestedBy: "validator-1", rejectedBy: "validator-1"},
+		{name: "driver who is a validator cannot reject a refund of their ride", requestedBy: rider, rejectedBy: driver, wantErr: true},
+		{name: "rider cannot reject a validator's proposal", requestedBy: "validator-1", rejectedBy: rider, wantErr: true},
+		{name: "not a party or validator", requestedBy: rider, rejectedBy: "someone-else", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, txID, _ := newDisputeChain(t, driver, rider)
+			refund, err := rc.ProposeRefund(txID, 200, tt.requestedBy, "driver took a detour")
+			assert.Nil(t, err)
+
+			err = rc.RejectRefund(refund.ID, tt.rejectedBy)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				assert.Equal(t, RefundProposed, refund.Status)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, RefundRejected, refund.Status)
+			assert.Equal(t, tt.rejectedBy, refund.RejectedBy)
+			assert.NotNil(t, rc.ApproveRefund(refund.ID, "validator-2"), "rejected refunds take no approvals")
+			assert.NotNil(t, rc.RejectRefund(refund.ID, tt.rejectedBy), "already rejected")
+			assert.Equal(t, 0, rc.Earnings[driver])
+			history, err := rc.RideHistory(txID)
+			assert.Nil(t, err)
+			assert.Empty(t, history.Refunds)
+		})
+	}
+}
+
+func sum(amounts []int) int {
+	total := 0
+	for _, amount := range amounts {
+		total += amount
+	}
+	return total
+}
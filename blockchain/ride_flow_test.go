@@ -1,7 +1,10 @@
 package blockchain
 
 import (
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -9,12 +12,23 @@ import (
 func TestRideFlow_Happy_Path(t *testing.T) {
 	driver := "genesis-123"
 	rider := "rider-abc"
-	rc, err := NewRideChain("test/token_ledger.json")
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
 	assert.Nil(t, err)
 
 	err = rc.BecomeValidator(driver)
 	assert.Nil(t, err)
 
+	rc.PendingVerifications[driver] = DriverVerificationRequest{DriverUUID: driver, Status: VerificationApproved, VerifiedAt: time.Now()}
+	vehicle, err := rc.RegisterVehicle(driver, "1HGCM82633A004352", Vehicle{Plate: "TN-GEN123", Seats: 4})
+	assert.Nil(t, err)
+	assert.NotNil(t, rc.ConfirmVehicle(vehicle.ID, driver, driver), "validators cannot confirm their own vehicle")
+	rc.TokenLedger.Mint("validator-2", 10)
+	assert.Nil(t, rc.StakeTokens(10, "validator-2"))
+	assert.Nil(t, rc.BecomeValidator("validator-2"))
+	assert.Nil(t, rc.ConfirmVehicle(vehicle.ID, driver, "validator-2"))
+	assert.NotNil(t, rc.InsureVehicle(vehicle.ID, driver, "policy-gen", time.Now().Add(24*time.Hour)), "nor insure it")
+	assert.Nil(t, rc.InsureVehicle(vehicle.ID, "validator-2", "policy-gen", time.Now().Add(24*time.Hour)))
+
 	// adds RideTx to pendingRideTxs
 	rideTxEvts := []RideTxEvt{}
 	rideTxEvts = append(rideTxEvts, RideTxEvt{
@@ -27,34 +41,123 @@ func TestRideFlow_Happy_Path(t *testing.T) {
 	tx, err := rc.SubmitPendingRideTx(RideTx{
 		RiderUUID:       rider,
 		DriverUUID:      driver,
-		PaidAmount:      100,
-		PickupCode:      "1931",
+		PaidAmount:      500, // minimum fare
 		StripeSessionId: "someStripeSuccessString",
 		ComputedRoute: ComputedRoute{
-			Destination: "some destination hopefully not final😅",
-		},
-		RideTxEvts: rideTxEvts,
-		PickupLocation: LatLng{
-			Lat: "36.00000",
-			Lng: "-86.00000",
+			Destination:         "some destination hopefully not final😅",
+			DestinationLocation: NewLatLng(36.1684, -86.8259),
 		},
+		RideTxEvts:     rideTxEvts,
+		PickupLocation: NewLatLng(36.0, -86.0),
+		Vehicle:        Vehicle{ID: vehicle.ID},
 	})
 	assert.Nil(t, err)
 
-	err = rc.SubmitPickupProof(tx, "1931")
+	err = rc.SubmitPickupProof(tx, tx.PickupCode, NewLatLng(36.0, -86.0))
 	assert.Nil(t, err)
 
-	err = rc.SubmitDropoff(tx, LatLng{Lat: "36.1684", Lng: "86.8259"})
-	assert.Nil(t, err)
+	driveTestRide(t, rc, tx, NewLatLng(36.1684, -86.8259))
 
 	// tx.TxID = generateRideHash(tx)
 	// happens here now
 	txID, err := rc.ApproveRideTx(tx, driver)
 	assert.Nil(t, err)
 
-	_, ok := RideLedger[txID]
+	_, ok := rc.RideLedger[txID]
 	assert.True(t, ok)
 
 	// todo assert RideTxEvts
 
 }
+
+// testRideTx returns a RideTx that passes ValidateRideTx
+func testRideTx(driver, rider string) RideTx {
+	return RideTx{
+		RiderUUID:       rider,
+		DriverUUID:      driver,
+		PaidAmount:      500, // minimum fare
+		StripeSessionId: "stripe-" + driver + "-" + rider,
+		ComputedRoute: ComputedRoute{
+			Destination:         "Broadway, Nashville",
+			DestinationLocation: NewLatLng(36.1584, -86.7760),
+		},
+		RideTxEvts: []RideTxEvt{
+			{EventType: RideRequested},
+			{EventType: DriverAccepted},
+			{EventType: RiderPaymentRecieved},
+		},
+		PickupLocation: NewLatLng(36.1627, -86.7816),
+		Vehicle:        testVehicle(driver),
+	}
+}
+
+func testVIN(driver string) string {
+	return "VIN-" + driver
+}
+
+func testVehicle(driver string) Vehicle {
+	return Vehicle{ID: VehicleID(testVIN(driver)), Plate: "TN-" + driver, Seats: 4}
+}
+
+// onboardTestDriver gives the driver an approved verification and an insured test vehicle
+func onboardTestDriver(t *testing.T, rc *RideChain, driver string) {
+	t.Helper()
+	if !rc.PendingVerifications[driver].Active(now()) {
+		rc.PendingVerifications[driver] = DriverVerificationRequest{
+			DriverUUID: driver,
+			Status:     VerificationApproved,
+			VerifiedAt: now(),
+			ExpiresAt:  now().Add(rc.VerificationPolicy.ValidFor),
+		}
+	}
+	if _, ok := rc.Vehicles[testVehicle(driver).ID]; !ok {
+		confirmer := testConfirmer(rc, driver)
+		if confirmer == "" {
+			// no other validator to confirm it, the vehicle is filed on this node only
+			vehicle := testVehicle(driver)
+			vehicle.Plate = normalizePlate(vehicle.Plate)
+			rc.Vehicles[vehicle.ID] = &VehicleRecord{Vehicle: vehicle, VIN: normalizeVIN(testVIN(driver)), Owners: []string{driver}}
+		} else {
+			record, err := rc.RegisterVehicle(driver, testVIN(driver), testVehicle(driver))
+			assert.Nil(t, err)
+			assert.Nil(t, rc.ConfirmVehicle(record.ID, driver, confirmer))
+		}
+		rc.Vehicles[testVehicle(driver).ID].InsuredUntil = now().Add(365 * 24 * time.Hour)
+	}
+}
+
+// testConfirmer picks a validator other than the driver to confirm their vehicle
+func testConfirmer(rc *RideChain, driver string) string {
+	validators := make([]string, 0, len(rc.Validators))
+	for uuid := range rc.Validators {
+		if uuid != driver {
+			validators = append(validators, uuid)
+		}
+	}
+	if len(validators) == 0 {
+		return ""
+	}
+	sort.Strings(validators)
+	return validators[0]
+}
+
+// completeTestRide submits, picks up, drops off and approves a ride returning its TxID
+func completeTestRide(t *testing.T, rc *RideChain, tx RideTx, validator string) string {
+	t.Helper()
+	onboardTestDriver(t, rc, tx.DriverUUID)
+	tx, err := rc.SubmitPendingRideTx(tx)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+	driveTestRide(t, rc, tx, NewLatLng(36.1584, -86.7760))
+	txID, err := rc.ApproveRideTx(tx, validator)
+	assert.Nil(t, err)
+	return txID
+}
+
+// driveTestRide submits a straight GPS trace from the pickup to dropoff and confirms the dropoff
+func driveTestRide(t *testing.T, rc *RideChain, tx RideTx, dropoff LatLng) {
+	t.Helper()
+	trace := straightTrace(tx.PickupLocation, dropoff, time.Now().Add(-time.Hour), 100, 30*time.Second)
+	assert.Nil(t, rc.SubmitTrace(tx.DriverUUID, trace))
+	assert.Nil(t, rc.SubmitDropoff(tx, dropoff))
+}
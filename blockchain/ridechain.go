@@ -1,11 +1,14 @@
 package blockchain
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -16,14 +19,16 @@ type RideChainer interface {
 	StakeTokens(amount int, driverUUID string) error
 	SlashValidator(driverUUID string, slasher string, reason string) error
 	VerifyDriver(driverUUID string, validator string, results string) error
+	RejectDriver(driverUUID string, validator string, reason string) error
 	GetDriverStake(driverUUID string) int
 	IsValidator(driverUUID string) bool
 	RewardValidator(validatorUUID string, amount int) error
 	ApproveRideTx(tx RideTx, validatorUUID string) (string, error)
-	RequestDriverVerification(driverUUID, requestedBy string) error
-	SubmitPickupProof(tx RideTx, pickupCode string) error
+	RequestDriverVerification(driverUUID, requestedBy string, requestedAt time.Time, signature []byte) error
+	SubmitPickupProof(tx RideTx, pickupCode string, driverLocation LatLng) error
 	SubmitDropoff(tx RideTx, dropoffLocation LatLng) error
 	HasActiveRide(driverUUID string) bool
+	CancelRide(driverUUID string, reason CancellationReason, requestedBy string, signature []byte) (RideTx, error)
 }
 
 // RideChain represents the entire blockchain composed of rideTx
@@ -31,12 +36,111 @@ type RideChain struct {
 	DriverStakes map[string]int // driverUUID → amount
 	TokenLedger  *TokenLedger
 	Validators   map[string]bool // driverUUID -> isValidator
-	// PendingRideTxs map of riderUUID -> RideTx
+	// PendingRideTxs map of driverUUID -> RideTx
 	PendingRideTxs       map[string]RideTx
 	RideApprovals        map[string]map[string]bool // txID → validatorUUID → approval
 	ApprovalQuorum       int                        // min approvals required
 	PendingVerifications map[string]DriverVerificationRequest
 	minValidatorStake    int
+	// VerificationProviders are the background, insurance, license and registration checks VerifyDriver runs
+	VerificationProviders []VerificationProvider
+	// RequireVerifiedValidators rejects blocks from validators without an approved verification,
+	// otherwise only validators whose verification was flagged or lapsed are rejected
+	RequireVerifiedValidators bool
+	// MaxBlockClockSkew is how far a peer block's Timestamp may be from this node's clock when it
+	// arrives, requirements are checked at that Timestamp so a lapsed validator cannot backdate blocks
+	MaxBlockClockSkew time.Duration
+	// VerificationPolicy sets how many validators must attest to a driver and how long approval lasts
+	VerificationPolicy VerificationPolicy
+	// Vehicles map of vehicleID -> registered vehicle, drivers submit rides only in their own insured vehicles,
+	// kept in step with the chain through RecordVehicle
+	Vehicles map[string]*VehicleRecord
+	// PendingVehicles map of vehicleID:driverUUID -> registration waiting for a validator, see ConfirmVehicle
+	PendingVehicles map[string]*VehicleRecord
+	// Eligibility is the ride history, rating and KYC a driver needs to become a validator
+	Eligibility EligibilityPolicy
+
+	// Chain holds every known block, including competing forks
+	Chain *BlockTree
+	// RideLedger map of TxID -> ride committed on the canonical chain, rebuilt by replaying blocks
+	RideLedger map[string]RideTx
+	// FinalityDepth is how many blocks below the head a block becomes final
+	FinalityDepth int
+	// OrphanedRideTxs are rides rolled back by a reorg that could not return
+	// to the mempool because the driver already had another pending ride
+	OrphanedRideTxs []RideTx
+
+	// Fares prices rides, ValidateRideTx rejects rides paid off schedule
+	Fares FareSchedule
+	// PaymentVerifier confirms each ride's checkout session was paid, nil only checks sessions are not reused
+	PaymentVerifier PaymentVerifier
+	// Payments map of stripe sessionID -> paid, refunded and disputed callbacks, see ApplyPaymentEvent
+	Payments map[string][]PaymentEvent
+	// PaymentEventsFromWebhook drops payment events callers put on submitted rides, a node with
+	// a payment webhook only trusts the callbacks recorded in Payments
+	PaymentEventsFromWebhook bool
+	// PaymentRefunder carries out authorized refunds, nil leaves them authorized until IssueRefund is retried
+	PaymentRefunder PaymentRefunder
+	// Refunds map of refundID -> refund on a committed ride
+	Refunds map[string]*Refund
+	// Earnings map of driverUUID -> fiat cents owed outside the TokenLedger, refunds debit it
+	// and settlements pay it out, it is negative while a driver owes refunds
+	Earnings map[string]int
+	// SettlementPolicy sets the payout period, protocol fee and minimum payout
+	SettlementPolicy SettlementPolicy
+	// Payouts map of batchID -> every driver's settlement for a period, see SettleEarnings
+	Payouts map[string]*PayoutBatch
+
+	// CancellationPolicy sets the fees charged when a ride is cancelled
+	CancellationPolicy CancellationPolicy
+
+	// GeofencePolicy sets how close the driver must be to the pickup and destination
+	GeofencePolicy GeofencePolicy
+
+	// MaxPickupAttempts is how many wrong pickup codes a ride allows before the rider must reissue it
+	MaxPickupAttempts int
+	pickupSecrets     map[string]*pickupSecret // driverUUID -> secret for the ride's pickup code
+
+	// TracePolicy sets what a driver's GPS trace must show at dropoff
+	TracePolicy TracePolicy
+	// PendingTraces map of driverUUID -> breadcrumbs for the ride in progress
+	PendingTraces map[string][]TracePoint
+	// RouteTraces map of RouteHash -> encoded trace committed at dropoff
+	RouteTraces map[string][]byte
+
+	// ExpiryPolicy sets when the sweeper expires stalled rides and verifications
+	ExpiryPolicy  ExpiryPolicy
+	expiryMetrics ExpiryMetrics
+
+	// Adjustments map of adjustmentID -> tip, toll or wait time on a committed ride
+	Adjustments map[string]*Adjustment
+
+	// Ratings map of txID -> rater -> rating
+	Ratings map[string]map[string]Rating
+	// ReputationPolicy sets how ratings decay into reputations
+	ReputationPolicy ReputationPolicy
+
+	// Disputes map of disputeID -> dispute
+	Disputes map[string]*Dispute
+	// DisputePanelSize is how many validators hear each dispute
+	DisputePanelSize int
+
+	// AccountKeys map of uuid -> public key used to verify signatures
+	AccountKeys map[string]ed25519.PublicKey
+	// AccountNonces map of uuid -> last signed request nonce accepted, see UseNonce
+	AccountNonces map[string]uint64
+	signerUUID    string
+	signerKey     ed25519.PrivateKey
+
+	// pendingValidatorUpdates are recorded in the next block this node produces
+	pendingValidatorUpdates []ValidatorUpdate
+	// pendingRecords are committed in the next block this node produces
+	pendingRecords []Record
+	// committedRecords map of record kind/id -> canonical block hash, rebuilt by replaying blocks
+	committedRecords map[string]string
+	// blockTokenChanges map of block hash -> balance changes applying the canonical block made,
+	// rollbackBlock undoes them when the block leaves the canonical chain
+	blockTokenChanges map[string][]TokenChange
 }
 
 func NewRideChain(ledgeFileLocation string) (*RideChain, error) {
@@ -44,7 +148,7 @@ func NewRideChain(ledgeFileLocation string) (*RideChain, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &RideChain{
+	rc := &RideChain{
 		TokenLedger:          ledger,
 		DriverStakes:         make(map[string]int),
 		Validators:           make(map[string]bool),
@@ -53,25 +157,82 @@ func NewRideChain(ledgeFileLocation string) (*RideChain, error) {
 		ApprovalQuorum:       1, // for now there is only genesis validator
 		PendingVerifications: make(map[string]DriverVerificationRequest),
 		minValidatorStake:    10,
-	}, nil
+		Vehicles:             make(map[string]*VehicleRecord),
+		PendingVehicles:      make(map[string]*VehicleRecord),
+		Eligibility:          DefaultEligibilityPolicy(),
+		VerificationPolicy:   DefaultVerificationPolicy(),
+		Chain:                NewBlockTree(NewGenesisBlock()),
+		RideLedger:           make(map[string]RideTx),
+		FinalityDepth:        6,
+		MaxBlockClockSkew:    5 * time.Minute,
+		Fares:                DefaultFareSchedule(),
+		Payments:             make(map[string][]PaymentEvent),
+		Refunds:              make(map[string]*Refund),
+		Earnings:             make(map[string]int),
+		SettlementPolicy:     DefaultSettlementPolicy(),
+		Payouts:              make(map[string]*PayoutBatch),
+		CancellationPolicy:   DefaultCancellationPolicy(),
+		GeofencePolicy:       DefaultGeofencePolicy(),
+		MaxPickupAttempts:    5,
+		pickupSecrets:        make(map[string]*pickupSecret),
+		TracePolicy:          DefaultTracePolicy(),
+		PendingTraces:        make(map[string][]TracePoint),
+		RouteTraces:          make(map[string][]byte),
+		ExpiryPolicy:         DefaultExpiryPolicy(),
+		Adjustments:          make(map[string]*Adjustment),
+		Ratings:              make(map[string]map[string]Rating),
+		ReputationPolicy:     DefaultReputationPolicy(),
+		Disputes:             make(map[string]*Dispute),
+		committedRecords:     make(map[string]string),
+		blockTokenChanges:    make(map[string][]TokenChange),
+		DisputePanelSize:     3,
+		AccountKeys:          make(map[string]ed25519.PublicKey),
+		AccountNonces:        make(map[string]uint64),
+	}
+	rc.Chain.Weigh = rc.blockWeight
+	return rc, nil
 }
 
 // SubmitPendingRideTx adds a active RideTx to the pendingRideTx queue
 // once the rideTx is complete this RideTx will move to AwaitingApproval
+// the returned RideTx carries the generated PickupCode for the rider, the queued one only its commitment
 func (rc *RideChain) SubmitPendingRideTx(tx RideTx) (RideTx, error) {
-	if err := ValidateRideTx(tx); err != nil {
+	if rc.PaymentEventsFromWebhook {
+		// only callbacks this node received vouch for the payment
+		tx.RideTxEvts = withoutPaymentEvents(tx.RideTxEvts)
+	}
+	rc.attachPaymentEvents(&tx)
+	if err := ValidateRideTx(tx, rc.Fares); err != nil {
+		return RideTx{}, err
+	}
+	if tx.ComputedRoute.DestinationLocation.IsZero() && rc.GeofencePolicy.Action != GeofenceFlag {
+		return RideTx{}, fmt.Errorf("%w: missing destination coordinates to check the dropoff against", ErrOutsideGeofence)
+	}
+	if err := rc.checkDriverCanDrive(&tx); err != nil {
+		return RideTx{}, err
+	}
+	if err := rc.checkPayment(tx); err != nil {
 		return RideTx{}, err
 	}
 
+	code, err := rc.issuePickupCode(&tx)
+	if err != nil {
+		return RideTx{}, err
+	}
+	tx.PickupCode = ""
 	rc.PendingRideTxs[tx.DriverUUID] = tx
 
 	fmt.Printf("Ride submitted: %v\n", tx)
+	tx.PickupCode = code
 	return tx, nil
 }
 
 // ValidateRideTx
-// also adds the RiderPaymentReceived
-func ValidateRideTx(tx RideTx) error {
+// RiderPaymentRecieved is added from the payment webhook's callbacks, or by the caller when the
+// node has no webhook, see PaymentEventsFromWebhook. A paid event's amount must be PaidAmount and
+// PaidAmount must match the fares schedule's price for the ComputedRoute. Rides whose payment was
+// already refunded or disputed are refused
+func ValidateRideTx(tx RideTx, fares FareSchedule) error {
 	// 1. Required field checks
 	// todo tx.TxID is not given until sumission to mempool,
 	// should we already have this by now?
@@ -84,20 +245,24 @@ func ValidateRideTx(tx RideTx) error {
 	if tx.StripeSessionId == "" {
 		return errors.New("missing Stripe session ID")
 	}
-
-	if tx.PickupCode == "" {
-		return errors.New("missing pickup code")
+	if err := fares.checkFare(tx); err != nil {
+		return err
 	}
 
 	// 2. Location validity
-	if tx.PickupLocation.Lat == "" || tx.PickupLocation.Lng == "" {
+	if tx.PickupLocation.IsZero() {
 		return errors.New("invalid pickup coordinates")
 	}
+	if err := tx.PickupLocation.Validate(); err != nil {
+		return fmt.Errorf("invalid pickup coordinates: %w", err)
+	}
 
-	// todo check the routes for drop off lat lng for confirmed dropoff
 	if tx.ComputedRoute.Destination == "" {
 		return errors.New("invalid dropoff destination")
 	}
+	if err := tx.ComputedRoute.DestinationLocation.Validate(); err != nil {
+		return fmt.Errorf("invalid destination coordinates: %w", err)
+	}
 
 	// 3. Event history lifecycle (simple sanity check)
 	if len(tx.RideTxEvts) == 0 {
@@ -116,6 +281,13 @@ func ValidateRideTx(tx RideTx) error {
 			hasDriverAcceptedEvt = true
 		case RiderPaymentRecieved:
 			hasRiderPaymentRecievedEvt = true
+			if amount, ok := eventAmount(evt); ok && amount != tx.PaidAmount {
+				return fmt.Errorf("%w: session %s paid %d, ride says %d", ErrPaymentNotVerified, tx.StripeSessionId, amount, tx.PaidAmount)
+			}
+		case RiderPaymentRefunded:
+			return fmt.Errorf("%w: session %s was refunded", ErrPaymentNotVerified, tx.StripeSessionId)
+		case RiderPaymentDisputed:
+			return fmt.Errorf("%w: session %s is disputed", ErrPaymentNotVerified, tx.StripeSessionId)
 		}
 	}
 
@@ -133,23 +305,23 @@ func ValidateRideTx(tx RideTx) error {
 
 // TODO guard with mutex
 func (rc *RideChain) BecomeValidator(driverUUID string) error {
-	stake := rc.TokenLedger.GetStake(driverUUID)
-
 	// Genesis validator rule: allow bootstrapper with any stake
 	// TODO should the genesis validator at some point need to
 	// also stake the minValidatorStake?
 	if len(rc.Validators) == 0 {
 		rc.Validators[driverUUID] = true
+		rc.recordValidatorUpdate(driverUUID, false)
 		// todo update RideTxEvts
 		// rc.logValidatorEvent(driverUUID, "let there be light! genesis validator created")
 		return nil
 	}
 
-	if stake < rc.minValidatorStake {
-		return fmt.Errorf("driver %s must stake at least %d tokens to become a validator", driverUUID, rc.minValidatorStake)
+	if _, err := rc.CheckEligibility(driverUUID); err != nil {
+		return err
 	}
 
 	rc.Validators[driverUUID] = true
+	rc.recordValidatorUpdate(driverUUID, false)
 	// todo update RideTxEvts
 	// rc.logValidatorEvent(driverUUID, "became a validator")
 
@@ -191,6 +363,7 @@ func (rc *RideChain) SlashValidator(driverUUID string, slasher string, reason st
 
 	// Remove validator status
 	delete(rc.Validators, driverUUID)
+	rc.recordValidatorUpdate(driverUUID, true)
 
 	// todo update RideTxEvts
 
@@ -211,31 +384,16 @@ func (rc *RideChain) SlashValidator(driverUUID string, slasher string, reason st
 	return os.WriteFile(rc.TokenLedger.filename, data, 0644)
 }
 
-// VerifyDriver for now is a simple validation action
-// we will want to get insurance and background check info from ride module
-// here is an example of the links in TN
-// - fetch from https://sor.tbi.tn.gov/api/search : results from here will probably need to be manually (by human) parsed
-// - fetch https://verifyinsurance.revenue.tn.gov/assets/api/api.php : results from here can be automatically parsed
+// VerifyDriver records the validator's approval of a pending verification after running the
+// configured VerificationProviders, results are the validator's notes
+// a failed provider check counts as a rejection, see attestDriver
 func (rc *RideChain) VerifyDriver(driverUUID string, validator string, results string) error {
-	if !rc.IsValidator(validator) {
-		return fmt.Errorf("%s is not a validator", validator)
-	}
-
-	// check pending verificaiton request
-	request, exists := rc.PendingVerifications[driverUUID]
-	if !exists {
-		return fmt.Errorf("no pending verification for driver %s", driverUUID)
-	}
-
-	request.Status = "approved"
-	rc.PendingVerifications[driverUUID] = request
-
-	fmt.Printf("Driver %s verified by validator %s with results %s\n", driverUUID, validator, results)
-
-	// todo update RideTxEvts
+	return rc.attestDriver(driverUUID, validator, true, results)
+}
 
-	// rc.logValidatorEvent(validator, fmt.Sprintf("Driver %s verified by validator %s with results %s\n", driverUUID, validator, results))
-	return nil
+// RejectDriver records the validator's rejection of a pending verification
+func (rc *RideChain) RejectDriver(driverUUID string, validator string, reason string) error {
+	return rc.attestDriver(driverUUID, validator, false, reason)
 }
 
 func (rc *RideChain) GetDriverStake(driverUUID string) int {
@@ -275,9 +433,25 @@ func (rc *RideChain) ApproveRideTx(tx RideTx, validatorUUID string) (string, err
 	if rc.RideApprovals[tx.DriverUUID][validatorUUID] {
 		return "", fmt.Errorf("validator %v already approved ride %v", validatorUUID, tx)
 	}
+	if d := rc.openDisputeFor(tx); d != nil {
+		return "", fmt.Errorf("ride %v is under dispute %s", tx, d.ID)
+	}
+	// a cancellation or expiry settles the ride whatever was flagged
+	if tx.NeedsReview() && !tx.Cancelled() && !tx.Expired() {
+		return "", fmt.Errorf("ride %v is flagged for review, a validator must clear it first", tx)
+	}
+	seats := tx.Vehicle.Seats
+	if record, ok := rc.Vehicles[tx.Vehicle.ID]; ok {
+		seats = record.Seats
+	}
+	if err := checkSeats(tx, seats); err != nil {
+		return "", err
+	}
 
 	// Register approval
-	rc.RideApprovals[tx.DriverUUID] = make(map[string]bool)
+	if rc.RideApprovals[tx.DriverUUID] == nil {
+		rc.RideApprovals[tx.DriverUUID] = make(map[string]bool)
+	}
 	rc.RideApprovals[tx.DriverUUID][validatorUUID] = true
 
 	tx.RideTxEvts = append(tx.RideTxEvts, RideTxEvt{
@@ -286,56 +460,141 @@ func (rc *RideChain) ApproveRideTx(tx RideTx, validatorUUID string) (string, err
 	})
 
 	// Count approvals
-	if len(rc.RideApprovals[tx.DriverUUID]) >= rc.ApprovalQuorum {
-		tx.TxID = generateRideHash(tx)
-
-		// Move to ledger
-		RideLedger[tx.TxID] = tx
-		delete(rc.PendingRideTxs, tx.DriverUUID)
-		delete(rc.RideApprovals, tx.DriverUUID)
-		fmt.Printf("Ride %v approved and committed\n", tx)
+	if len(rc.RideApprovals[tx.DriverUUID]) < rc.ApprovalQuorum {
+		rc.PendingRideTxs[tx.DriverUUID] = tx
+		return "", nil
+	}
 
-		// rc.logValidatorEvent(validatorUUID, fmt.Sprintf("approved txID and commited %s", txID))
+	tx.TxID = generateRideHash(tx)
 
+	// Move to ledger through a new block on the canonical head
+	// applying the block debits a cancelled ride's driver penalty
+	if err := rc.commitRideTxs([]RideTx{tx}, rc.RideApprovals[tx.DriverUUID]); err != nil {
+		return "", err
+	}
+	if tx.Cancelled() && tx.Cancellation.Instruction.DriverPenalty > 0 {
+		if err := rc.TokenLedger.SaveToFile(); err != nil {
+			return "", err
+		}
 	}
+	fmt.Printf("Ride %v approved and committed\n", tx)
+
+	// rc.logValidatorEvent(validatorUUID, fmt.Sprintf("approved txID and commited %s", txID))
 
 	fmt.Printf("RideTx approved: %v\n", tx.TxID)
 
 	return tx.TxID, nil
 }
 
-func (rc *RideChain) RequestDriverVerification(driverUUID, requestedBy string) error {
-	if _, ok := rc.PendingVerifications[driverUUID]; ok {
-		return fmt.Errorf("verification for driver %s already requested", driverUUID)
+// commitRideTxs builds a block from txs approved by approvers and extends the canonical head
+func (rc *RideChain) commitRideTxs(txs []RideTx, approvers map[string]bool) error {
+	var validators []Validator
+	for uuid := range approvers {
+		validators = append(validators, Validator{UUID: uuid, Stake: rc.TokenLedger.GetStake(uuid)})
+	}
+	sort.Slice(validators, func(i, j int) bool { return validators[i].UUID < validators[j].UUID })
+
+	block, err := NewRideBlock(rc.Chain.HeadBlock(), txs, validators)
+	if err != nil {
+		return err
+	}
+	for _, update := range rc.pendingValidatorUpdates {
+		if !update.Removed {
+			update.PubKey = rc.AccountKeys[update.UUID]
+		}
+		block.ValidatorUpdates = append(block.ValidatorUpdates, update)
+	}
+	block.Records = rc.pendingRecords
+	block.seal()
+	if rc.signerKey != nil {
+		block.Sign(rc.signerUUID, rc.signerKey)
 	}
-	rc.PendingVerifications[driverUUID] = DriverVerificationRequest{
-		DriverUUID:  driverUUID,
-		RequestedBy: requestedBy,
-		Timestamp:   time.Now(),
-		Status:      "pending",
+	if err := rc.addBlock(block); err != nil {
+		return err
 	}
+	rc.pendingValidatorUpdates = nil
+	rc.pendingRecords = nil
 	return nil
 }
 
-func (rc *RideChain) SubmitPickupProof(tx RideTx, pickupCode string) error {
+// recordValidatorUpdate queues a validator set change for the next block
+func (rc *RideChain) recordValidatorUpdate(validatorUUID string, removed bool) {
+	rc.pendingValidatorUpdates = append(rc.pendingValidatorUpdates, ValidatorUpdate{
+		UUID:    validatorUUID,
+		Removed: removed,
+	})
+}
+
+// VerificationRequestSigningBytes is what the driver signs to open a verification round
+func VerificationRequestSigningBytes(driverUUID, requestedBy string, requestedAt time.Time) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("verify|%s|%s|%d", driverUUID, requestedBy, requestedAt.UnixNano())))
+	return digest[:]
+}
+
+// RequestDriverVerification opens a verification round, drivers re-verify the same way once
+// their approval is expiring or expired, their last outcome stands until the new round is decided
+// the driver signs VerificationRequestSigningBytes, requestedAt must be after their last round
+// opened so a signature cannot open another one
+func (rc *RideChain) RequestDriverVerification(driverUUID, requestedBy string, requestedAt time.Time, signature []byte) error {
+	request, ok := rc.PendingVerifications[driverUUID]
+	if ok && request.Status == VerificationPending {
+		return fmt.Errorf("verification for driver %s already requested", driverUUID)
+	}
+	if err := rc.verifyAccountSignature(driverUUID, VerificationRequestSigningBytes(driverUUID, requestedBy, requestedAt), signature); err != nil {
+		return err
+	}
+	if ok && !requestedAt.After(request.Timestamp) {
+		return fmt.Errorf("verification request for driver %s is not newer than the round opened at %s", driverUUID, request.Timestamp.Format(time.RFC3339))
+	}
+	request.DriverUUID = driverUUID
+	request.RequestedBy = requestedBy
+	request.Timestamp = requestedAt
+	request.Status = VerificationPending
+	request.Attestations = make(map[string]Attestation)
+	request.Signature = signature
+	return rc.commitVerification(request)
+}
+
+// SubmitPickupProof confirms pickup with the rider's code and the driver's position
+// the driver must be within GeofencePolicy.PickupRadius of the PickupLocation
+func (rc *RideChain) SubmitPickupProof(tx RideTx, pickupCode string, driverLocation LatLng) error {
 	tx, exists := rc.PendingRideTxs[tx.DriverUUID]
 	if !exists {
 		return fmt.Errorf("rideTx %v not found", tx)
 	}
 
+	if tx.Cancelled() || tx.Expired() {
+		return fmt.Errorf("rideTx %v was cancelled or expired", tx)
+	}
+
 	if tx.PickupConfirmed {
 		return fmt.Errorf("pickup already confirmed for rideTx %v", tx)
 	}
 
-	if tx.PickupCode != pickupCode {
-		return fmt.Errorf("invalid pickup code for rideTx %v", tx)
+	if err := rc.checkPickupCode(tx, pickupCode); err != nil {
+		return err
 	}
 
-	// Confirm pickup
-	tx.PickupConfirmed = true
-	rc.PendingRideTxs[tx.RiderUUID] = tx // save updated tx
+	if err := driverLocation.Validate(); err != nil {
+		return fmt.Errorf("invalid driver coordinates: %w", err)
+	}
+	if err := rc.GeofencePolicy.checkGeofence(&tx, "pickup", tx.PickupLocation, driverLocation, rc.GeofencePolicy.PickupRadius); err != nil {
+		return err
+	}
 
-	// todo update RideTxEvts
+	// Confirm pickup, the code is spent
+	delete(rc.pickupSecrets, tx.DriverUUID)
+	tx.PickupConfirmed = true
+	tx.DriverLocation = driverLocation
+	tx.RideTxEvts = append(tx.RideTxEvts, RideTxEvt{
+		EventType: PickupVerified,
+		Timestamp: time.Now(),
+		Metadata: map[string]interface{}{
+			"lat": driverLocation.Lat.String(),
+			"lng": driverLocation.Lng.String(),
+		},
+	})
+	rc.PendingRideTxs[tx.DriverUUID] = tx // save updated tx
 
 	// rc.logValidatorEvent(tx.DriverUUID, fmt.Sprintf("pickup code confirmed for ride %s", txID))
 
@@ -343,12 +602,18 @@ func (rc *RideChain) SubmitPickupProof(tx RideTx, pickupCode string) error {
 	return nil
 }
 
+// SubmitDropoff confirms dropoff within GeofencePolicy.DropoffRadius of the route destination
+// and commits the driver's GPS trace to RouteHash
 func (rc *RideChain) SubmitDropoff(tx RideTx, dropoffLocation LatLng) error {
-	tx, exists := rc.PendingRideTxs[tx.RiderUUID]
+	tx, exists := rc.PendingRideTxs[tx.DriverUUID]
 	if !exists {
 		return fmt.Errorf("rideTx %v not found", tx)
 	}
 
+	if tx.Cancelled() || tx.Expired() {
+		return fmt.Errorf("ride %v was cancelled or expired", tx)
+	}
+
 	if !tx.PickupConfirmed {
 		return fmt.Errorf("pickup not confirmed for ride %v", tx)
 	}
@@ -357,13 +622,32 @@ func (rc *RideChain) SubmitDropoff(tx RideTx, dropoffLocation LatLng) error {
 		return fmt.Errorf("dropoff already submitted for ride %v", tx)
 	}
 
+	if dropoffLocation.IsZero() {
+		return fmt.Errorf("missing dropoff coordinates for ride %v", tx)
+	}
+	if err := dropoffLocation.Validate(); err != nil {
+		return fmt.Errorf("invalid dropoff coordinates: %w", err)
+	}
+	if err := rc.GeofencePolicy.checkGeofence(&tx, "destination", tx.ComputedRoute.DestinationLocation, dropoffLocation, rc.GeofencePolicy.DropoffRadius); err != nil {
+		return err
+	}
+
 	tx.DropoffLocation = dropoffLocation
+	if err := rc.commitTrace(&tx); err != nil {
+		return err
+	}
 	tx.DropoffConfirmed = true
 	tx.DropoffTime = time.Now()
+	tx.RideTxEvts = append(tx.RideTxEvts, RideTxEvt{
+		EventType: DropoffConfirmed,
+		Timestamp: tx.DropoffTime,
+		Metadata: map[string]interface{}{
+			"lat": dropoffLocation.Lat.String(),
+			"lng": dropoffLocation.Lng.String(),
+		},
+	})
 
-	rc.PendingRideTxs[tx.RiderUUID] = tx // update with drop-off
-
-	// todo update RideTxEvts
+	rc.PendingRideTxs[tx.DriverUUID] = tx // update with drop-off
 
 	// rc.logValidatorEvent(tx.DriverUUID, fmt.Sprintf("dropoff submitted for ride %s", txID))
 
@@ -3,13 +3,14 @@ package blockchain
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRideChain_BecomeValidator(t *testing.T) {
-	rc, _ := NewRideChain("test/token_ledger.json")
+	rc, _ := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
 	type args struct {
 		driverUUID string
 	}
@@ -22,17 +22,20 @@ type RideTx struct {
 
 	// todo we need to get this from the computedRoutes.destination if possible
 	// the destination needs to be converted to lat,lng
-	DropoffLocation  LatLng    `json:"dropoffLocation"`
-	Passengers       int       `json:"passengers"` // todo deprecate put inside vehicle
-	Luggage          int       `json:"luggage"`    // todo deprecate put inside vehicle
-	PaidAmount       int       `json:"paidAmount"`
-	PickupCode       string    `json:"pickupCode"`
-	RouteHash        string    `json:"routeHash"` // optional full route hash
-	PickupConfirmed  bool      `json:"pickUpConfirmed"`
-	DropoffConfirmed bool      `json:"dropOffConfirmed"`
-	DropoffTime      time.Time `json:"dropOffTime"`
-	DriverLocation   LatLng    `json:"driverLocation"` // This is needed for the google embedded map string
-	DriverAccepted   bool      `json:"driverAccepted"`
+	DropoffLocation LatLng `json:"dropoffLocation"`
+	Passengers      int    `json:"passengers"` // todo deprecate put inside vehicle
+	Luggage         int    `json:"luggage"`    // todo deprecate put inside vehicle
+	PaidAmount      int    `json:"paidAmount"`
+	// PickupCode is only set on the copy returned to the rider, it is never stored or hashed
+	PickupCode string `json:"-"`
+	// PickupCodeCommitment is the salted hash of the PickupCode, see RideChain.SubmitPickupProof
+	PickupCodeCommitment string    `json:"pickupCodeCommitment"`
+	RouteHash            string    `json:"routeHash"` // optional full route hash
+	PickupConfirmed      bool      `json:"pickUpConfirmed"`
+	DropoffConfirmed     bool      `json:"dropOffConfirmed"`
+	DropoffTime          time.Time `json:"dropOffTime"`
+	DriverLocation       LatLng    `json:"driverLocation"` // This is needed for the google embedded map string
+	DriverAccepted       bool      `json:"driverAccepted"`
 
 	// StripeSessionId represents successful payment from rider
 	// TODO see what opportunities we have with buttons to
@@ -47,6 +50,11 @@ type RideTx struct {
 
 	// ComputedRoute should have all the information on pickup/dropoff times/miles away
 	ComputedRoute ComputedRoute `json:"computedRoute"`
+
+	// Cancellation is set when the ride was cancelled instead of completed
+	Cancellation *RideCancellation `json:"cancellation,omitempty"`
+	// Expiry is set when the sweeper ended the ride, see RideChain.SweepExpired
+	Expiry *RideExpiry `json:"expiry,omitempty"`
 }
 
 // PlaceDetails present details for example type of place is grocery_store,
@@ -59,6 +67,8 @@ type PlaceDetails struct {
 }
 
 type Vehicle struct {
+	// ID is the vehicle's registry ID, see RideChain.RegisterVehicle
+	ID    string `json:"id,omitempty"`
 	Brand string `json:"brand"`
 	Year  string `json:"year"`
 	Model string `json:"model"`
@@ -68,9 +78,6 @@ type Vehicle struct {
 	Seats int    `json:"seats"`
 }
 
-// Simulated ledger (in-memory for now)
-var RideLedger = map[string]RideTx{}
-
 // Generate a SHA-256 hash of the ride data (for TxID or chain anchoring)
 func generateRideHash(tx RideTx) string {
 	data, _ := json.Marshal(tx)
@@ -90,8 +97,12 @@ type ComputedRoute struct {
 	Departure        string  `json:"departure"`
 	EstimatedArrival string  `json:"arrival"`
 	Destination      string  `json:"destination"`
-	MilesAway        int     `json:"milesAway"`
-	MinutesAway      int     `json:"minutesAway"`
-	TravelTime       int     `json:"travelTime"`
-	TravelMiles      int     `json:"travelMiles"`
+	// DestinationLocation is the geocoded Destination used to check the dropoff
+	DestinationLocation LatLng `json:"destinationLocation"`
+	MilesAway           int    `json:"milesAway"`
+	MinutesAway         int    `json:"minutesAway"`
+	TravelTime          int    `json:"travelTime"` // minutes
+	TravelMiles         int    `json:"travelMiles"`
+	// Surge is the demand multiplier in percent, 150 is 1.5x, 0 means no surge
+	Surge int `json:"surge"`
 }
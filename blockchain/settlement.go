@@ -0,0 +1,286 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrPeriodNotSettleable is returned for periods that are still open, overlap or leave a gap after the last batch
+var ErrPeriodNotSettleable = errors.New("period cannot be settled")
+
+// SettlementPolicy sets how often drivers are paid out and what the protocol keeps, amounts are cents
+type SettlementPolicy struct {
+	Period time.Duration
+	// ProtocolFeePercent is taken from fares and cancellation fees net of refunds, never from tips
+	ProtocolFeePercent int
+	// MinimumPayout carries smaller balances, and anything a driver owes, into their next settlement
+	MinimumPayout int
+	Currency      string
+}
+
+// DefaultSettlementPolicy pays drivers weekly keeping 2% for the protocol
+func DefaultSettlementPolicy() SettlementPolicy {
+	return SettlementPolicy{
+		Period:             7 * 24 * time.Hour,
+		ProtocolFeePercent: 2,
+		MinimumPayout:      1000,
+		Currency:           "usd",
+	}
+}
+
+// Settlement is one driver's fiat earnings for a period, amounts are cents
+// tips and adjustments riders covered in tokens stay on the TokenLedger and are reported only
+type Settlement struct {
+	ID          string    `json:"id"`
+	DriverUUID  string    `json:"driverUUID"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	TxIDs       []string  `json:"txIDs"` // rides committed during the period
+	Rides       int       `json:"rides"`
+	Fares       int       `json:"fares"`
+	// CancellationFees are what riders paid the driver for late cancellations and no-shows
+	CancellationFees int `json:"cancellationFees"`
+	Tips             int `json:"tips"`
+	Adjustments      int `json:"adjustments"` // tolls and wait time
+	// Receivables are the part of Adjustments riders' token balances did not cover, collected by payments
+	Receivables int `json:"receivables"`
+	Refunds     int `json:"refunds"`
+	ProtocolFee int `json:"protocolFee"`
+	// Net is fares, cancellation fees and receivables less refunds and the protocol fee
+	Net int `json:"net"`
+	// CarriedIn is what the driver's Earnings held before the period's refunds were debited
+	CarriedIn  int `json:"carriedIn"`
+	Payout     int `json:"payout"`
+	CarriedOut int `json:"carriedOut"`
+}
+
+// PayoutBatch is every driver's settlement for a period, see WriteCSV for the file handed to payments
+type PayoutBatch struct {
+	ID          string       `json:"id"`
+	PeriodStart time.Time    `json:"periodStart"`
+	PeriodEnd   time.Time    `json:"periodEnd"`
+	Currency    string       `json:"currency"`
+	CreatedBy   string       `json:"createdBy"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	Settlements []Settlement `json:"settlements"`
+	Total       int          `json:"total"`
+}
+
+// PayoutBatchID names the batch for a period starting at start
+func PayoutBatchID(start time.Time) string {
+	return "payout-" + start.UTC().Format("20060102T150405Z")
+}
+
+// SettleEarnings settles every driver's fiat earnings for the period starting at periodStart and
+// commits the payout batch in a block, periods follow each other without gaps once the first is
+// settled, what a driver is not paid stays in their Earnings for the next period
+func (rc *RideChain) SettleEarnings(periodStart time.Time, validatorUUID string) (*PayoutBatch, error) {
+	if !rc.IsValidator(validatorUUID) {
+		return nil, fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	periodStart = periodStart.UTC()
+	periodEnd := periodStart.Add(rc.SettlementPolicy.Period)
+	if periodEnd.After(now()) {
+		return nil, fmt.Errorf("%w: period ends %s", ErrPeriodNotSettleable, periodEnd.Format(time.RFC3339))
+	}
+	if last := rc.lastPayoutBatch(); last != nil && !periodStart.Equal(last.PeriodEnd) {
+		return nil, fmt.Errorf("%w: the next period starts %s", ErrPeriodNotSettleable, last.PeriodEnd.Format(time.RFC3339))
+	}
+
+	batch := &PayoutBatch{
+		ID:          PayoutBatchID(periodStart),
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Currency:    rc.SettlementPolicy.Currency,
+		CreatedBy:   validatorUUID,
+		CreatedAt:   now().UTC(),
+	}
+	earnings := rc.earnings(periodStart, periodEnd)
+	drivers := make([]string, 0, len(earnings))
+	for driver := range earnings {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+
+	for _, driver := range drivers {
+		s := earnings[driver]
+		s.ProtocolFee = max(s.Fares+s.CancellationFees-s.Refunds, 0) * rc.SettlementPolicy.ProtocolFeePercent / 100
+		s.Net = s.Fares + s.CancellationFees + s.Receivables - s.Refunds - s.ProtocolFee
+		owed := s.Net + s.CarriedIn
+		if owed >= rc.SettlementPolicy.MinimumPayout && owed > 0 {
+			s.Payout = owed
+		}
+		s.CarriedOut = owed - s.Payout
+		batch.Settlements = append(batch.Settlements, *s)
+		batch.Total += s.Payout
+	}
+	if err := rc.queueRecord(RecordSettlement, batch.ID, batch); err != nil {
+		return nil, err
+	}
+	// earnings only move once the batch is committed, so a failed commit can be settled again
+	if err := rc.commitRideTxs(nil, map[string]bool{validatorUUID: true}); err != nil {
+		rc.dropRecord(RecordSettlement, batch.ID)
+		return nil, err
+	}
+	rc.applyPayoutBatch(batch)
+	fmt.Printf("Payout batch %s settled %d drivers for %d %s\n", batch.ID, len(batch.Settlements), batch.Total, batch.Currency)
+	return batch, nil
+}
+
+// applyPayoutBatch records the batch and leaves each settled driver's Earnings at what they carry
+// out, less the refunds this node authorized after the period, which the next settlement counts
+func (rc *RideChain) applyPayoutBatch(batch *PayoutBatch) {
+	for _, s := range batch.Settlements {
+		rc.Earnings[s.DriverUUID] = s.CarriedOut - rc.refundedSince(s.DriverUUID, batch.PeriodEnd)
+	}
+	rc.Payouts[batch.ID] = batch
+}
+
+// earnings aggregates each driver's rides, tips, adjustments and refunds in [start, end)
+// with what they carried out of their last settlement
+func (rc *RideChain) earnings(start, end time.Time) map[string]*Settlement {
+	earnings := make(map[string]*Settlement)
+	settlement := func(driver string) *Settlement {
+		s, ok := earnings[driver]
+		if !ok {
+			s = &Settlement{DriverUUID: driver, PeriodStart: start, PeriodEnd: end}
+			s.ID = settlementID(driver, start, end)
+			earnings[driver] = s
+		}
+		return s
+	}
+	within := func(at time.Time) bool {
+		return !at.Before(start) && at.Before(end)
+	}
+
+	for _, b := range rc.Chain.CanonicalChain() {
+		if !within(b.Timestamp) {
+			continue
+		}
+		txs, err := b.RideTxs()
+		if err != nil {
+			continue
+		}
+		for _, tx := range txs {
+			switch {
+			case tx.Completed():
+				s := settlement(tx.DriverUUID)
+				s.TxIDs = append(s.TxIDs, tx.TxID)
+				s.Rides++
+				s.Fares += tx.PaidAmount
+			case tx.Cancelled() && tx.Cancellation.Instruction.PayToDriver > 0:
+				s := settlement(tx.DriverUUID)
+				s.TxIDs = append(s.TxIDs, tx.TxID)
+				s.CancellationFees += tx.Cancellation.Instruction.PayToDriver
+			case tx.Expired() && tx.Expiry.Instruction.PayToDriver > 0:
+				s := settlement(tx.DriverUUID)
+				s.TxIDs = append(s.TxIDs, tx.TxID)
+				s.Rides++
+				s.Fares += tx.Expiry.Instruction.PayToDriver
+			}
+		}
+	}
+	for _, adj := range rc.Adjustments {
+		if !adj.Settled || !within(adj.SettledAt) {
+			continue
+		}
+		tx, err := rc.RideTx(adj.TxID)
+		if err != nil {
+			continue
+		}
+		s := settlement(tx.DriverUUID)
+		if adj.Kind == AdjustmentTip {
+			s.Tips += adj.Amount
+		} else {
+			s.Adjustments += adj.Amount
+		}
+		s.Receivables += adj.Receivable
+	}
+	for _, r := range rc.Refunds {
+		if (r.Status == RefundAuthorized || r.Status == RefundIssued) && within(r.AuthorizedAt) {
+			settlement(r.DriverUUID).Refunds += r.Amount
+		}
+	}
+
+	// refunds authorized since the period started are already debited from Earnings
+	for driver, balance := range rc.Earnings {
+		if carried := balance + rc.refundedSince(driver, start); carried != 0 {
+			settlement(driver).CarriedIn = carried
+		}
+	}
+	return earnings
+}
+
+// refundedSince totals the driver's refunds authorized at or after start
+func (rc *RideChain) refundedSince(driver string, start time.Time) int {
+	total := 0
+	for _, r := range rc.Refunds {
+		if r.DriverUUID == driver && (r.Status == RefundAuthorized || r.Status == RefundIssued) && !r.AuthorizedAt.Before(start) {
+			total += r.Amount
+		}
+	}
+	return total
+}
+
+// lastPayoutBatch returns the batch for the most recent settled period
+func (rc *RideChain) lastPayoutBatch() *PayoutBatch {
+	var last *PayoutBatch
+	for _, batch := range rc.Payouts {
+		if last == nil || batch.PeriodEnd.After(last.PeriodEnd) {
+			last = batch
+		}
+	}
+	return last
+}
+
+// PayoutBatch returns a settled batch by ID
+func (rc *RideChain) PayoutBatch(batchID string) (*PayoutBatch, error) {
+	batch, ok := rc.Payouts[batchID]
+	if !ok {
+		return nil, fmt.Errorf("payout batch %s not found", batchID)
+	}
+	return batch, nil
+}
+
+func settlementID(driver string, start, end time.Time) string {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", driver, start.UnixNano(), end.UnixNano())))
+	return hex.EncodeToString(digest[:8])
+}
+
+// payoutColumns are the payout file's header, amounts are in the currency's minor unit
+var payoutColumns = []string{
+	"batch_id", "settlement_id", "driver_uuid", "period_start", "period_end", "rides",
+	"fares", "cancellation_fees", "tips", "adjustments", "refunds", "protocol_fee",
+	"carried_in", "amount", "currency",
+}
+
+// WriteCSV writes one row per driver being paid, drivers carrying their balance forward are left out
+func (b *PayoutBatch) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(payoutColumns); err != nil {
+		return err
+	}
+	for _, s := range b.Settlements {
+		if s.Payout <= 0 {
+			continue
+		}
+		row := []string{
+			b.ID, s.ID, s.DriverUUID, s.PeriodStart.Format(time.RFC3339), s.PeriodEnd.Format(time.RFC3339),
+			strconv.Itoa(s.Rides), strconv.Itoa(s.Fares), strconv.Itoa(s.CancellationFees),
+			strconv.Itoa(s.Tips), strconv.Itoa(s.Adjustments), strconv.Itoa(s.Refunds),
+			strconv.Itoa(s.ProtocolFee), strconv.Itoa(s.CarriedIn), strconv.Itoa(s.Payout), b.Currency,
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideChain_SettleEarnings(t *testing.T) {
+	defer func() { now = time.Now }()
+	driver, rider := "driver-settle", "rider-settle"
+	start := time.Now().Add(-time.Minute).UTC()
+	rc, txID, _ := newDisputeChain(t, driver, rider)
+	rc.SettlementPolicy.Period = time.Hour
+
+	toll, err := rc.ProposeAdjustment(txID, AdjustmentToll, 300, driver, "bridge toll")
+	assert.Nil(t, err)
+	assert.Nil(t, rc.ApproveAdjustment(toll.ID, "validator-1"))
+	refund, err := rc.ProposeRefund(txID, 100, rider, "took the long way")
+	assert.Nil(t, err)
+	assert.Nil(t, rc.ApproveRefund(refund.ID, "validator-1"))
+	assert.Equal(t, 1000, rc.TokenLedger.Balances[driver], "the rider holds no tokens, so the toll is receivable")
+	assert.Equal(t, -100, rc.Earnings[driver])
+	assert.Equal(t, 300, toll.Receivable)
+
+	_, err = rc.SettleEarnings(start, "validator-1")
+	assert.True(t, errors.Is(err, ErrPeriodNotSettleable), "the period has not ended, got %v", err)
+	now = func() time.Time { return start.Add(3 * time.Hour) }
+	_, err = rc.SettleEarnings(start, rider)
+	assert.NotNil(t, err, "only validators settle")
+
+	// a batch whose block is refused leaves the period unsettled
+	finalized := rc.Chain.Finalized
+	rc.Chain.Finalized = "not-a-block"
+	_, err = rc.SettleEarnings(start, "validator-1")
+	assert.NotNil(t, err)
+	assert.Empty(t, rc.Payouts)
+	assert.Equal(t, -100, rc.Earnings[driver])
+	assert.False(t, rc.recordQueued(RecordSettlement, PayoutBatchID(start)))
+	rc.Chain.Finalized = finalized
+
+	// 500 fare + 300 toll - 100 refund - 2% of 400, under the minimum payout so it carries forward
+	batch, err := rc.SettleEarnings(start, "validator-1")
+	assert.Nil(t, err)
+	assert.Equal(t, PayoutBatchID(start), batch.ID)
+	assert.Len(t, batch.Settlements, 1)
+	s := batch.Settlements[0]
+	assert.Equal(t, []string{txID}, s.TxIDs)
+	assert.Equal(t, 500, s.Fares)
+	assert.Equal(t, 300, s.Adjustments)
+	assert.Equal(t, 300, s.Receivables)
+	assert.Equal(t, 100, s.Refunds)
+	assert.Equal(t, 8, s.ProtocolFee)
+	assert.Equal(t, 692, s.Net)
+	assert.Equal(t, 0, s.Payout)
+	assert.Equal(t, 692, s.CarriedOut)
+	assert.Equal(t, 0, batch.Total)
+	assert.Equal(t, 1000, rc.TokenLedger.Balances[driver], "settling leaves the driver's tokens alone")
+	assert.Equal(t, 692, rc.Earnings[driver], "what was not paid out stays in the driver's earnings")
+	hash, ok := rc.RecordBlock(RecordSettlement, batch.ID)
+	assert.True(t, ok, "the payout batch is committed in a block")
+	assert.Equal(t, rc.Chain.Head, hash)
+
+	// a node that did not settle the period adopts the batch from the block
+	peer, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	head, _ := rc.Chain.Get(hash)
+	for _, r := range head.Records {
+		assert.Nil(t, peer.applyRecord(r, hash))
+	}
+	adopted, err := peer.PayoutBatch(batch.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, batch.Settlements, adopted.Settlements)
+	assert.Equal(t, 692, peer.Earnings[driver])
+
+	_, err = rc.SettleEarnings(start, "validator-1")
+	assert.True(t, errors.Is(err, ErrPeriodNotSettleable), "already settled, got %v", err)
+	_, err = rc.SettleEarnings(start.Add(2*time.Hour), "validator-1")
+	assert.True(t, errors.Is(err, ErrPeriodNotSettleable), "leaves a gap, got %v", err)
+
+	rc.SettlementPolicy.MinimumPayout = 500
+	next, err := rc.SettleEarnings(batch.PeriodEnd, "validator-1")
+	assert.Nil(t, err)
+	assert.Len(t, next.Settlements, 1)
+	assert.Equal(t, 692, next.Settlements[0].CarriedIn)
+	assert.Equal(t, 692, next.Settlements[0].Payout)
+	assert.Equal(t, 692, next.Total)
+	assert.Equal(t, 0, rc.Earnings[driver])
+
+	assert.Nil(t, rc.Save())
+	reopened, err := OpenRideChain(filepath.Dir(rc.TokenLedger.filename))
+	assert.Nil(t, err)
+	saved, err := reopened.PayoutBatch(next.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 692, saved.Total)
+}
+
+func TestPayoutBatch_WriteCSV(t *testing.T) {
+	start := time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)
+	batch := PayoutBatch{
+		ID:          PayoutBatchID(start),
+		PeriodStart: start,
+		PeriodEnd:   start.Add(7 * 24 * time.Hour),
+		Currency:    "usd",
+		Settlements: []Settlement{
+			{ID: "s-1", DriverUUID: "driver-1", PeriodStart: start, PeriodEnd: start.Add(7 * 24 * time.Hour), Rides: 3, Fares: 1500, Tips: 200, ProtocolFee: 30, Payout: 1670},
+			{ID: "s-2", DriverUUID: "driver-2", PeriodStart: start, PeriodEnd: start.Add(7 * 24 * time.Hour), Rides: 1, Fares: 500, ProtocolFee: 10, CarriedOut: 490},
+		},
+		Total: 1670,
+	}
+
+	var out bytes.Buffer
+	assert.Nil(t, batch.WriteCSV(&out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, []string{
+		"batch_id,settlement_id,driver_uuid,period_start,period_end,rides,fares,cancellation_fees,tips,adjustments,refunds,protocol_fee,carried_in,amount,currency",
+		"payout-20261005T000000Z,s-1,driver-1,2026-10-05T00:00:00Z,2026-10-12T00:00:00Z,3,1500,0,200,0,0,30,0,1670,usd",
+	}, lines, "drivers carrying their balance forward are not paid")
+}
@@ -0,0 +1,209 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	ledgerFileName = "token_ledger.json"
+	chainFileName  = "chain.json"
+)
+
+// chainState is the part of a RideChain persisted next to the token ledger
+type chainState struct {
+	Validators              map[string]bool                      `json:"validators"`
+	PendingRideTxs          map[string]RideTx                    `json:"pendingRideTxs"`
+	RideApprovals           map[string]map[string]bool           `json:"rideApprovals"`
+	PendingVerifications    map[string]DriverVerificationRequest `json:"pendingVerifications"`
+	AccountKeys             map[string]ed25519.PublicKey         `json:"accountKeys"`
+	AccountNonces           map[string]uint64                    `json:"accountNonces"`
+	PendingValidatorUpdates []ValidatorUpdate                    `json:"pendingValidatorUpdates"`
+	PendingRecords          []Record                             `json:"pendingRecords"`
+	BlockTokenChanges       map[string][]TokenChange             `json:"blockTokenChanges"`
+	OrphanedRideTxs         []RideTx                             `json:"orphanedRideTxs"`
+	Disputes                map[string]*Dispute                  `json:"disputes"`
+	Adjustments             map[string]*Adjustment               `json:"adjustments"`
+	Ratings                 map[string]map[string]Rating         `json:"ratings"`
+	Vehicles                map[string]*VehicleRecord            `json:"vehicles"`
+	PendingVehicles         map[string]*VehicleRecord            `json:"pendingVehicles"`
+	Payments                map[string][]PaymentEvent            `json:"payments"`
+	Refunds                 map[string]*Refund                   `json:"refunds"`
+	Earnings                map[string]int                       `json:"earnings"`
+	Payouts                 map[string]*PayoutBatch              `json:"payouts"`
+	PendingTraces           map[string][]TracePoint              `json:"pendingTraces"`
+	RouteTraces             map[string][]byte                    `json:"routeTraces"`
+	PickupSecrets           map[string]*pickupSecret             `json:"pickupSecrets"`
+	Eligibility             *EligibilityPolicy                   `json:"eligibility"`
+	Blocks                  []*Block                             `json:"blocks"`
+	Finalized               string                               `json:"finalized"`
+	Head                    string                               `json:"head"`
+}
+
+// OpenRideChain loads a RideChain from a data directory created by Save
+// a missing directory starts a fresh chain
+func OpenRideChain(dataDir string) (*RideChain, error) {
+	rc, err := NewRideChain(filepath.Join(dataDir, ledgerFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dataDir, chainFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return rc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state chainState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	// fork choice and block requirements during the replay read the validator, verification and key state
+	if state.Validators != nil {
+		rc.Validators = state.Validators
+	}
+	if state.PendingVerifications != nil {
+		rc.PendingVerifications = state.PendingVerifications
+	}
+	if state.AccountKeys != nil {
+		rc.AccountKeys = state.AccountKeys
+	}
+	if state.AccountNonces != nil {
+		rc.AccountNonces = state.AccountNonces
+	}
+	if state.Eligibility != nil {
+		rc.Eligibility = *state.Eligibility
+	}
+
+	// replaying the blocks rebuilds the RideLedger, the data directory is trusted like blocks this node produced
+	// the saved token ledger already holds the token changes of the blocks it applied
+	if state.BlockTokenChanges != nil {
+		rc.blockTokenChanges = state.BlockTokenChanges
+	}
+	sort.SliceStable(state.Blocks, func(i, j int) bool { return state.Blocks[i].Height < state.Blocks[j].Height })
+	for _, b := range state.Blocks {
+		if b.Hash == rc.Chain.Genesis {
+			continue
+		}
+		if err := rc.addBlock(b); err != nil {
+			return nil, err
+		}
+	}
+	if finalized, ok := rc.Chain.Get(state.Finalized); ok && finalized.Height > rc.Chain.Blocks[rc.Chain.Finalized].Height {
+		if err := rc.Chain.Finalize(finalized.Hash); err != nil {
+			return nil, err
+		}
+	}
+	if state.Head != "" && state.Head != rc.Chain.Head {
+		return nil, fmt.Errorf("replaying %s chose head %s, it was saved at %s", dataDir, rc.Chain.Head, state.Head)
+	}
+
+	if state.PendingRideTxs != nil {
+		rc.PendingRideTxs = state.PendingRideTxs
+	}
+	if state.RideApprovals != nil {
+		rc.RideApprovals = state.RideApprovals
+	}
+	if state.Disputes != nil {
+		rc.Disputes = state.Disputes
+	}
+	if state.Adjustments != nil {
+		rc.Adjustments = state.Adjustments
+	}
+	if state.Ratings != nil {
+		rc.Ratings = state.Ratings
+	}
+	if state.Vehicles != nil {
+		rc.Vehicles = state.Vehicles
+	}
+	if state.PendingVehicles != nil {
+		rc.PendingVehicles = state.PendingVehicles
+	}
+	if state.Payments != nil {
+		rc.Payments = state.Payments
+	}
+	if state.Refunds != nil {
+		rc.Refunds = state.Refunds
+	}
+	if state.Earnings != nil {
+		rc.Earnings = state.Earnings
+	}
+	if state.Payouts != nil {
+		rc.Payouts = state.Payouts
+	}
+	if state.PendingTraces != nil {
+		rc.PendingTraces = state.PendingTraces
+	}
+	if state.RouteTraces != nil {
+		rc.RouteTraces = state.RouteTraces
+	}
+	if state.PickupSecrets != nil {
+		rc.pickupSecrets = state.PickupSecrets
+	}
+	rc.pendingValidatorUpdates = state.PendingValidatorUpdates
+	rc.pendingRecords = state.PendingRecords
+	rc.OrphanedRideTxs = state.OrphanedRideTxs
+	return rc, nil
+}
+
+// Save writes the token ledger and chain state into the ledger's directory
+func (rc *RideChain) Save() error {
+	if err := rc.TokenLedger.SaveToFile(); err != nil {
+		return err
+	}
+
+	rc.Chain.mu.RLock()
+	state := chainState{
+		Validators:              rc.Validators,
+		PendingRideTxs:          rc.PendingRideTxs,
+		RideApprovals:           rc.RideApprovals,
+		PendingVerifications:    rc.PendingVerifications,
+		AccountKeys:             rc.AccountKeys,
+		AccountNonces:           rc.AccountNonces,
+		PendingValidatorUpdates: rc.pendingValidatorUpdates,
+		PendingRecords:          rc.pendingRecords,
+		BlockTokenChanges:       rc.blockTokenChanges,
+		OrphanedRideTxs:         rc.OrphanedRideTxs,
+		Disputes:                rc.Disputes,
+		Adjustments:             rc.Adjustments,
+		Ratings:                 rc.Ratings,
+		Vehicles:                rc.Vehicles,
+		PendingVehicles:         rc.PendingVehicles,
+		Payments:                rc.Payments,
+		Refunds:                 rc.Refunds,
+		Earnings:                rc.Earnings,
+		Payouts:                 rc.Payouts,
+		PendingTraces:           rc.PendingTraces,
+		RouteTraces:             rc.RouteTraces,
+		PickupSecrets:           rc.pickupSecrets,
+		Eligibility:             &rc.Eligibility,
+		Finalized:               rc.Chain.Finalized,
+		Head:                    rc.Chain.Head,
+	}
+	for _, b := range rc.Chain.Blocks {
+		state.Blocks = append(state.Blocks, b)
+	}
+	rc.Chain.mu.RUnlock()
+
+	sort.Slice(state.Blocks, func(i, j int) bool {
+		if state.Blocks[i].Height != state.Blocks[j].Height {
+			return state.Blocks[i].Height < state.Blocks[j].Height
+		}
+		return state.Blocks[i].Hash < state.Blocks[j].Hash
+	})
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(filepath.Dir(rc.TokenLedger.filename), chainFileName), data, 0644)
+}
@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenRideChain(t *testing.T) {
+	dir := t.TempDir()
+	driver := "genesis-123"
+
+	rc, err := OpenRideChain(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	pub, _, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey(driver, pub))
+	rc.TokenLedger.Mint(driver, 25)
+	assert.Nil(t, rc.StakeTokens(10, driver))
+	txID := completeTestRide(t, rc, testRideTx(driver, "rider-store"), driver)
+	assert.Nil(t, requestTestVerification(t, rc, map[string]ed25519.PrivateKey{}, "driver-456", driver))
+	assert.Nil(t, rc.Save())
+
+	reopened, err := OpenRideChain(dir)
+	assert.Nil(t, err)
+
+	assert.Equal(t, rc.Chain.Head, reopened.Chain.Head)
+	assert.True(t, reopened.IsValidator(driver))
+	assert.Equal(t, rc.Account(driver), reopened.Account(driver))
+	assert.True(t, reopened.AccountKeys[driver].Equal(pub))
+	assert.Contains(t, reopened.PendingVerifications, "driver-456")
+
+	_, err = reopened.RideTx(txID)
+	assert.Nil(t, err, "replaying blocks should rebuild the ride ledger")
+}
+
+func TestOpenRideChain_ForkChoice(t *testing.T) {
+	dir := t.TempDir()
+	driver, peer := "genesis-123", "peer-validator"
+	rc, err := OpenRideChain(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	rc.TokenLedger.Mint(peer, 10)
+	assert.Nil(t, rc.StakeTokens(10, peer))
+	assert.Nil(t, rc.BecomeValidator(peer))
+	pub, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey(peer, pub))
+
+	// the peer's block outweighs two local blocks though its chain is shorter
+	genesis := rc.Chain.HeadBlock()
+	completeTestRide(t, rc, testRideTx(driver, "rider-1"), driver)
+	completeTestRide(t, rc, testRideTx(driver, "rider-2"), driver)
+	heavier := peerBlock(t, genesis, peer, key, Validator{UUID: peer, Stake: 10})
+	assert.Nil(t, rc.ReceiveBlock(heavier))
+	assert.Equal(t, heavier.Hash, rc.Chain.Head)
+	assert.Nil(t, rc.Save())
+
+	reopened, err := OpenRideChain(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, heavier.Hash, reopened.Chain.Head, "replay weighs blocks by the restored validators")
+
+	chainFile := filepath.Join(dir, chainFileName)
+	data, err := os.ReadFile(chainFile)
+	assert.Nil(t, err)
+	tampered := strings.Replace(string(data), `"head": "`+heavier.Hash+`"`, `"head": "`+genesis.Hash+`"`, 1)
+	assert.Nil(t, os.WriteFile(chainFile, []byte(tampered), 0644))
+	_, err = OpenRideChain(dir)
+	assert.NotNil(t, err, "the replayed head must match the saved head")
+}
+
+func TestRideChain_UnstakeTokens(t *testing.T) {
+	rc, err := OpenRideChain(t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+
+	rc.TokenLedger.Mint("driver-123", 20)
+	assert.Nil(t, rc.StakeTokens(15, "driver-123"))
+	assert.Nil(t, rc.BecomeValidator("driver-123"))
+
+	assert.Nil(t, rc.UnstakeTokens(5, "driver-123"))
+	assert.True(t, rc.IsValidator("driver-123"))
+
+	assert.Nil(t, rc.UnstakeTokens(5, "driver-123"))
+	assert.False(t, rc.IsValidator("driver-123"), "validator below the minimum stake is removed")
+	assert.Equal(t, 15, rc.Account("driver-123").Balance)
+
+	assert.NotNil(t, rc.UnstakeTokens(10, "driver-123"))
+}
@@ -0,0 +1,338 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// peer scoring, a peer at or below SyncConfig.BanScore is no longer asked for data
+const (
+	goodResponseScore    = 1
+	failedRequestPenalty = -2
+	badDataPenalty       = -10
+)
+
+// ErrNoPeers is returned when every peer has been banned or none were given
+var ErrNoPeers = errors.New("no usable peers")
+
+// Peer is a remote node a syncing node downloads blocks from
+type Peer interface {
+	ID() string
+	// Headers returns up to max canonical headers starting at fromHeight
+	Headers(fromHeight, max int) ([]BlockHeader, error)
+	// Body returns the full block for hash
+	Body(hash string) (*Block, error)
+}
+
+// Headers returns up to max headers of the canonical chain starting at fromHeight
+func (rc *RideChain) Headers(fromHeight, max int) []BlockHeader {
+	var headers []BlockHeader
+	for _, b := range rc.Chain.CanonicalChain() {
+		if b.Height < fromHeight {
+			continue
+		}
+		if len(headers) == max {
+			break
+		}
+		headers = append(headers, b.Header())
+	}
+	return headers
+}
+
+// BlockByHash returns any known block, canonical or not
+func (rc *RideChain) BlockByHash(hash string) (*Block, error) {
+	b, ok := rc.Chain.Get(hash)
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", hash)
+	}
+	return b, nil
+}
+
+// LocalPeer serves blocks straight from an in-process RideChain
+type LocalPeer struct {
+	Name  string
+	Chain *RideChain
+}
+
+func (p *LocalPeer) ID() string { return p.Name }
+
+func (p *LocalPeer) Headers(fromHeight, max int) ([]BlockHeader, error) {
+	return p.Chain.Headers(fromHeight, max), nil
+}
+
+func (p *LocalPeer) Body(hash string) (*Block, error) {
+	return p.Chain.BlockByHash(hash)
+}
+
+type SyncConfig struct {
+	HeaderBatch int // headers requested per round trip
+	Workers     int // parallel body downloads
+	MaxRetries  int // attempts per body, each on a different peer when possible
+	BanScore    int // peers scoring at or below this are ignored
+}
+
+func DefaultSyncConfig() SyncConfig {
+	return SyncConfig{
+		HeaderBatch: 64,
+		Workers:     4,
+		MaxRetries:  3,
+		BanScore:    -20,
+	}
+}
+
+// Syncer catches a RideChain up with its peers
+// headers are downloaded and verified first, then bodies are fetched in parallel
+type Syncer struct {
+	rc     *RideChain
+	peers  []Peer
+	config SyncConfig
+
+	mu     sync.Mutex
+	scores map[string]int // peerID -> score
+}
+
+func NewSyncer(rc *RideChain, config SyncConfig, peers ...Peer) *Syncer {
+	return &Syncer{
+		rc:     rc,
+		peers:  peers,
+		config: config,
+		scores: make(map[string]int),
+	}
+}
+
+// Score returns the current score of a peer
+func (s *Syncer) Score(peerID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scores[peerID]
+}
+
+func (s *Syncer) adjustScore(peerID string, delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scores[peerID] += delta
+	if s.scores[peerID] <= s.config.BanScore {
+		fmt.Printf("Peer %s banned with score %d\n", peerID, s.scores[peerID])
+	}
+}
+
+// usablePeers returns peers that are not banned, best scoring first
+func (s *Syncer) usablePeers() []Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var peers []Peer
+	for _, p := range s.peers {
+		if s.scores[p.ID()] > s.config.BanScore {
+			peers = append(peers, p)
+		}
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		return s.scores[peers[i].ID()] > s.scores[peers[j].ID()]
+	})
+	return peers
+}
+
+// nextPeer spreads jobs across the usable peers that have not been tried yet
+// once every peer has been tried they all become eligible again
+func (s *Syncer) nextPeer(tried map[string]bool, job int) Peer {
+	peers := s.usablePeers()
+	var untried []Peer
+	for _, p := range peers {
+		if !tried[p.ID()] {
+			untried = append(untried, p)
+		}
+	}
+	if len(untried) == 0 {
+		untried = peers
+	}
+	if len(untried) == 0 {
+		return nil
+	}
+	return untried[job%len(untried)]
+}
+
+// Sync downloads and applies every block the peers have beyond the local chain
+// returns the number of new blocks applied
+func (s *Syncer) Sync() (int, error) {
+	headers, err := s.syncHeaders()
+	if err != nil {
+		return 0, err
+	}
+	if len(headers) == 0 {
+		return 0, nil
+	}
+
+	blocks, err := s.syncBodies(headers)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, b := range blocks {
+		if err := s.rc.receiveBlock(b, false); err != nil {
+			if errors.Is(err, ErrDuplicateBlock) {
+				continue
+			}
+			return applied, err
+		}
+		applied++
+	}
+	fmt.Printf("Synced %d blocks, head is now %s\n", applied, s.rc.Chain.Head)
+	return applied, nil
+}
+
+// syncHeaders asks every usable peer for headers past the finalized block and keeps the chain
+// the fork-choice rule prefers: heaviest by ledger stake, then longest, then lowest tip hash
+func (s *Syncer) syncHeaders() ([]BlockHeader, error) {
+	peers := s.usablePeers()
+	if len(peers) == 0 {
+		return nil, ErrNoPeers
+	}
+
+	var best *peerChain
+	for _, p := range peers {
+		chain, err := s.headersFrom(p)
+		if err != nil {
+			fmt.Printf("Header sync from peer %s failed: %v\n", p.ID(), err)
+			continue
+		}
+		if best == nil || chain.heavier(best) {
+			best = chain
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return best.headers, nil
+}
+
+// peerChain is a peer's canonical chain past the local finalized block
+type peerChain struct {
+	headers []BlockHeader // the ones this node does not have yet
+	weight  int
+	height  int
+	tip     string
+}
+
+func (c *peerChain) heavier(other *peerChain) bool {
+	if c.weight != other.weight {
+		return c.weight > other.weight
+	}
+	if c.height != other.height {
+		return c.height > other.height
+	}
+	return c.tip < other.tip
+}
+
+func (s *Syncer) headersFrom(p Peer) (*peerChain, error) {
+	finalized, _ := s.rc.Chain.Get(s.rc.Chain.Finalized)
+	from := finalized.Height + 1
+	prevHash := finalized.Hash
+
+	chain := &peerChain{height: finalized.Height, tip: finalized.Hash}
+	for {
+		batch, err := p.Headers(from, s.config.HeaderBatch)
+		if err != nil {
+			s.adjustScore(p.ID(), failedRequestPenalty)
+			return nil, err
+		}
+		for _, h := range batch {
+			if err := s.verifyHeader(h, from, prevHash); err != nil {
+				s.adjustScore(p.ID(), badDataPenalty)
+				return nil, err
+			}
+			from, prevHash = h.Height+1, h.Hash
+			chain.weight += s.rc.approverWeight(h.Validators)
+			chain.height, chain.tip = h.Height, h.Hash
+			// known blocks only need to be walked past for linkage
+			if _, known := s.rc.Chain.Get(h.Hash); !known {
+				chain.headers = append(chain.headers, h)
+			}
+		}
+		if len(batch) < s.config.HeaderBatch {
+			break
+		}
+	}
+	s.adjustScore(p.ID(), goodResponseScore)
+	return chain, nil
+}
+
+// verifyHeader checks hash linkage, height and the proposer signature
+func (s *Syncer) verifyHeader(h BlockHeader, height int, prevHash string) error {
+	if h.Height != height {
+		return fmt.Errorf("header %s has height %d, expected %d", h.Hash, h.Height, height)
+	}
+	if h.PrevBlockHash != prevHash {
+		return fmt.Errorf("header %s does not link to %s", h.Hash, prevHash)
+	}
+	return s.rc.verifyHeader(h)
+}
+
+// syncBodies downloads the block for every header in parallel
+func (s *Syncer) syncBodies(headers []BlockHeader) ([]*Block, error) {
+	blocks := make([]*Block, len(headers))
+	errs := make([]error, len(headers))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(s.config.Workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				blocks[i], errs[i] = s.fetchBody(headers[i], i)
+			}
+		}()
+	}
+	for i := range headers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// fetchBody retries the download on a different peer after each failure
+func (s *Syncer) fetchBody(h BlockHeader, job int) (*Block, error) {
+	var lastErr error = ErrNoPeers
+	tried := make(map[string]bool)
+	for attempt := 0; attempt < s.config.MaxRetries; attempt++ {
+		p := s.nextPeer(tried, job)
+		if p == nil {
+			break
+		}
+		tried[p.ID()] = true
+
+		b, err := p.Body(h.Hash)
+		if err != nil {
+			s.adjustScore(p.ID(), failedRequestPenalty)
+			lastErr = err
+			continue
+		}
+		if err := b.VerifyBody(h); err != nil {
+			s.adjustScore(p.ID(), badDataPenalty)
+			lastErr = fmt.Errorf("peer %s served bad data: %w", p.ID(), err)
+			continue
+		}
+		s.adjustScore(p.ID(), goodResponseScore)
+
+		// keep the verified header fields, the body is only trusted for its data
+		block := *b
+		block.Validators = h.Validators
+		block.TxRoot = h.TxRoot
+		block.Proposer = h.Proposer
+		block.Signature = h.Signature
+		block.ValidatorUpdates = h.ValidatorUpdates
+		return &block, nil
+	}
+	return nil, fmt.Errorf("download block %s: %w", h.Hash, lastErr)
+}
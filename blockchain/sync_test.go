@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tamperingPeer serves valid headers but corrupts every body
+type tamperingPeer struct {
+	LocalPeer
+}
+
+func (p *tamperingPeer) Body(hash string) (*Block, error) {
+	b, err := p.LocalPeer.Body(hash)
+	if err != nil {
+		return nil, err
+	}
+	bad := *b
+	bad.Data = []byte(`[]`)
+	return &bad, nil
+}
+
+// forgingPeer re-signs headers with a key nobody registered
+type forgingPeer struct {
+	LocalPeer
+}
+
+func (p *forgingPeer) Headers(fromHeight, max int) ([]BlockHeader, error) {
+	headers, _ := p.LocalPeer.Headers(fromHeight, max)
+	_, key, _ := GenerateKeyPair()
+	for i := range headers {
+		headers[i].Signature = ed25519.Sign(key, headers[i].SigningBytes())
+	}
+	return headers, nil
+}
+
+// unreachablePeer fails every request
+type unreachablePeer struct{ name string }
+
+func (p *unreachablePeer) ID() string { return p.name }
+func (p *unreachablePeer) Headers(int, int) ([]BlockHeader, error) {
+	return nil, errors.New("connection refused")
+}
+func (p *unreachablePeer) Body(string) (*Block, error) {
+	return nil, errors.New("connection refused")
+}
+
+func newSourceChain(t *testing.T, validator string, rides int) *RideChain {
+	t.Helper()
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(validator))
+	_, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SetSigner(validator, key))
+
+	for i := 0; i < rides; i++ {
+		completeTestRide(t, rc, testRideTx(validator, fmt.Sprintf("rider-sync-%d", i)), validator)
+	}
+	return rc
+}
+
+func TestSyncer_Sync(t *testing.T) {
+	source := newSourceChain(t, "genesis-123", 5)
+
+	tests := []struct {
+		name        string
+		peers       func() []Peer
+		wantApplied int
+		wantErr     bool
+		wantBad     []string
+	}{
+		{
+			name: "catches up from a single honest peer",
+			peers: func() []Peer {
+				return []Peer{&LocalPeer{Name: "honest", Chain: source}}
+			},
+			wantApplied: 5,
+		},
+		{
+			name: "bodies from a tampering peer are retried on an honest peer",
+			peers: func() []Peer {
+				return []Peer{
+					&tamperingPeer{LocalPeer{Name: "tampering", Chain: source}},
+					&LocalPeer{Name: "honest", Chain: source},
+					&unreachablePeer{name: "offline"},
+				}
+			},
+			wantApplied: 5,
+			wantBad:     []string{"tampering", "offline"},
+		},
+		{
+			name: "headers with forged proposer signatures are rejected",
+			peers: func() []Peer {
+				return []Peer{&forgingPeer{LocalPeer{Name: "forging", Chain: source}}}
+			},
+			wantApplied: 0,
+			wantBad:     []string{"forging"},
+		},
+		{
+			name: "only tampering peers fails the sync",
+			peers: func() []Peer {
+				return []Peer{&tamperingPeer{LocalPeer{Name: "tampering", Chain: source}}}
+			},
+			wantErr: true,
+			wantBad: []string{"tampering"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			assert.Nil(t, node.RegisterPublicKey(source.signerUUID, source.AccountKeys[source.signerUUID]))
+
+			syncer := NewSyncer(node, DefaultSyncConfig(), tt.peers()...)
+			applied, err := syncer.Sync()
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.wantApplied, applied)
+			}
+			if tt.wantApplied > 0 {
+				assert.Equal(t, source.Chain.Head, node.Chain.Head)
+			}
+			for _, peer := range tt.wantBad {
+				assert.Less(t, syncer.Score(peer), 0, "peer %s should be penalized", peer)
+			}
+		})
+	}
+}
+
+func TestSyncer_Sync_Resumes(t *testing.T) {
+	source := newSourceChain(t, "genesis-123", 3)
+	node, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, node.RegisterPublicKey(source.signerUUID, source.AccountKeys[source.signerUUID]))
+
+	config := DefaultSyncConfig()
+	config.HeaderBatch = 2
+	syncer := NewSyncer(node, config, &LocalPeer{Name: "honest", Chain: source})
+
+	applied, err := syncer.Sync()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, applied)
+
+	completeTestRide(t, source, testRideTx(source.signerUUID, "rider-sync-late"), source.signerUUID)
+
+	applied, err = syncer.Sync()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, source.Chain.Head, node.Chain.Head)
+}
+
+func TestSyncer_Sync_HeaviestPeer(t *testing.T) {
+	longer := newSourceChain(t, "genesis-123", 3)
+	heavier := newSourceChain(t, "staked-validator", 1)
+
+	node, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, node.BecomeValidator("genesis-123"))
+	node.TokenLedger.Mint("staked-validator", 10)
+	assert.Nil(t, node.StakeTokens(10, "staked-validator"))
+	assert.Nil(t, node.BecomeValidator("staked-validator"))
+	for _, source := range []*RideChain{longer, heavier} {
+		assert.Nil(t, node.RegisterPublicKey(source.signerUUID, source.AccountKeys[source.signerUUID]))
+	}
+
+	syncer := NewSyncer(node, DefaultSyncConfig(),
+		&LocalPeer{Name: "longer", Chain: longer},
+		&LocalPeer{Name: "heavier", Chain: heavier},
+	)
+	applied, err := syncer.Sync()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, applied, "three blocks approved with no stake weigh less than one approved with 10")
+	assert.Equal(t, heavier.Chain.Head, node.Chain.Head)
+}
@@ -13,14 +13,17 @@ const ledgerFilePath = "data/token_ledger.json"
 type TokenLedger struct {
 	Balances map[string]int `json:"balances"` // driverUUID -> token balance
 	Stakes   map[string]int `json:"stakes"`   // driverUUID -> staked tokens
-	mu       sync.RWMutex   `json:"-"`
-	filename string         `json:"-"`
+	// Delegations map of delegator -> validatorUUID -> delegated tokens
+	Delegations map[string]map[string]int `json:"delegations,omitempty"`
+	mu          sync.RWMutex              `json:"-"`
+	filename    string                    `json:"-"`
 }
 
 func NewTokenLedger() *TokenLedger {
 	return &TokenLedger{
-		Balances: make(map[string]int),
-		Stakes:   make(map[string]int),
+		Balances:    make(map[string]int),
+		Stakes:      make(map[string]int),
+		Delegations: make(map[string]map[string]int),
 	}
 }
 
@@ -50,8 +53,20 @@ func (m *TokenLedger) GetStake(driverUUID string) int {
 	return m.Stakes[driverUUID]
 }
 
+func (m *TokenLedger) GetBalance(uuid string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.Balances[uuid]
+}
+
 // Unstake tokens
 func (m *TokenLedger) Unstake(driverUUID string, amount int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if amount <= 0 {
+		return fmt.Errorf("invalid unstake amount %d", amount)
+	}
 	if m.Stakes[driverUUID] < amount {
 		return fmt.Errorf("not enough tokens staked")
 	}
@@ -60,6 +75,56 @@ func (m *TokenLedger) Unstake(driverUUID string, amount int) error {
 	return nil
 }
 
+// Delegate moves tokens from the delegator's balance behind a validator's stake
+func (m *TokenLedger) Delegate(delegator, validatorUUID string, amount int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if amount <= 0 {
+		return fmt.Errorf("invalid delegation amount %d", amount)
+	}
+	if m.Balances[delegator] < amount {
+		return fmt.Errorf("insufficient balance for driver %s", delegator)
+	}
+	if m.Delegations[delegator] == nil {
+		m.Delegations[delegator] = make(map[string]int)
+	}
+	m.Balances[delegator] -= amount
+	m.Delegations[delegator][validatorUUID] += amount
+	return nil
+}
+
+// Undelegate returns tokens delegated to a validator to the delegator's balance
+func (m *TokenLedger) Undelegate(delegator, validatorUUID string, amount int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if amount <= 0 {
+		return fmt.Errorf("invalid undelegation amount %d", amount)
+	}
+	if m.Delegations[delegator][validatorUUID] < amount {
+		return fmt.Errorf("%s has not delegated %d tokens to %s", delegator, amount, validatorUUID)
+	}
+	m.Delegations[delegator][validatorUUID] -= amount
+	if m.Delegations[delegator][validatorUUID] == 0 {
+		delete(m.Delegations[delegator], validatorUUID)
+	}
+	m.Balances[delegator] += amount
+	return nil
+}
+
+// DelegatedStake is the total delegated to a validator by other accounts
+func (m *TokenLedger) DelegatedStake(validatorUUID string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	total := 0
+	for _, delegations := range m.Delegations {
+		total += delegations[validatorUUID]
+	}
+	return total
+}
+
 func (t *TokenLedger) SaveToFile() error {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -79,9 +144,10 @@ func LoadTokenLedgerFromFile(filename string) (*TokenLedger, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return &TokenLedger{
-			Stakes:   make(map[string]int),
-			Balances: make(map[string]int),
-			filename: filename,
+			Stakes:      make(map[string]int),
+			Balances:    make(map[string]int),
+			Delegations: make(map[string]map[string]int),
+			filename:    filename,
 		}, nil
 	}
 
@@ -98,6 +164,9 @@ func LoadTokenLedgerFromFile(filename string) (*TokenLedger, error) {
 	if ledger.Balances == nil {
 		ledger.Balances = make(map[string]int)
 	}
+	if ledger.Delegations == nil {
+		ledger.Delegations = make(map[string]map[string]int)
+	}
 	ledger.filename = filename
 	return &ledger, nil
 }
@@ -52,3 +52,99 @@ func TestTokenLedger_Stake(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenLedger_Delegate(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int
+		wantErr   error
+		balance   int
+		delegated int
+	}{
+		{
+			name:    "delegating more than the balance",
+			amount:  20,
+			wantErr: fmt.Errorf("insufficient balance for driver rider-abc"),
+			balance: 11,
+		},
+		{
+			name:    "delegating nothing",
+			amount:  0,
+			wantErr: fmt.Errorf("invalid delegation amount 0"),
+			balance: 11,
+		},
+		{
+			name:      "delegates tokens to the validator",
+			amount:    10,
+			balance:   1,
+			delegated: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := NewTokenLedger()
+			ledger.Balances["rider-abc"] = 11
+
+			err := ledger.Delegate("rider-abc", "genesis-123", tt.amount)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr.Error(), err.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.balance, ledger.Balances["rider-abc"])
+			assert.Equal(t, tt.delegated, ledger.DelegatedStake("genesis-123"))
+		})
+	}
+}
+
+func TestTokenLedger_Undelegate(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int
+		wantErr   error
+		balance   int
+		delegated int
+	}{
+		{
+			name:      "undelegating more than was delegated",
+			amount:    11,
+			wantErr:   fmt.Errorf("rider-abc has not delegated 11 tokens to genesis-123"),
+			balance:   1,
+			delegated: 10,
+		},
+		{
+			name:      "undelegating nothing",
+			amount:    0,
+			wantErr:   fmt.Errorf("invalid undelegation amount 0"),
+			balance:   1,
+			delegated: 10,
+		},
+		{
+			name:      "returns part of the delegation",
+			amount:    4,
+			balance:   5,
+			delegated: 6,
+		},
+		{
+			name:    "returns the whole delegation",
+			amount:  10,
+			balance: 11,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := NewTokenLedger()
+			ledger.Balances["rider-abc"] = 11
+			assert.Nil(t, ledger.Delegate("rider-abc", "genesis-123", 10))
+
+			err := ledger.Undelegate("rider-abc", "genesis-123", tt.amount)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr.Error(), err.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.balance, ledger.Balances["rider-abc"])
+			assert.Equal(t, tt.delegated, ledger.DelegatedStake("genesis-123"))
+		})
+	}
+}
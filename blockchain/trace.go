@@ -0,0 +1,212 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidTrace is returned when a GPS trace does not prove the ride was driven
+var ErrInvalidTrace = errors.New("invalid gps trace")
+
+// traceVersion is the first byte of an encoded trace
+const traceVersion = 1
+
+const metersPerMile = 1609.344
+
+// TracePoint is one GPS breadcrumb reported by the driver's phone
+type TracePoint struct {
+	Location  LatLng    `json:"location"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// TracePolicy sets what a GPS trace has to show for the ride to count as driven
+type TracePolicy struct {
+	// MaxGap is the longest time allowed between two breadcrumbs
+	MaxGap time.Duration
+	// MaxSpeed is meters per second allowed between two breadcrumbs
+	MaxSpeed float64
+	// EndpointRadius is meters allowed between the trace ends and the pickup and dropoff
+	EndpointRadius float64
+	// MilesTolerance is the fraction the traced distance may differ from ComputedRoute.TravelMiles
+	MilesTolerance float64
+	// Action decides whether a bad or missing trace rejects the dropoff or flags it
+	Action GeofenceAction
+}
+
+func DefaultTracePolicy() TracePolicy {
+	return TracePolicy{
+		MaxGap:         2 * time.Minute,
+		MaxSpeed:       45, // about 100mph
+		EndpointRadius: 300,
+		MilesTolerance: 0.3,
+		Action:         GeofenceReject,
+	}
+}
+
+// SubmitTrace appends breadcrumbs to the driver's trace for the ride in progress
+func (rc *RideChain) SubmitTrace(driverUUID string, points []TracePoint) error {
+	tx, exists := rc.PendingRideTxs[driverUUID]
+	if !exists {
+		return fmt.Errorf("no pending ride for driver %s", driverUUID)
+	}
+	if !tx.PickupConfirmed || tx.DropoffConfirmed {
+		return fmt.Errorf("ride for driver %s is not in progress", driverUUID)
+	}
+
+	trace := rc.PendingTraces[driverUUID]
+	for _, p := range points {
+		if err := p.Location.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTrace, err)
+		}
+		// encoded traces keep whole seconds
+		p.Timestamp = p.Timestamp.Truncate(time.Second).UTC()
+		if len(trace) > 0 && !p.Timestamp.After(trace[len(trace)-1].Timestamp) {
+			return fmt.Errorf("%w: breadcrumb at %s is not after the previous one", ErrInvalidTrace, p.Timestamp)
+		}
+		trace = append(trace, p)
+	}
+	rc.PendingTraces[driverUUID] = trace
+	return nil
+}
+
+// commitTrace stores the driver's trace and sets RouteHash on tx, called at dropoff
+func (rc *RideChain) commitTrace(tx *RideTx) error {
+	trace := rc.PendingTraces[tx.DriverUUID]
+	if len(trace) == 0 {
+		return rc.TracePolicy.failed(tx, fmt.Errorf("%w: no breadcrumbs submitted", ErrInvalidTrace))
+	}
+
+	encoded := EncodeTrace(trace)
+	if err := rc.TracePolicy.Verify(*tx, trace); err != nil {
+		if err := rc.TracePolicy.failed(tx, err); err != nil {
+			return err
+		}
+	}
+
+	tx.RouteHash = RouteHash(encoded)
+	rc.RouteTraces[tx.RouteHash] = encoded
+	delete(rc.PendingTraces, tx.DriverUUID)
+	return nil
+}
+
+func (p TracePolicy) failed(tx *RideTx, err error) error {
+	if p.Action != GeofenceFlag {
+		return err
+	}
+	flagForReview(tx, "route", err.Error(), nil)
+	return nil
+}
+
+// RouteTrace returns the committed trace for a RideTx.RouteHash
+func (rc *RideChain) RouteTrace(routeHash string) ([]TracePoint, error) {
+	encoded, ok := rc.RouteTraces[routeHash]
+	if !ok {
+		return nil, fmt.Errorf("no trace stored for route hash %s", routeHash)
+	}
+	if RouteHash(encoded) != routeHash {
+		return nil, fmt.Errorf("stored trace does not match route hash %s", routeHash)
+	}
+	return DecodeTrace(encoded)
+}
+
+// RouteHash is the hex sha256 of an encoded trace
+func RouteHash(encoded []byte) string {
+	hash := sha256.Sum256(encoded)
+	return fmt.Sprintf("%x", hash[:])
+}
+
+// Verify checks the trace is continuous, drivable, runs from pickup to dropoff
+// and roughly covers ComputedRoute.TravelMiles
+func (p TracePolicy) Verify(tx RideTx, trace []TracePoint) error {
+	if len(trace) < 2 {
+		return fmt.Errorf("%w: need at least 2 breadcrumbs, got %d", ErrInvalidTrace, len(trace))
+	}
+
+	if d := trace[0].Location.DistanceMeters(tx.PickupLocation); d > p.EndpointRadius {
+		return fmt.Errorf("%w: starts %.0fm from the pickup", ErrInvalidTrace, d)
+	}
+	if d := trace[len(trace)-1].Location.DistanceMeters(tx.DropoffLocation); d > p.EndpointRadius {
+		return fmt.Errorf("%w: ends %.0fm from the dropoff", ErrInvalidTrace, d)
+	}
+
+	var meters float64
+	for i := 1; i < len(trace); i++ {
+		prev, next := trace[i-1], trace[i]
+		elapsed := next.Timestamp.Sub(prev.Timestamp)
+		if elapsed <= 0 {
+			return fmt.Errorf("%w: breadcrumb %d is not after the previous one", ErrInvalidTrace, i)
+		}
+		if elapsed > p.MaxGap {
+			return fmt.Errorf("%w: %s gap before breadcrumb %d", ErrInvalidTrace, elapsed, i)
+		}
+		step := prev.Location.DistanceMeters(next.Location)
+		if speed := step / elapsed.Seconds(); speed > p.MaxSpeed {
+			return fmt.Errorf("%w: %.0fm/s before breadcrumb %d", ErrInvalidTrace, speed, i)
+		}
+		meters += step
+	}
+
+	if expected := float64(tx.ComputedRoute.TravelMiles); expected > 0 {
+		miles := meters / metersPerMile
+		if diff := miles - expected; diff > expected*p.MilesTolerance || -diff > expected*p.MilesTolerance {
+			return fmt.Errorf("%w: traced %.1f miles, route is %d", ErrInvalidTrace, miles, tx.ComputedRoute.TravelMiles)
+		}
+	}
+	return nil
+}
+
+// EncodeTrace compresses a trace as varint deltas of microdegrees and seconds
+func EncodeTrace(trace []TracePoint) []byte {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64*(1+3*len(trace)))
+	buf = append(buf, traceVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(trace)))
+
+	var lat, lng, seconds int64
+	for _, p := range trace {
+		buf = binary.AppendVarint(buf, int64(p.Location.Lat)-lat)
+		buf = binary.AppendVarint(buf, int64(p.Location.Lng)-lng)
+		buf = binary.AppendVarint(buf, p.Timestamp.Unix()-seconds)
+		lat, lng, seconds = int64(p.Location.Lat), int64(p.Location.Lng), p.Timestamp.Unix()
+	}
+	return buf
+}
+
+// DecodeTrace reverses EncodeTrace
+func DecodeTrace(data []byte) ([]TracePoint, error) {
+	if len(data) == 0 || data[0] != traceVersion {
+		return nil, fmt.Errorf("%w: unknown encoding", ErrInvalidTrace)
+	}
+	data = data[1:]
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: truncated", ErrInvalidTrace)
+	}
+	data = data[n:]
+
+	var trace []TracePoint
+	var deltas [3]int64
+	var lat, lng, seconds int64
+	for i := uint64(0); i < count; i++ {
+		for j := range deltas {
+			v, n := binary.Varint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("%w: truncated", ErrInvalidTrace)
+			}
+			deltas[j] = v
+			data = data[n:]
+		}
+		lat, lng, seconds = lat+deltas[0], lng+deltas[1], seconds+deltas[2]
+		trace = append(trace, TracePoint{
+			Location:  LatLng{Lat: Coordinate(lat), Lng: Coordinate(lng)},
+			Timestamp: time.Unix(seconds, 0).UTC(),
+		})
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidTrace, len(data))
+	}
+	return trace, nil
+}
@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// straightTrace drives in a straight line from one location to another, one breadcrumb per step
+func straightTrace(from, to LatLng, start time.Time, points int, step time.Duration) []TracePoint {
+	var trace []TracePoint
+	for i := 0; i < points; i++ {
+		f := float64(i) / float64(points-1)
+		trace = append(trace, TracePoint{
+			Location: NewLatLng(
+				from.Lat.Degrees()+(to.Lat.Degrees()-from.Lat.Degrees())*f,
+				from.Lng.Degrees()+(to.Lng.Degrees()-from.Lng.Degrees())*f,
+			),
+			Timestamp: start.Add(time.Duration(i) * step),
+		})
+	}
+	return trace
+}
+
+func TestEncodeTrace(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	trace := straightTrace(NewLatLng(36.1627, -86.7816), NewLatLng(36.1584, -86.7760), start, 50, 5*time.Second)
+
+	encoded := EncodeTrace(trace)
+	decoded, err := DecodeTrace(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, trace, decoded)
+
+	asJSON, err := json.Marshal(trace)
+	assert.Nil(t, err)
+	assert.Less(t, len(encoded)*10, len(asJSON), "encoded trace should be far smaller than JSON")
+
+	_, err = DecodeTrace(encoded[:len(encoded)-1])
+	assert.True(t, errors.Is(err, ErrInvalidTrace))
+}
+
+func TestTracePolicy_Verify(t *testing.T) {
+	pickup := NewLatLng(36.1627, -86.7816)
+	dropoff := NewLatLng(36.1584, -86.7760)
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	policy := DefaultTracePolicy()
+
+	tx := RideTx{PickupLocation: pickup, DropoffLocation: dropoff}
+
+	tests := []struct {
+		name    string
+		tx      RideTx
+		trace   []TracePoint
+		wantErr bool
+	}{
+		{
+			name:  "continuous drive from pickup to dropoff",
+			tx:    tx,
+			trace: straightTrace(pickup, dropoff, start, 10, 30*time.Second),
+		},
+		{
+			name:    "a single breadcrumb proves nothing",
+			tx:      tx,
+			trace:   straightTrace(pickup, dropoff, start, 10, 30*time.Second)[:1],
+			wantErr: true,
+		},
+		{
+			name:    "trace starting across town",
+			tx:      tx,
+			trace:   straightTrace(NewLatLng(36.1263, -86.6774), dropoff, start, 100, 30*time.Second),
+			wantErr: true,
+		},
+		{
+			name:    "trace ending short of the dropoff",
+			tx:      tx,
+			trace:   straightTrace(pickup, NewLatLng(36.1610, -86.7790), start, 10, 30*time.Second),
+			wantErr: true,
+		},
+		{
+			name:    "phone went dark for ten minutes",
+			tx:      tx,
+			trace:   straightTrace(pickup, dropoff, start, 3, 10*time.Minute),
+			wantErr: true,
+		},
+		{
+			name:    "teleporting between breadcrumbs",
+			tx:      tx,
+			trace:   straightTrace(pickup, dropoff, start, 2, time.Second),
+			wantErr: true,
+		},
+		{
+			name: "traced distance far short of the route",
+			tx: RideTx{
+				PickupLocation:  pickup,
+				DropoffLocation: dropoff,
+				ComputedRoute:   ComputedRoute{TravelMiles: 5},
+			},
+			trace:   straightTrace(pickup, dropoff, start, 10, 30*time.Second),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Verify(tt.tx, tt.trace)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidTrace), "got %v", err)
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestRideChain_SubmitTrace(t *testing.T) {
+	driver := "driver-trace"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+
+	onboardTestDriver(t, rc, driver)
+	tx, err := rc.SubmitPendingRideTx(testRideTx(driver, "rider-trace"))
+	assert.Nil(t, err)
+	dropoff := tx.ComputedRoute.DestinationLocation
+	trace := straightTrace(tx.PickupLocation, dropoff, time.Now(), 10, 30*time.Second)
+
+	assert.NotNil(t, rc.SubmitTrace(driver, trace), "no trace before pickup")
+	assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+	assert.Nil(t, rc.SubmitTrace(driver, trace[:5]))
+	assert.NotNil(t, rc.SubmitTrace(driver, trace[:1]), "breadcrumbs must move forward in time")
+	assert.Nil(t, rc.SubmitTrace(driver, trace[5:]))
+	assert.Nil(t, rc.SubmitDropoff(tx, dropoff))
+
+	pending := rc.PendingRideTxs[driver]
+	assert.NotEmpty(t, pending.RouteHash)
+	assert.False(t, pending.FlaggedForReview())
+	assert.NotContains(t, rc.PendingTraces, driver)
+
+	committed, err := rc.RouteTrace(pending.RouteHash)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.TracePolicy.Verify(pending, committed))
+
+	// without breadcrumbs the dropoff is rejected, or only flagged under a lenient policy
+	strict, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	onboardTestDriver(t, strict, driver)
+	tx, err = strict.SubmitPendingRideTx(testRideTx(driver, "rider-trace"))
+	assert.Nil(t, err)
+	assert.Nil(t, strict.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+	err = strict.SubmitDropoff(tx, dropoff)
+	assert.True(t, errors.Is(err, ErrInvalidTrace))
+
+	strict.TracePolicy.Action = GeofenceFlag
+	assert.Nil(t, strict.SubmitDropoff(tx, dropoff))
+	assert.True(t, strict.PendingRideTxs[driver].FlaggedForReview())
+}
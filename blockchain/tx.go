@@ -10,11 +10,6 @@ type Transaction struct {
 	Price   int    `json:"price"`
 }
 
-type LatLng struct {
-	Lat string `json:"lat"`
-	Lng string `json:"lng"`
-}
-
 type RideTxEventType string
 
 const (
@@ -28,6 +23,21 @@ const (
 	InsuranceVerified    RideTxEventType = "InsuranceVerified"
 	DriverValidated      RideTxEventType = "DriverValidated"
 	RiderPaymentRecieved RideTxEventType = "RiderPaymentRecieved"
+
+	// RiderPaymentRefunded and RiderPaymentDisputed come from payment provider callbacks, see PaymentEvent
+	RiderPaymentRefunded RideTxEventType = "RiderPaymentRefunded"
+	RiderPaymentDisputed RideTxEventType = "RiderPaymentDisputed"
+
+	// RideCancelled represents the ride ending before dropoff, see RideTx.Cancellation
+	RideCancelled RideTxEventType = "RideCancelled"
+
+	// FlaggedForReview represents something validators should look at before approving, see GeofencePolicy
+	FlaggedForReview RideTxEventType = "FlaggedForReview"
+	// ReviewCleared represents a validator clearing the flags raised before it, see RideChain.ClearReview
+	ReviewCleared RideTxEventType = "ReviewCleared"
+
+	// Expired represents the sweeper ending a ride that stalled in its lifecycle, see ExpiryReason
+	Expired RideTxEventType = "Expired"
 )
 
 type RideTxEvt struct {
@@ -1,6 +1,13 @@
 package blockchain
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNotEligible = errors.New("not eligible to become a validator")
 
 // ValidatorProfile from Driver.TransactionsQueue, we can calculate proof of physical work
 // validator staking can happen after completing x rides
@@ -10,14 +17,103 @@ import "time"
 type ValidatorProfile struct {
 	UUID        string
 	RidesServed int
+	RidesTaken  int
 	AvgRating   float64
-	Verified    bool // manual/off-chain KYC
+	TrustScore  float64 // 0 to 1 from decayed ratings, see RideChain.Reputation
+	Verified    bool    // manual/off-chain KYC
 	LastKYC     time.Time
+	Stake       int
+}
+
+// EligibilityPolicy is the proof of physical work a driver needs before becoming a validator
+// zero values turn a rule off, the stake minimum always applies
+type EligibilityPolicy struct {
+	MinRidesServed int
+	MinRidesTaken  int
+	// MinRating is the decayed average stars, drivers without ratings fail it
+	MinRating     float64
+	MinTrustScore float64
+	// KYCMaxAge is how recent the driver's last approved verification must be
+	KYCMaxAge time.Duration
+}
+
+// DefaultEligibilityPolicy only asks for stake, deployments opt into the rest
+func DefaultEligibilityPolicy() EligibilityPolicy {
+	return EligibilityPolicy{}
+}
+
+// Ineligible lists every rule the profile fails, empty when eligible
+func (p EligibilityPolicy) Ineligible(profile ValidatorProfile, minStake int, at time.Time) []string {
+	var reasons []string
+	if profile.Stake < minStake {
+		reasons = append(reasons, fmt.Sprintf("staked %d of %d tokens", profile.Stake, minStake))
+	}
+	if profile.RidesServed < p.MinRidesServed {
+		reasons = append(reasons, fmt.Sprintf("served %d of %d rides", profile.RidesServed, p.MinRidesServed))
+	}
+	if profile.RidesTaken < p.MinRidesTaken {
+		reasons = append(reasons, fmt.Sprintf("took %d of %d rides as a passenger", profile.RidesTaken, p.MinRidesTaken))
+	}
+	if p.MinRating > 0 && profile.AvgRating < p.MinRating {
+		reasons = append(reasons, fmt.Sprintf("rated %.2f, needs %.2f", profile.AvgRating, p.MinRating))
+	}
+	if p.MinTrustScore > 0 && profile.TrustScore < p.MinTrustScore {
+		reasons = append(reasons, fmt.Sprintf("trust score %.2f, needs %.2f", profile.TrustScore, p.MinTrustScore))
+	}
+	if p.KYCMaxAge > 0 {
+		switch {
+		case !profile.Verified:
+			reasons = append(reasons, "no approved KYC")
+		case at.Sub(profile.LastKYC) > p.KYCMaxAge:
+			reasons = append(reasons, fmt.Sprintf("last KYC %s is older than %s", profile.LastKYC.Format(time.DateOnly), p.KYCMaxAge))
+		}
+	}
+	return reasons
 }
 
+// CheckEligibility returns the driver's profile and an ErrNotEligible error naming every failed rule
+func (rc *RideChain) CheckEligibility(driverUUID string) (ValidatorProfile, error) {
+	profile := rc.ValidatorProfile(driverUUID)
+	reasons := rc.Eligibility.Ineligible(profile, rc.minValidatorStake, now())
+	if len(reasons) > 0 {
+		return profile, fmt.Errorf("%w: driver %s %s", ErrNotEligible, driverUUID, strings.Join(reasons, "; "))
+	}
+	return profile, nil
+}
+
+// DriverVerificationRequest.Status values
+const (
+	VerificationPending  = "pending"
+	VerificationApproved = "approved"
+	VerificationRejected = "rejected"
+	// VerificationExpired drivers are suspended from submitting rides until they re-verify
+	VerificationExpired = "expired"
+)
+
 type DriverVerificationRequest struct {
 	DriverUUID  string
 	RequestedBy string
 	Timestamp   time.Time
 	Status      string
+	// Attestations map of validatorUUID -> verdict for the current round
+	Attestations map[string]Attestation
+	// VerifiedAt and ExpiresAt bound the last approval, they survive a re-verification round
+	VerifiedAt time.Time
+	ExpiresAt  time.Time
+	// Results merge the provider outcomes of the attestations that decided the last round, Events
+	// hold every attestation's outcomes, both are committed with the request as a RecordVerification
+	Results []VerificationResult
+	Events  []RideTxEvt
+	// Signature is the driver's on VerificationRequestSigningBytes for the current round
+	Signature []byte
+}
+
+// Active reports whether the driver holds an unexpired approval
+func (r DriverVerificationRequest) Active(at time.Time) bool {
+	return !r.VerifiedAt.IsZero() && (r.ExpiresAt.IsZero() || at.Before(r.ExpiresAt))
+}
+
+// Expired reports whether the driver's approval ran out and they must re-verify
+func (r DriverVerificationRequest) Expired(at time.Time) bool {
+	return !r.VerifiedAt.IsZero() && !r.ExpiresAt.IsZero() && !at.Before(r.ExpiresAt)
 }
@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEligibilityPolicy_Ineligible(t *testing.T) {
+	at := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	policy := EligibilityPolicy{
+		MinRidesServed: 20,
+		MinRidesTaken:  5,
+		MinRating:      4.5,
+		KYCMaxAge:      30 * 24 * time.Hour,
+	}
+	eligible := ValidatorProfile{
+		RidesServed: 20,
+		RidesTaken:  5,
+		AvgRating:   4.8,
+		Verified:    true,
+		LastKYC:     at.Add(-24 * time.Hour),
+		Stake:       10,
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(p *ValidatorProfile)
+		want    []string
+	}{
+		{
+			name:    "meets every rule",
+			prepare: func(p *ValidatorProfile) {},
+		},
+		{
+			name: "new driver misses everything",
+			prepare: func(p *ValidatorProfile) {
+				*p = ValidatorProfile{}
+			},
+			want: []string{
+				"staked 0 of 10 tokens",
+				"served 0 of 20 rides",
+				"took 0 of 5 rides as a passenger",
+				"rated 0.00, needs 4.50",
+				"no approved KYC",
+			},
+		},
+		{
+			name: "stale KYC",
+			prepare: func(p *ValidatorProfile) {
+				p.LastKYC = at.Add(-45 * 24 * time.Hour)
+			},
+			want: []string{"last KYC 2025-04-17 is older than 720h0m0s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := eligible
+			tt.prepare(&profile)
+			assert.Equal(t, tt.want, policy.Ineligible(profile, 10, at))
+		})
+	}
+}
+
+func TestRideChain_CheckEligibility(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	rc.Eligibility = EligibilityPolicy{MinRidesServed: 1, KYCMaxAge: 30 * 24 * time.Hour}
+
+	driver := "driver-eligible"
+	rc.TokenLedger.Mint(driver, 10)
+	assert.Nil(t, rc.StakeTokens(10, driver))
+
+	err = rc.BecomeValidator(driver)
+	assert.True(t, errors.Is(err, ErrNotEligible), "got %v", err)
+	assert.Contains(t, err.Error(), "served 0 of 1 rides; no approved KYC")
+
+	completeTestRide(t, rc, testRideTx(driver, "rider-eligible"), "genesis-123")
+	assert.Nil(t, requestTestVerification(t, rc, map[string]ed25519.PrivateKey{}, driver, "genesis-123"))
+	assert.Nil(t, rc.VerifyDriver(driver, "genesis-123", "clean"))
+
+	profile, err := rc.CheckEligibility(driver)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, profile.RidesServed)
+	assert.Equal(t, 1, rc.ValidatorProfile("rider-eligible").RidesTaken)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	assert.True(t, rc.IsValidator(driver))
+}
@@ -0,0 +1,312 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDriverNotVerified is returned for rides from drivers without an approved, unexpired verification
+	ErrDriverNotVerified = errors.New("driver not verified")
+	// ErrVehicleNotOnFile is returned for rides in a vehicle that is not registered to the driver
+	ErrVehicleNotOnFile = errors.New("vehicle not on file")
+	// ErrVehicleNotInsured is returned when the vehicle's insurance lapsed or was never confirmed
+	ErrVehicleNotInsured = errors.New("vehicle not insured")
+	// ErrTooManyPassengers is returned for rides with more Passengers than the vehicle has Seats
+	ErrTooManyPassengers = errors.New("more passengers than seats")
+)
+
+// VehicleRecord is a registered vehicle, rides reference it by ID and carry a copy of its details
+// Vehicle.ID is the registry ID
+type VehicleRecord struct {
+	Vehicle
+	VIN string `json:"vin"`
+	// Owners are the drivers allowed to submit rides in the vehicle
+	Owners []string `json:"owners"`
+	// ConfirmedBy is the validator that confirmed the registration, see RideChain.ConfirmVehicle
+	ConfirmedBy string    `json:"confirmedBy"`
+	ConfirmedAt time.Time `json:"confirmedAt"`
+	// InsurancePolicy and InsuredUntil are confirmed by a validator, see RideChain.InsureVehicle
+	InsurancePolicy string    `json:"insurancePolicy"`
+	InsuredUntil    time.Time `json:"insuredUntil"`
+	InsuredBy       string    `json:"insuredBy"`
+	RegisteredAt    time.Time `json:"registeredAt"`
+}
+
+// Insured reports whether the vehicle's confirmed insurance covers the given time
+func (v VehicleRecord) Insured(at time.Time) bool {
+	return at.Before(v.InsuredUntil)
+}
+
+// VehicleID derives the registry ID from the normalized VIN, so the same vehicle gets
+// the same ID however its VIN was typed
+func VehicleID(vin string) string {
+	digest := sha256.Sum256([]byte("vin:" + normalizeVIN(vin)))
+	return hex.EncodeToString(digest[:8])
+}
+
+// RegisterVehicle files the driver's claim to a vehicle, committed as a RecordVehicleClaim, the claim
+// is only binding once a validator confirms it with ConfirmVehicle, until then other drivers may
+// claim the VIN or plate
+func (rc *RideChain) RegisterVehicle(driverUUID, vin string, vehicle Vehicle) (*VehicleRecord, error) {
+	vin = normalizeVIN(vin)
+	if vin == "" {
+		return nil, fmt.Errorf("vehicle VIN is required")
+	}
+	vehicle.Plate = normalizePlate(vehicle.Plate)
+	if vehicle.Plate == "" {
+		return nil, fmt.Errorf("vehicle plate is required")
+	}
+	if vehicle.Seats <= 0 {
+		return nil, fmt.Errorf("vehicle %s must have at least one seat", vehicle.Plate)
+	}
+	id := VehicleID(vin)
+	if err := rc.checkVehicleUnclaimed(id, vin, vehicle.Plate); err != nil {
+		return nil, err
+	}
+	claim := vehicleClaimID(id, driverUUID)
+	if _, ok := rc.PendingVehicles[claim]; ok {
+		return nil, fmt.Errorf("driver %s already registered vehicle %s, waiting for a validator", driverUUID, id)
+	}
+
+	vehicle.ID = id
+	record := &VehicleRecord{
+		Vehicle:      vehicle,
+		VIN:          vin,
+		Owners:       []string{driverUUID},
+		RegisteredAt: now().UTC(),
+	}
+	if err := rc.commitVehicle(RecordVehicleClaim, claim, record); err != nil {
+		return nil, err
+	}
+	rc.PendingVehicles[claim] = record
+	fmt.Printf("Vehicle %s (%s) registered by driver %s, waiting for a validator\n", id, vehicle.Plate, driverUUID)
+	return record, nil
+}
+
+// ConfirmVehicle makes a driver's registration binding on a validator's word, committed as a
+// RecordVehicle, with a registration provider configured the driver's registration record must
+// name the vehicle's VIN. Competing claims to the same VIN or plate are dropped
+func (rc *RideChain) ConfirmVehicle(vehicleID, driverUUID, validatorUUID string) error {
+	if !rc.IsValidator(validatorUUID) {
+		return fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	claim := vehicleClaimID(vehicleID, driverUUID)
+	record, ok := rc.PendingVehicles[claim]
+	if !ok {
+		return fmt.Errorf("driver %s has no registration of vehicle %s waiting", driverUUID, vehicleID)
+	}
+	if validatorUUID == driverUUID {
+		return fmt.Errorf("validator %s cannot confirm their own vehicle", validatorUUID)
+	}
+	if err := rc.checkVehicleUnclaimed(vehicleID, record.VIN, record.Plate); err != nil {
+		return err
+	}
+	for _, provider := range rc.VerificationProviders {
+		if provider.Check() != CheckRegistration {
+			continue
+		}
+		result, err := provider.Verify(context.Background(), driverUUID)
+		if err != nil {
+			return err
+		}
+		expired := !result.ExpiresAt.IsZero() && !result.ExpiresAt.After(now())
+		if !result.Passed || expired || normalizeVIN(result.Reference) != record.VIN {
+			return fmt.Errorf("%s has no registration of VIN %s to driver %s", provider.Name(), record.VIN, driverUUID)
+		}
+	}
+
+	confirmed := *record
+	confirmed.ConfirmedBy = validatorUUID
+	confirmed.ConfirmedAt = now().UTC()
+	if err := rc.commitVehicle(RecordVehicle, vehicleID, &confirmed); err != nil {
+		return err
+	}
+	*record = confirmed
+	rc.keepVehicle(record)
+	fmt.Printf("Vehicle %s (%s) registered to driver %s, confirmed by %s\n", vehicleID, record.Plate, driverUUID, validatorUUID)
+	return nil
+}
+
+// checkVehicleUnclaimed rejects a VIN or plate that already belongs to a confirmed vehicle
+func (rc *RideChain) checkVehicleUnclaimed(id, vin, plate string) error {
+	if _, ok := rc.Vehicles[id]; ok {
+		return fmt.Errorf("vehicle with VIN %s already registered as %s", vin, id)
+	}
+	if existing, ok := rc.VehicleByPlate(plate); ok {
+		return fmt.Errorf("plate %s already registered to vehicle %s", plate, existing.ID)
+	}
+	return nil
+}
+
+func vehicleClaimID(vehicleID, driverUUID string) string {
+	return vehicleID + ":" + driverUUID
+}
+
+// commitVehicle queues a registration or vehicle record and flushes it, nothing is queued
+// when the flush fails so the caller can leave its state unchanged
+func (rc *RideChain) commitVehicle(kind, id string, record *VehicleRecord) error {
+	if err := rc.queueRecord(kind, id, record); err != nil {
+		return err
+	}
+	if err := rc.flushRecords(); err != nil {
+		rc.dropRecord(kind, id)
+		return err
+	}
+	return nil
+}
+
+// keepVehicle files a confirmed vehicle and drops competing claims to its VIN or plate
+func (rc *RideChain) keepVehicle(record *VehicleRecord) {
+	rc.Vehicles[record.ID] = record
+	for id, pending := range rc.PendingVehicles {
+		if pending.ID == record.ID || pending.Plate == record.Plate {
+			delete(rc.PendingVehicles, id)
+		}
+	}
+}
+
+// adoptVehicleClaim takes a committed registration unless the vehicle is already confirmed
+func (rc *RideChain) adoptVehicleClaim(claim string, record *VehicleRecord) {
+	if _, ok := rc.Vehicles[record.ID]; ok {
+		return
+	}
+	if _, ok := rc.PendingVehicles[claim]; !ok {
+		rc.PendingVehicles[claim] = record
+	}
+}
+
+// adoptVehicle takes a committed vehicle unless this node already holds it with the same owners
+// and insurance, the local record is updated in place so lookups keep pointing at it
+func (rc *RideChain) adoptVehicle(record *VehicleRecord) {
+	local, ok := rc.Vehicles[record.ID]
+	if !ok {
+		rc.keepVehicle(record)
+		return
+	}
+	if len(record.Owners) > len(local.Owners) || record.InsuredUntil.After(local.InsuredUntil) {
+		*local = *record
+	}
+}
+
+// AddVehicleOwner lets an owner share the vehicle with another driver, the vehicle is committed again
+func (rc *RideChain) AddVehicleOwner(vehicleID, owner, driverUUID string) error {
+	record, ok := rc.Vehicles[vehicleID]
+	if !ok {
+		return fmt.Errorf("vehicle %s not registered", vehicleID)
+	}
+	if !slices.Contains(record.Owners, owner) {
+		return fmt.Errorf("%s does not own vehicle %s", owner, vehicleID)
+	}
+	if slices.Contains(record.Owners, driverUUID) {
+		return fmt.Errorf("%s already owns vehicle %s", driverUUID, vehicleID)
+	}
+	shared := *record
+	shared.Owners = append(slices.Clone(record.Owners), driverUUID)
+	if err := rc.commitVehicle(RecordVehicle, vehicleID, &shared); err != nil {
+		return err
+	}
+	*record = shared
+	return nil
+}
+
+// InsureVehicle records a validator confirming the vehicle's insurance policy until the given time,
+// the vehicle is committed again, validators cannot vouch for a vehicle they own
+func (rc *RideChain) InsureVehicle(vehicleID, validatorUUID, policy string, until time.Time) error {
+	if !rc.IsValidator(validatorUUID) {
+		return fmt.Errorf("%s is not a validator", validatorUUID)
+	}
+	record, ok := rc.Vehicles[vehicleID]
+	if !ok {
+		return fmt.Errorf("vehicle %s not registered", vehicleID)
+	}
+	if slices.Contains(record.Owners, validatorUUID) {
+		return fmt.Errorf("validator %s cannot confirm insurance on their own vehicle", validatorUUID)
+	}
+	if !until.After(now()) {
+		return fmt.Errorf("insurance policy %s already expired %s", policy, until.Format(time.DateOnly))
+	}
+	insured := *record
+	insured.InsurancePolicy = policy
+	insured.InsuredUntil = until
+	insured.InsuredBy = validatorUUID
+	if err := rc.commitVehicle(RecordVehicle, vehicleID, &insured); err != nil {
+		return err
+	}
+	*record = insured
+	fmt.Printf("Vehicle %s insured under %s until %s, confirmed by %s\n", vehicleID, policy, until.Format(time.DateOnly), validatorUUID)
+	return nil
+}
+
+// VehicleByPlate looks a registered vehicle up by its plate
+func (rc *RideChain) VehicleByPlate(plate string) (*VehicleRecord, bool) {
+	plate = normalizePlate(plate)
+	for _, record := range rc.Vehicles {
+		if record.Plate == plate {
+			return record, true
+		}
+	}
+	return nil, false
+}
+
+// LookupVehicle finds a registered vehicle by ID, VIN or plate
+func (rc *RideChain) LookupVehicle(ref string) (*VehicleRecord, error) {
+	if record, ok := rc.Vehicles[ref]; ok {
+		return record, nil
+	}
+	if record, ok := rc.Vehicles[VehicleID(ref)]; ok {
+		return record, nil
+	}
+	if record, ok := rc.VehicleByPlate(ref); ok {
+		return record, nil
+	}
+	return nil, fmt.Errorf("vehicle %s not registered", ref)
+}
+
+// checkDriverCanDrive makes sure the ride's driver is verified and driving an insured vehicle they own
+// with a seat for every passenger, the ride's Vehicle is replaced with the registered details
+func (rc *RideChain) checkDriverCanDrive(tx *RideTx) error {
+	at := now()
+	request, ok := rc.PendingVerifications[tx.DriverUUID]
+	if ok && request.Expired(at) {
+		return fmt.Errorf("%w: driver %s verification expired %s", ErrDriverSuspended, tx.DriverUUID, request.ExpiresAt.Format(time.DateOnly))
+	}
+	if !request.Active(at) {
+		return fmt.Errorf("%w: driver %s has no approved verification", ErrDriverNotVerified, tx.DriverUUID)
+	}
+
+	record, ok := rc.Vehicles[tx.Vehicle.ID]
+	if !ok || !slices.Contains(record.Owners, tx.DriverUUID) {
+		return fmt.Errorf("%w: driver %s has no registered vehicle %q", ErrVehicleNotOnFile, tx.DriverUUID, tx.Vehicle.ID)
+	}
+	if !record.Insured(at) {
+		return fmt.Errorf("%w: vehicle %s insurance is not current", ErrVehicleNotInsured, record.ID)
+	}
+	if err := checkSeats(*tx, record.Seats); err != nil {
+		return err
+	}
+	tx.Vehicle = record.Vehicle
+	return nil
+}
+
+// checkSeats rejects rides with more passengers than the vehicle seats
+func checkSeats(tx RideTx, seats int) error {
+	if tx.Passengers > seats {
+		return fmt.Errorf("%w: %d passengers in vehicle %s with %d seats", ErrTooManyPassengers, tx.Passengers, tx.Vehicle.ID, seats)
+	}
+	return nil
+}
+
+func normalizePlate(plate string) string {
+	return strings.ToUpper(strings.TrimSpace(plate))
+}
+
+func normalizeVIN(vin string) string {
+	return strings.ToUpper(strings.TrimSpace(vin))
+}
@@ -0,0 +1,214 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRideChain_SubmitPendingRideTx_DriverChecks(t *testing.T) {
+	driver := "driver-checks"
+
+	tests := []struct {
+		name    string
+		prepare func(rc *RideChain)
+		wantErr error
+	}{
+		{
+			name: "verified driver in an insured vehicle on file",
+			prepare: func(rc *RideChain) {
+				onboardTestDriver(t, rc, driver)
+			},
+		},
+		{
+			name:    "never verified",
+			prepare: func(rc *RideChain) {},
+			wantErr: ErrDriverNotVerified,
+		},
+		{
+			name: "verification still pending",
+			prepare: func(rc *RideChain) {
+				assert.Nil(t, requestTestVerification(t, rc, map[string]ed25519.PrivateKey{}, driver, driver))
+			},
+			wantErr: ErrDriverNotVerified,
+		},
+		{
+			name: "verification expired",
+			prepare: func(rc *RideChain) {
+				onboardTestDriver(t, rc, driver)
+				request := rc.PendingVerifications[driver]
+				request.ExpiresAt = time.Now().Add(-time.Hour)
+				rc.PendingVerifications[driver] = request
+			},
+			wantErr: ErrDriverSuspended,
+		},
+		{
+			name: "vehicle not registered",
+			prepare: func(rc *RideChain) {
+				onboardTestDriver(t, rc, driver)
+				delete(rc.Vehicles, testVehicle(driver).ID)
+			},
+			wantErr: ErrVehicleNotOnFile,
+		},
+		{
+			name: "vehicle owned by another driver",
+			prepare: func(rc *RideChain) {
+				onboardTestDriver(t, rc, driver)
+				rc.Vehicles[testVehicle(driver).ID].Owners = []string{"driver-other"}
+			},
+			wantErr: ErrVehicleNotOnFile,
+		},
+		{
+			name: "vehicle insurance lapsed",
+			prepare: func(rc *RideChain) {
+				onboardTestDriver(t, rc, driver)
+				rc.Vehicles[testVehicle(driver).ID].InsuredUntil = time.Now().Add(-time.Hour)
+			},
+			wantErr: ErrVehicleNotInsured,
+		},
+		{
+			name: "more passengers than seats",
+			prepare: func(rc *RideChain) {
+				onboardTestDriver(t, rc, driver)
+				rc.Vehicles[testVehicle(driver).ID].Seats = 1
+			},
+			wantErr: ErrTooManyPassengers,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			tt.prepare(rc)
+
+			tx := testRideTx(driver, "rider-checks")
+			tx.Passengers = 2
+			_, err = rc.SubmitPendingRideTx(tx)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				assert.False(t, rc.HasActiveRide(driver))
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, rc.HasActiveRide(driver))
+		})
+	}
+}
+
+func TestRideChain_VehicleRegistry(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+
+	record, err := rc.RegisterVehicle("driver-owner", " 1hgcm82633a004352 ", Vehicle{Plate: "tn-abc123", Seats: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, VehicleID("1HGCM82633A004352"), record.ID)
+	assert.Equal(t, "TN-ABC123", record.Plate)
+
+	_, err = rc.LookupVehicle(record.ID)
+	assert.NotNil(t, err, "the registration is not binding until a validator confirms it")
+	_, err = rc.RegisterVehicle("driver-owner", "1HGCM82633A004352", Vehicle{Plate: "TN-ABC123", Seats: 4})
+	assert.NotNil(t, err, "already waiting for a validator")
+	squat, err := rc.RegisterVehicle("driver-squat", "1HGCM82633A004352", Vehicle{Plate: "TN-ABC123", Seats: 4})
+	assert.Nil(t, err, "unconfirmed registrations do not lock the VIN")
+
+	assert.NotNil(t, rc.ConfirmVehicle(record.ID, "driver-owner", "driver-squat"), "not a validator")
+	assert.NotNil(t, rc.ConfirmVehicle(record.ID, "driver-nobody", "genesis-123"), "no such registration")
+	assert.Nil(t, rc.ConfirmVehicle(record.ID, "driver-owner", "genesis-123"))
+	assert.Equal(t, "genesis-123", record.ConfirmedBy)
+	assert.NotNil(t, rc.ConfirmVehicle(squat.ID, "driver-squat", "genesis-123"), "the competing claim was dropped")
+	assert.Empty(t, rc.PendingVehicles)
+
+	_, err = rc.RegisterVehicle("driver-other", "1HGCM82633A004352", Vehicle{Plate: "TN-XYZ", Seats: 4})
+	assert.NotNil(t, err, "VIN already registered")
+	_, err = rc.RegisterVehicle("driver-other", "2HGCM82633A004353", Vehicle{Plate: "TN-ABC123", Seats: 4})
+	assert.NotNil(t, err, "plate already registered")
+	_, err = rc.RegisterVehicle("driver-other", "2HGCM82633A004353", Vehicle{Plate: "TN-XYZ"})
+	assert.NotNil(t, err, "no seats")
+
+	for _, ref := range []string{record.ID, "1hgcm82633a004352", "tn-abc123"} {
+		found, err := rc.LookupVehicle(ref)
+		assert.Nil(t, err)
+		assert.Equal(t, record.ID, found.ID)
+	}
+	_, err = rc.LookupVehicle("TN-NOPE")
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, rc.AddVehicleOwner(record.ID, "driver-other", "driver-other"), "only an owner can share")
+	assert.Nil(t, rc.AddVehicleOwner(record.ID, "driver-owner", "driver-other"))
+	assert.Equal(t, []string{"driver-owner", "driver-other"}, record.Owners)
+
+	assert.NotNil(t, rc.InsureVehicle(record.ID, "driver-owner", "policy-1", time.Now().Add(time.Hour)), "not a validator")
+	assert.NotNil(t, rc.InsureVehicle(record.ID, "genesis-123", "policy-1", time.Now().Add(-time.Hour)), "already expired")
+	assert.Nil(t, rc.InsureVehicle(record.ID, "genesis-123", "policy-1", time.Now().Add(time.Hour)))
+	assert.True(t, record.Insured(time.Now()))
+	assert.Equal(t, "genesis-123", record.InsuredBy)
+}
+
+func TestRideChain_ConfirmVehicle_RegistrationProvider(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	mock := newMockVerificationServer(t)
+	rc.VerificationProviders = mock.providers()
+
+	owner, err := rc.RegisterVehicle("driver-owner", testVIN("driver-owner"), Vehicle{Plate: "TN-OWN", Seats: 4})
+	assert.Nil(t, err)
+	squat, err := rc.RegisterVehicle("driver-squat", testVIN("driver-owner"), Vehicle{Plate: "TN-OWN", Seats: 4})
+	assert.Nil(t, err)
+	mock.clear("driver-owner", time.Now().Add(time.Hour))
+	mock.clear("driver-squat", time.Now().Add(time.Hour))
+
+	assert.NotNil(t, rc.ConfirmVehicle(squat.ID, "driver-squat", "genesis-123"), "the squatter's registration names another VIN")
+	assert.Nil(t, rc.ConfirmVehicle(owner.ID, "driver-owner", "genesis-123"))
+	found, err := rc.LookupVehicle("TN-OWN")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"driver-owner"}, found.Owners)
+}
+
+func TestRideChain_VehicleRecords(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	validator := "genesis-123"
+	assert.Nil(t, rc.BecomeValidator(validator))
+	_, key, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	// the validator's node signs blocks, so every registry change is committed as it happens
+	assert.Nil(t, rc.SetSigner(validator, key))
+
+	own, err := rc.RegisterVehicle(validator, "2HGCM82633A004353", Vehicle{Plate: "TN-VAL", Seats: 4})
+	assert.Nil(t, err)
+	assert.NotNil(t, rc.ConfirmVehicle(own.ID, validator, validator), "even a sole validator cannot confirm their own vehicle")
+
+	record, err := rc.RegisterVehicle("driver-owner", "1HGCM82633A004352", Vehicle{Plate: "TN-ABC123", Seats: 4})
+	assert.Nil(t, err)
+	_, ok := rc.RecordBlock(RecordVehicleClaim, vehicleClaimID(record.ID, "driver-owner"))
+	assert.True(t, ok, "the registration is committed")
+	assert.Nil(t, rc.ConfirmVehicle(record.ID, "driver-owner", validator))
+	assert.Nil(t, rc.InsureVehicle(record.ID, validator, "policy-1", time.Now().Add(time.Hour)))
+	assert.Nil(t, rc.AddVehicleOwner(record.ID, "driver-owner", validator))
+	assert.NotNil(t, rc.InsureVehicle(record.ID, validator, "policy-2", time.Now().Add(2*time.Hour)), "the validator now owns the vehicle")
+	hash, ok := rc.RecordBlock(RecordVehicle, record.ID)
+	assert.True(t, ok)
+	assert.Equal(t, rc.Chain.Head, hash)
+
+	// a node that saw none of it rebuilds the registry from the blocks
+	peer, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	for _, b := range rc.Chain.CanonicalChain() {
+		for _, r := range b.Records {
+			assert.Nil(t, peer.applyRecord(r, b.Hash))
+		}
+	}
+	found, err := peer.LookupVehicle("TN-ABC123")
+	assert.Nil(t, err)
+	assert.Equal(t, validator, found.ConfirmedBy)
+	assert.Equal(t, "policy-1", found.InsurancePolicy)
+	assert.True(t, found.Insured(time.Now()))
+	assert.Equal(t, []string{"driver-owner", validator}, found.Owners)
+	assert.Len(t, peer.PendingVehicles, 1, "only the validator's own unconfirmed claim is left")
+}
@@ -0,0 +1,330 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrVerificationUnavailable is returned when a provider could not be reached, the request stays pending
+	ErrVerificationUnavailable = errors.New("verification provider unavailable")
+	// ErrDriverSuspended is returned when a driver's verification expired and they must re-verify
+	ErrDriverSuspended = errors.New("driver suspended until re-verified")
+)
+
+type VerificationCheck string
+
+const (
+	// CheckBackground is a criminal and sex offender registry search, e.g. https://sor.tbi.tn.gov/api/search
+	CheckBackground VerificationCheck = "background"
+	// CheckInsurance is a state insurance lookup, e.g. https://verifyinsurance.revenue.tn.gov/assets/api/api.php
+	CheckInsurance    VerificationCheck = "insurance"
+	CheckLicense      VerificationCheck = "license"
+	CheckRegistration VerificationCheck = "registration"
+	// CheckManual is a validator's own review, used when no provider is configured
+	CheckManual VerificationCheck = "manual"
+)
+
+// VerificationPolicy sets how drivers are verified
+type VerificationPolicy struct {
+	// Attestations is how many validators must agree to approve, or to reject, a driver
+	Attestations int
+	// ValidFor is how long an approval lasts, a license or policy expiring sooner ends it earlier
+	ValidFor time.Duration
+}
+
+// DefaultVerificationPolicy is monthly KYC attested by a single validator
+func DefaultVerificationPolicy() VerificationPolicy {
+	return VerificationPolicy{
+		Attestations: 1,
+		ValidFor:     30 * 24 * time.Hour,
+	}
+}
+
+// Attestation is one validator's verdict on a driver
+type Attestation struct {
+	Validator string
+	Approved  bool
+	Notes     string
+	Timestamp time.Time
+	Results   []VerificationResult
+}
+
+// VerificationResult is one provider's structured answer about a driver
+type VerificationResult struct {
+	Check    VerificationCheck `json:"check"`
+	Provider string            `json:"provider"`
+	Passed   bool              `json:"passed"`
+	// Reason explains the outcome, e.g. "registry match" or "policy lapsed"
+	Reason    string `json:"reason,omitempty"`
+	Reference string `json:"reference,omitempty"` // provider's record or policy number
+	// ExpiresAt is when the insurance policy, license or registration runs out, zero if it does not
+	ExpiresAt time.Time `json:"expiresAt"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// VerificationProvider runs one kind of check against an outside record source
+type VerificationProvider interface {
+	Name() string
+	Check() VerificationCheck
+	Verify(ctx context.Context, driverUUID string) (VerificationResult, error)
+}
+
+// HTTPVerificationProvider asks a JSON API for GET {BaseURL}/{check}/{driverUUID}
+// and expects a VerificationResult back
+type HTTPVerificationProvider struct {
+	Provider string
+	Kind     VerificationCheck
+	BaseURL  string
+	HTTP     *http.Client
+}
+
+func NewHTTPVerificationProvider(name string, check VerificationCheck, baseURL string) *HTTPVerificationProvider {
+	return &HTTPVerificationProvider{
+		Provider: name,
+		Kind:     check,
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		HTTP:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *HTTPVerificationProvider) Name() string { return p.Provider }
+
+func (p *HTTPVerificationProvider) Check() VerificationCheck { return p.Kind }
+
+func (p *HTTPVerificationProvider) Verify(ctx context.Context, driverUUID string) (VerificationResult, error) {
+	endpoint := p.BaseURL + "/" + string(p.Kind) + "/" + url.PathEscape(driverUUID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return VerificationResult{}, err
+	}
+	resp, err := p.HTTP.Do(req)
+	if err != nil {
+		return VerificationResult{}, fmt.Errorf("%w: %s: %v", ErrVerificationUnavailable, p.Provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return VerificationResult{}, fmt.Errorf("%w: %s returned status %d", ErrVerificationUnavailable, p.Provider, resp.StatusCode)
+	}
+
+	var result VerificationResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return VerificationResult{}, fmt.Errorf("%s returned an unreadable result: %v", p.Provider, err)
+	}
+	result.Check = p.Kind
+	result.Provider = p.Provider
+	if result.CheckedAt.IsZero() {
+		result.CheckedAt = now().UTC()
+	}
+	return result, nil
+}
+
+// attestDriver records a validator's verdict on the driver's pending round and decides
+// the round once VerificationPolicy.Attestations validators agree either way
+func (rc *RideChain) attestDriver(driverUUID, validator string, approve bool, notes string) error {
+	if !rc.IsValidator(validator) {
+		return fmt.Errorf("%s is not a validator", validator)
+	}
+
+	// check pending verificaiton request
+	request, exists := rc.PendingVerifications[driverUUID]
+	if !exists || request.Status != VerificationPending {
+		return fmt.Errorf("no pending verification for driver %s", driverUUID)
+	}
+	if validator == driverUUID {
+		return fmt.Errorf("validator %s cannot attest to their own verification", validator)
+	}
+	if _, attested := request.Attestations[validator]; attested {
+		return fmt.Errorf("validator %s already attested to driver %s", validator, driverUUID)
+	}
+
+	attestation := Attestation{
+		Validator: validator,
+		Approved:  approve,
+		Notes:     notes,
+		Timestamp: now().UTC(),
+	}
+	if approve {
+		checks, err := rc.runVerifications(driverUUID)
+		if err != nil {
+			return err
+		}
+		if len(checks) == 0 {
+			checks = append(checks, VerificationResult{
+				Check:     CheckManual,
+				Provider:  "manual",
+				Passed:    true,
+				Reason:    notes,
+				CheckedAt: attestation.Timestamp,
+			})
+		}
+		for _, check := range checks {
+			if !check.Passed {
+				attestation.Approved = false
+			}
+		}
+		attestation.Results = checks
+	} else {
+		attestation.Results = []VerificationResult{{
+			Check:     CheckManual,
+			Provider:  "manual",
+			Passed:    false,
+			Reason:    notes,
+			CheckedAt: attestation.Timestamp,
+		}}
+	}
+	// the round is updated on a copy so nothing changes when the record cannot be committed
+	events := make([]RideTxEvt, 0, len(request.Events)+len(attestation.Results))
+	events = append(events, request.Events...)
+	for _, result := range attestation.Results {
+		events = append(events, verificationEvent(result, validator))
+	}
+	request.Events = events
+	attestations := make(map[string]Attestation, len(request.Attestations)+1)
+	for v, a := range request.Attestations {
+		attestations[v] = a
+	}
+	attestations[validator] = attestation
+	request.Attestations = attestations
+
+	approvals, rejections := 0, 0
+	for _, a := range request.Attestations {
+		if a.Approved {
+			approvals++
+		} else {
+			rejections++
+		}
+	}
+	switch {
+	case approvals >= rc.VerificationPolicy.Attestations:
+		request.Status = VerificationApproved
+		request.Results = mergeResults(request.Attestations, true)
+		request.VerifiedAt = now()
+		request.ExpiresAt = request.VerifiedAt.Add(rc.VerificationPolicy.ValidFor)
+		for _, result := range request.Results {
+			if !result.ExpiresAt.IsZero() && result.ExpiresAt.Before(request.ExpiresAt) {
+				request.ExpiresAt = result.ExpiresAt
+			}
+		}
+	case rejections >= rc.VerificationPolicy.Attestations:
+		request.Status = VerificationRejected
+		request.Results = mergeResults(request.Attestations, false)
+		request.VerifiedAt = time.Time{}
+		request.ExpiresAt = time.Time{}
+	}
+	if err := rc.commitVerification(request); err != nil {
+		return err
+	}
+
+	fmt.Printf("Driver %s attested by validator %s (approved %t, %d/%d approvals, %d/%d rejections) with results %s\n",
+		driverUUID, validator, attestation.Approved, approvals, rc.VerificationPolicy.Attestations, rejections, rc.VerificationPolicy.Attestations, notes)
+	return nil
+}
+
+// mergeResults keeps the latest result of each check and provider across the attestations
+// that approved, or rejected, the driver
+func mergeResults(attestations map[string]Attestation, approved bool) []VerificationResult {
+	latest := make(map[string]VerificationResult)
+	for _, a := range attestations {
+		if a.Approved != approved {
+			continue
+		}
+		for _, result := range a.Results {
+			key := string(result.Check) + "/" + result.Provider
+			if seen, ok := latest[key]; !ok || result.CheckedAt.After(seen.CheckedAt) {
+				latest[key] = result
+			}
+		}
+	}
+	results := make([]VerificationResult, 0, len(latest))
+	for _, result := range latest {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Check != results[j].Check {
+			return results[i].Check < results[j].Check
+		}
+		return results[i].Provider < results[j].Provider
+	})
+	return results
+}
+
+// commitVerification queues the driver's verification round as a RecordVerification and
+// keeps it once the record is committed, or queued when this node does not sign blocks
+func (rc *RideChain) commitVerification(request DriverVerificationRequest) error {
+	if err := rc.queueRecord(RecordVerification, request.DriverUUID, request); err != nil {
+		return err
+	}
+	if err := rc.flushRecords(); err != nil {
+		rc.dropRecord(RecordVerification, request.DriverUUID)
+		return err
+	}
+	rc.PendingVerifications[request.DriverUUID] = request
+	return nil
+}
+
+// adoptVerification takes a committed verification round unless this node already holds
+// the same round with as many attestations or a later round
+func (rc *RideChain) adoptVerification(request DriverVerificationRequest) {
+	if local, ok := rc.PendingVerifications[request.DriverUUID]; ok {
+		behind := local.Timestamp.Before(request.Timestamp) ||
+			(local.Timestamp.Equal(request.Timestamp) && len(local.Attestations) < len(request.Attestations))
+		if !behind {
+			return
+		}
+	}
+	rc.PendingVerifications[request.DriverUUID] = request
+}
+
+// runVerifications asks every configured provider about the driver, a provider
+// error leaves the request untouched so a validator can retry
+func (rc *RideChain) runVerifications(driverUUID string) ([]VerificationResult, error) {
+	results := make([]VerificationResult, 0, len(rc.VerificationProviders))
+	for _, provider := range rc.VerificationProviders {
+		result, err := provider.Verify(context.Background(), driverUUID)
+		if err != nil {
+			return nil, err
+		}
+		if !result.ExpiresAt.IsZero() && !result.ExpiresAt.After(now()) {
+			result.Passed = false
+			result.Reason = fmt.Sprintf("expired %s", result.ExpiresAt.Format(time.DateOnly))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// verificationEvent records a result as InsuranceVerified for insurance and DriverValidated otherwise
+func verificationEvent(result VerificationResult, validator string) RideTxEvt {
+	eventType := DriverValidated
+	if result.Check == CheckInsurance {
+		eventType = InsuranceVerified
+	}
+	metadata := map[string]interface{}{
+		"check":    string(result.Check),
+		"provider": result.Provider,
+		"passed":   result.Passed,
+	}
+	if result.Reason != "" {
+		metadata["reason"] = result.Reason
+	}
+	if result.Reference != "" {
+		metadata["reference"] = result.Reference
+	}
+	if !result.ExpiresAt.IsZero() {
+		metadata["expiresAt"] = result.ExpiresAt.Format(time.RFC3339)
+	}
+	return RideTxEvt{
+		EventType: eventType,
+		Timestamp: result.CheckedAt,
+		Validator: validator,
+		Metadata:  metadata,
+	}
+}
@@ -0,0 +1,293 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockVerificationServer stands in for the registry, insurance and DMV lookups
+// answering GET /{check}/{driverUUID} from canned results
+type mockVerificationServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	results map[string]VerificationResult // check/driverUUID -> result
+}
+
+func newMockVerificationServer(t *testing.T) *mockVerificationServer {
+	m := &mockVerificationServer{results: make(map[string]VerificationResult)}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		result, ok := m.results[strings.TrimPrefix(r.URL.Path, "/")]
+		m.mu.Unlock()
+		if !ok {
+			http.Error(w, "unknown driver", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockVerificationServer) set(check VerificationCheck, driverUUID string, result VerificationResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[string(check)+"/"+driverUUID] = result
+}
+
+func (m *mockVerificationServer) providers() []VerificationProvider {
+	var providers []VerificationProvider
+	for _, check := range []VerificationCheck{CheckBackground, CheckInsurance, CheckLicense, CheckRegistration} {
+		providers = append(providers, NewHTTPVerificationProvider("mock-"+string(check), check, m.URL))
+	}
+	return providers
+}
+
+func (m *mockVerificationServer) clear(driverUUID string, expires time.Time) {
+	m.set(CheckBackground, driverUUID, VerificationResult{Passed: true, Reference: "sor-0"})
+	m.set(CheckInsurance, driverUUID, VerificationResult{Passed: true, Reference: "policy-1", ExpiresAt: expires})
+	m.set(CheckLicense, driverUUID, VerificationResult{Passed: true, ExpiresAt: expires})
+	m.set(CheckRegistration, driverUUID, VerificationResult{Passed: true, Reference: testVIN(driverUUID), ExpiresAt: expires})
+}
+
+// requestTestVerification has the driver sign a new verification round, a key is registered
+// for a driver missing from keys
+func requestTestVerification(t *testing.T, rc *RideChain, keys map[string]ed25519.PrivateKey, driver, requestedBy string) error {
+	t.Helper()
+	if _, ok := keys[driver]; !ok {
+		keys[driver] = registerTestKeys(t, rc, driver)[driver]
+	}
+	at := now()
+	return rc.RequestDriverVerification(driver, requestedBy, at, ed25519.Sign(keys[driver], VerificationRequestSigningBytes(driver, requestedBy, at)))
+}
+
+func TestRideChain_VerifyDriverProviders(t *testing.T) {
+	validator := "genesis-123"
+	expires := time.Now().Add(180 * 24 * time.Hour)
+
+	tests := []struct {
+		name       string
+		driver     string
+		prepare    func(m *mockVerificationServer, driver string)
+		wantErr    error
+		wantStatus string
+	}{
+		{
+			name:       "every check passes",
+			driver:     "driver-clear",
+			prepare:    func(m *mockVerificationServer, driver string) { m.clear(driver, expires) },
+			wantStatus: "approved",
+		},
+		{
+			name:   "registry match",
+			driver: "driver-flagged",
+			prepare: func(m *mockVerificationServer, driver string) {
+				m.clear(driver, expires)
+				m.set(CheckBackground, driver, VerificationResult{Passed: false, Reason: "registry match"})
+			},
+			wantStatus: "rejected",
+		},
+		{
+			name:   "insurance already expired",
+			driver: "driver-lapsed",
+			prepare: func(m *mockVerificationServer, driver string) {
+				m.clear(driver, expires)
+				m.set(CheckInsurance, driver, VerificationResult{Passed: true, ExpiresAt: time.Now().Add(-time.Hour)})
+			},
+			wantStatus: "rejected",
+		},
+		{
+			name:       "provider down leaves the request pending",
+			driver:     "driver-unknown",
+			prepare:    func(m *mockVerificationServer, driver string) {},
+			wantErr:    ErrVerificationUnavailable,
+			wantStatus: "pending",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			assert.Nil(t, rc.BecomeValidator(validator))
+			mock := newMockVerificationServer(t)
+			rc.VerificationProviders = mock.providers()
+			tt.prepare(mock, tt.driver)
+
+			assert.Nil(t, requestTestVerification(t, rc, map[string]ed25519.PrivateKey{}, tt.driver, validator))
+			err = rc.VerifyDriver(tt.driver, validator, "")
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			request := rc.PendingVerifications[tt.driver]
+			assert.Equal(t, tt.wantStatus, request.Status)
+			if tt.wantErr != nil {
+				assert.Empty(t, request.Events)
+				return
+			}
+			assert.Len(t, request.Results, 4)
+			assert.Len(t, request.Events, 4)
+			assert.Equal(t, DriverValidated, request.Events[0].EventType)
+			assert.Equal(t, InsuranceVerified, request.Events[1].EventType)
+			assert.Equal(t, validator, request.Events[1].Validator)
+		})
+	}
+}
+
+func TestRideChain_VerificationAttestations(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	for _, v := range []string{"validator-2", "validator-3"} {
+		rc.TokenLedger.Mint(v, 10)
+		assert.Nil(t, rc.TokenLedger.Stake(v, 10))
+		assert.Nil(t, rc.BecomeValidator(v))
+	}
+	rc.VerificationPolicy.Attestations = 2
+
+	approved, rejected := "driver-attested", "driver-rejected"
+	keys := make(map[string]ed25519.PrivateKey)
+	assert.Nil(t, requestTestVerification(t, rc, keys, approved, approved))
+	assert.NotNil(t, requestTestVerification(t, rc, keys, approved, approved), "already pending")
+	assert.Nil(t, requestTestVerification(t, rc, keys, rejected, rejected))
+
+	assert.NotNil(t, rc.VerifyDriver(approved, "driver-attested", ""), "not a validator")
+	assert.Nil(t, rc.VerifyDriver(approved, "genesis-123", "looks good"))
+	assert.NotNil(t, rc.VerifyDriver(approved, "genesis-123", ""), "attested twice")
+	assert.Equal(t, VerificationPending, rc.PendingVerifications[approved].Status)
+	assert.Nil(t, rc.VerifyDriver(approved, "validator-2", "looks good"))
+
+	request := rc.PendingVerifications[approved]
+	assert.Equal(t, VerificationApproved, request.Status)
+	assert.True(t, request.Active(time.Now()))
+	assert.WithinDuration(t, request.VerifiedAt.Add(rc.VerificationPolicy.ValidFor), request.ExpiresAt, time.Second)
+	assert.Len(t, request.Events, 2)
+	assert.NotNil(t, rc.VerifyDriver(approved, "validator-3", ""), "round already decided")
+
+	assert.Nil(t, rc.RejectDriver(rejected, "genesis-123", "license photo does not match"))
+	assert.Nil(t, rc.VerifyDriver(rejected, "validator-2", ""))
+	assert.Equal(t, VerificationPending, rc.PendingVerifications[rejected].Status, "split vote waits for a third validator")
+	assert.Nil(t, rc.RejectDriver(rejected, "validator-3", "registration lapsed"))
+	request = rc.PendingVerifications[rejected]
+	assert.Equal(t, VerificationRejected, request.Status)
+	assert.False(t, request.Active(time.Now()))
+	assert.Equal(t, false, request.Events[len(request.Events)-1].Metadata["passed"])
+}
+
+func TestRideChain_VerificationExpiry(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	validator, driver := "genesis-123", "driver-"+uuid.NewString()
+	assert.Nil(t, rc.BecomeValidator(validator))
+
+	mock := newMockVerificationServer(t)
+	rc.VerificationProviders = mock.providers()
+	insuranceEnds := time.Now().Add(10 * 24 * time.Hour)
+	mock.clear(driver, time.Now().Add(365*24*time.Hour))
+	mock.set(CheckInsurance, driver, VerificationResult{Passed: true, ExpiresAt: insuranceEnds})
+
+	keys := registerTestKeys(t, rc, driver)
+	assert.Nil(t, requestTestVerification(t, rc, keys, driver, driver))
+	assert.Nil(t, rc.VerifyDriver(driver, validator, ""))
+	assert.True(t, rc.PendingVerifications[driver].ExpiresAt.Equal(insuranceEnds), "insurance ends before the 30 day approval")
+	onboardTestDriver(t, rc, driver)
+
+	tx, err := rc.SubmitPendingRideTx(testRideTx(driver, "rider-expiry"))
+	assert.Nil(t, err)
+	cancelled, err := rc.CancelRide(driver, DriverCancelled, driver, ed25519.Sign(keys[driver], CancellationSigningBytes(tx, DriverCancelled, driver)))
+	assert.Nil(t, err)
+	_, err = rc.ApproveRideTx(cancelled, validator)
+	assert.Nil(t, err)
+	// the cancelled ride was committed with its session, later attempts pay again
+	retry := testRideTx(driver, "rider-expiry")
+	retry.StripeSessionId += "-retry"
+
+	defer func() { now = time.Now }()
+	now = func() time.Time { return insuranceEnds.Add(time.Hour) }
+
+	_, err = rc.SubmitPendingRideTx(retry)
+	assert.True(t, errors.Is(err, ErrDriverSuspended), "got %v", err)
+
+	_, err = rc.SweepExpired()
+	assert.Nil(t, err)
+	assert.Equal(t, VerificationExpired, rc.PendingVerifications[driver].Status)
+	assert.Equal(t, 1, rc.ExpiryMetrics().Suspensions)
+
+	// re-verifying with a renewed policy lifts the suspension
+	mock.set(CheckInsurance, driver, VerificationResult{Passed: true, ExpiresAt: insuranceEnds.Add(365 * 24 * time.Hour)})
+	assert.Nil(t, requestTestVerification(t, rc, keys, driver, driver))
+	_, err = rc.SubmitPendingRideTx(retry)
+	assert.True(t, errors.Is(err, ErrDriverSuspended), "still suspended while the round is pending")
+	assert.Nil(t, rc.VerifyDriver(driver, validator, "renewed"))
+	_, err = rc.SubmitPendingRideTx(retry)
+	assert.Nil(t, err)
+}
+
+func TestRideChain_RequestDriverVerification_Signed(t *testing.T) {
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	validator, driver := "genesis-123", "driver-signed"
+	assert.Nil(t, rc.BecomeValidator(validator))
+	rc.TokenLedger.Mint("validator-2", 10)
+	assert.Nil(t, rc.TokenLedger.Stake("validator-2", 10))
+	assert.Nil(t, rc.BecomeValidator("validator-2"))
+	keys := registerTestKeys(t, rc, validator, driver)
+	// the validator's node signs blocks, so verification rounds are committed as they change
+	assert.Nil(t, rc.SetSigner(validator, keys[validator]))
+	rc.VerificationPolicy.Attestations = 2
+	mock := newMockVerificationServer(t)
+	rc.VerificationProviders = mock.providers()
+	mock.clear(driver, time.Now().Add(365*24*time.Hour))
+
+	at := time.Now()
+	signature := ed25519.Sign(keys[driver], VerificationRequestSigningBytes(driver, driver, at))
+	forged := ed25519.Sign(keys[validator], VerificationRequestSigningBytes(driver, driver, at))
+	assert.NotNil(t, rc.RequestDriverVerification(driver, driver, at, forged), "only the driver can open their round")
+	assert.NotNil(t, rc.RequestDriverVerification(driver, driver, at, nil))
+	assert.NotNil(t, rc.RequestDriverVerification(driver, validator, at, signature), "the signature names who asked")
+	assert.Nil(t, rc.RequestDriverVerification(driver, driver, at, signature))
+	opened, ok := rc.RecordBlock(RecordVerification, driver)
+	assert.True(t, ok, "the round is committed in a block")
+
+	assert.Nil(t, rc.VerifyDriver(driver, validator, "providers clear"))
+	// the second validator reviews by hand, its result joins the providers' instead of replacing them
+	rc.VerificationProviders = nil
+	assert.Nil(t, rc.VerifyDriver(driver, "validator-2", "documents checked"))
+	request := rc.PendingVerifications[driver]
+	assert.Equal(t, VerificationApproved, request.Status)
+	assert.Len(t, request.Results, 5)
+	assert.Len(t, request.Events, 5)
+	assert.True(t, hasCarInsurance(request, time.Now()))
+	decided, ok := rc.RecordBlock(RecordVerification, driver)
+	assert.True(t, ok)
+	assert.NotEqual(t, opened, decided)
+
+	assert.NotNil(t, rc.RequestDriverVerification(driver, driver, at, signature), "a signature opens one round")
+
+	// a node that did not attest adopts the decided round from the blocks
+	peer, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	for _, hash := range []string{decided, opened} {
+		b, _ := rc.Chain.Get(hash)
+		for _, r := range b.Records {
+			assert.Nil(t, peer.applyRecord(r, hash))
+		}
+	}
+	adopted := peer.PendingVerifications[driver]
+	assert.Equal(t, VerificationApproved, adopted.Status, "an older round does not replace a newer one")
+	assert.Equal(t, request.Results, adopted.Results)
+	assert.True(t, adopted.Active(time.Now()))
+}
@@ -0,0 +1,262 @@
+package blockchain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrWebhookSignature is returned for callbacks that were not signed with the webhook secret
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	// ErrUnknownPayment is returned for refunds and disputes on a payment no ride was paid with,
+	// the provider retries so it is applied once the paid callback arrives
+	ErrUnknownPayment = errors.New("unknown payment")
+)
+
+// stripeWebhookTolerance bounds how old a signed callback can be before it is treated as a replay
+const stripeWebhookTolerance = 5 * time.Minute
+
+type PaymentEventKind string
+
+const (
+	PaymentPaid     PaymentEventKind = "paid"
+	PaymentRefunded PaymentEventKind = "refunded"
+	PaymentDisputed PaymentEventKind = "disputed"
+)
+
+// PaymentEvent is a payment provider callback about a rider's checkout, amounts are cents
+type PaymentEvent struct {
+	ID            string           `json:"id"` // provider event ID, applying the same ID twice is a no-op
+	Kind          PaymentEventKind `json:"kind"`
+	SessionID     string           `json:"sessionId"`
+	PaymentIntent string           `json:"paymentIntent"`
+	// Amount is what was paid, the total refunded so far or the amount disputed
+	Amount  int       `json:"amount"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// RideTxEvt is how the callback is recorded in the ride's events
+func (e PaymentEvent) RideTxEvt() RideTxEvt {
+	eventType := RiderPaymentRecieved
+	switch e.Kind {
+	case PaymentRefunded:
+		eventType = RiderPaymentRefunded
+	case PaymentDisputed:
+		eventType = RiderPaymentDisputed
+	}
+	metadata := map[string]interface{}{
+		"paymentEventId": e.ID,
+		"sessionId":      e.SessionID,
+		"paymentIntent":  e.PaymentIntent,
+		"amount":         e.Amount,
+	}
+	if e.Reason != "" {
+		metadata["reason"] = e.Reason
+	}
+	return RideTxEvt{
+		EventType: eventType,
+		Timestamp: e.Created,
+		Metadata:  metadata,
+	}
+}
+
+// stripeEvent is the envelope Stripe posts to a webhook endpoint
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object stripeObject `json:"object"`
+	} `json:"data"`
+}
+
+// stripeObject holds the fields used from checkout sessions, charges and disputes
+type stripeObject struct {
+	ID             string `json:"id"`
+	AmountTotal    int    `json:"amount_total"`
+	AmountRefunded int    `json:"amount_refunded"`
+	Amount         int    `json:"amount"`
+	PaymentStatus  string `json:"payment_status"`
+	PaymentIntent  string `json:"payment_intent"`
+	Reason         string `json:"reason"`
+}
+
+// ParseStripeWebhook verifies the Stripe-Signature header against the webhook secret and reads
+// the callback, ok is false for event types that say nothing about a ride's payment
+func ParseStripeWebhook(payload []byte, signatureHeader, secret string, at time.Time) (evt PaymentEvent, ok bool, err error) {
+	if err := verifyStripeSignature(payload, signatureHeader, secret, at); err != nil {
+		return PaymentEvent{}, false, err
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return PaymentEvent{}, false, fmt.Errorf("unreadable stripe event: %v", err)
+	}
+	object := event.Data.Object
+	evt = PaymentEvent{
+		ID:            event.ID,
+		PaymentIntent: object.PaymentIntent,
+		Created:       time.Unix(event.Created, 0).UTC(),
+	}
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		// completed sessions paid by bank transfer settle later with async_payment_succeeded
+		if object.PaymentStatus != PaymentStatusPaid {
+			return PaymentEvent{}, false, nil
+		}
+		evt.Kind = PaymentPaid
+		evt.SessionID = object.ID
+		evt.Amount = object.AmountTotal
+	case "charge.refunded":
+		evt.Kind = PaymentRefunded
+		evt.Amount = object.AmountRefunded
+	case "charge.dispute.created":
+		evt.Kind = PaymentDisputed
+		evt.Amount = object.Amount
+		evt.Reason = object.Reason
+	default:
+		return PaymentEvent{}, false, nil
+	}
+	return evt, true, nil
+}
+
+// StripeSignature builds the Stripe-Signature header for a payload, for local tooling and tests
+func StripeSignature(payload []byte, secret string, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(stripeMAC(payload, secret, timestamp))
+}
+
+// verifyStripeSignature checks any v1 signature in the header matches and the timestamp is recent
+func verifyStripeSignature(payload []byte, header, secret string, at time.Time) error {
+	if secret == "" {
+		return fmt.Errorf("%w: no webhook secret configured", ErrWebhookSignature)
+	}
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed header %q", ErrWebhookSignature, header)
+	}
+	if age := at.Sub(time.Unix(seconds, 0)); age > stripeWebhookTolerance || age < -stripeWebhookTolerance {
+		return fmt.Errorf("%w: signed %s ago", ErrWebhookSignature, age.Round(time.Second))
+	}
+
+	expected := stripeMAC(payload, secret, timestamp)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no matching signature", ErrWebhookSignature)
+}
+
+func stripeMAC(payload []byte, secret, timestamp string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// ApplyPaymentEvent records a provider callback against the session it concerns and appends it
+// to the session's pending ride, a ride submitted later picks it up in SubmitPendingRideTx and a
+// committed ride shows it in RideHistory, applied is false when the event was already recorded
+func (rc *RideChain) ApplyPaymentEvent(evt PaymentEvent) (applied bool, err error) {
+	if evt.ID == "" {
+		return false, errors.New("payment event has no ID")
+	}
+	for _, events := range rc.Payments {
+		for _, existing := range events {
+			if existing.ID == evt.ID {
+				return false, nil
+			}
+		}
+	}
+	if evt.SessionID == "" {
+		evt.SessionID = rc.sessionForIntent(evt.PaymentIntent)
+	}
+	if evt.SessionID == "" {
+		return false, fmt.Errorf("%w: %s event %s for payment intent %q", ErrUnknownPayment, evt.Kind, evt.ID, evt.PaymentIntent)
+	}
+
+	rc.Payments[evt.SessionID] = append(rc.Payments[evt.SessionID], evt)
+	for driver, tx := range rc.PendingRideTxs {
+		if tx.StripeSessionId == evt.SessionID {
+			tx.RideTxEvts = append(tx.RideTxEvts, evt.RideTxEvt())
+			rc.PendingRideTxs[driver] = tx
+		}
+	}
+	fmt.Printf("Payment %s %s for session %s, %d cents\n", evt.ID, evt.Kind, evt.SessionID, evt.Amount)
+	return true, nil
+}
+
+// sessionForIntent finds the session a paid callback tied to the payment intent
+func (rc *RideChain) sessionForIntent(paymentIntent string) string {
+	if paymentIntent == "" {
+		return ""
+	}
+	for sessionID, events := range rc.Payments {
+		for _, evt := range events {
+			if evt.PaymentIntent == paymentIntent {
+				return sessionID
+			}
+		}
+	}
+	return ""
+}
+
+// attachPaymentEvents adds callbacks received before the ride was submitted to its events
+func (rc *RideChain) attachPaymentEvents(tx *RideTx) {
+	recorded := make(map[interface{}]bool)
+	for _, e := range tx.RideTxEvts {
+		recorded[e.Metadata["paymentEventId"]] = true
+	}
+	for _, evt := range rc.Payments[tx.StripeSessionId] {
+		if !recorded[evt.ID] {
+			tx.RideTxEvts = append(tx.RideTxEvts, evt.RideTxEvt())
+		}
+	}
+}
+
+// withoutPaymentEvents copies events without the paid, refunded and disputed callbacks
+func withoutPaymentEvents(events []RideTxEvt) []RideTxEvt {
+	kept := make([]RideTxEvt, 0, len(events))
+	for _, e := range events {
+		switch e.EventType {
+		case RiderPaymentRecieved, RiderPaymentRefunded, RiderPaymentDisputed:
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// eventAmount reads the cents a payment event carries, events added by hand may have none
+func eventAmount(e RideTxEvt) (int, bool) {
+	switch amount := e.Metadata["amount"].(type) {
+	case int:
+		return amount, true
+	case float64:
+		// metadata read back from json
+		return int(amount), true
+	}
+	return 0, false
+}
@@ -0,0 +1,238 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testWebhookSecret = "whsec_blockshare"
+
+// stripeWebhook renders a Stripe event envelope around object
+func stripeWebhook(id, eventType, object string) []byte {
+	return []byte(fmt.Sprintf(`{"id":%q,"type":%q,"created":%d,"data":{"object":%s}}`, id, eventType, time.Now().Unix(), object))
+}
+
+func TestParseStripeWebhook(t *testing.T) {
+	paid := stripeWebhook("evt_paid", "checkout.session.completed",
+		`{"id":"cs_1","amount_total":500,"payment_status":"paid","payment_intent":"pi_1"}`)
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		signature func(payload []byte) string
+		wantErr   error
+		wantOK    bool
+		wantEvent PaymentEvent
+	}{
+		{
+			name:    "paid checkout",
+			payload: paid,
+			wantOK:  true,
+			wantEvent: PaymentEvent{
+				ID: "evt_paid", Kind: PaymentPaid, SessionID: "cs_1", PaymentIntent: "pi_1", Amount: 500,
+			},
+		},
+		{
+			name:    "refunded charge",
+			payload: stripeWebhook("evt_refund", "charge.refunded", `{"id":"ch_1","amount_refunded":200,"payment_intent":"pi_1"}`),
+			wantOK:  true,
+			wantEvent: PaymentEvent{
+				ID: "evt_refund", Kind: PaymentRefunded, PaymentIntent: "pi_1", Amount: 200,
+			},
+		},
+		{
+			name:    "disputed charge",
+			payload: stripeWebhook("evt_dispute", "charge.dispute.created", `{"id":"dp_1","amount":500,"reason":"fraudulent","payment_intent":"pi_1"}`),
+			wantOK:  true,
+			wantEvent: PaymentEvent{
+				ID: "evt_dispute", Kind: PaymentDisputed, PaymentIntent: "pi_1", Amount: 500, Reason: "fraudulent",
+			},
+		},
+		{
+			name:    "checkout still awaiting a bank transfer",
+			payload: stripeWebhook("evt_async", "checkout.session.completed", `{"id":"cs_2","amount_total":500,"payment_status":"unpaid"}`),
+		},
+		{
+			name:    "unrelated event type",
+			payload: stripeWebhook("evt_customer", "customer.created", `{"id":"cus_1"}`),
+		},
+		{
+			name:      "signed with another secret",
+			payload:   paid,
+			signature: func(payload []byte) string { return StripeSignature(payload, "whsec_other", time.Now()) },
+			wantErr:   ErrWebhookSignature,
+		},
+		{
+			name:    "replayed after the tolerance",
+			payload: paid,
+			signature: func(payload []byte) string {
+				return StripeSignature(payload, testWebhookSecret, time.Now().Add(-time.Hour))
+			},
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:      "missing signature",
+			payload:   paid,
+			signature: func(payload []byte) string { return "" },
+			wantErr:   ErrWebhookSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature := StripeSignature(tt.payload, testWebhookSecret, time.Now())
+			if tt.signature != nil {
+				signature = tt.signature(tt.payload)
+			}
+			evt, ok, err := ParseStripeWebhook(tt.payload, signature, testWebhookSecret, time.Now())
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				evt.Created = time.Time{}
+				assert.Equal(t, tt.wantEvent, evt)
+			}
+		})
+	}
+}
+
+func TestRideChain_ApplyPaymentEvent(t *testing.T) {
+	driver, rider := "genesis-123", "rider-webhook"
+	rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(driver))
+	onboardTestDriver(t, rc, driver)
+
+	tx := testRideTx(driver, rider)
+	tx.RideTxEvts = []RideTxEvt{{EventType: RideRequested}, {EventType: DriverAccepted}}
+	_, err = rc.SubmitPendingRideTx(tx)
+	assert.NotNil(t, err, "no payment received yet")
+
+	paid := PaymentEvent{ID: "evt_paid", Kind: PaymentPaid, SessionID: tx.StripeSessionId, PaymentIntent: "pi_webhook", Amount: 500}
+	applied, err := rc.ApplyPaymentEvent(paid)
+	assert.Nil(t, err)
+	assert.True(t, applied)
+	applied, err = rc.ApplyPaymentEvent(paid)
+	assert.Nil(t, err)
+	assert.False(t, applied, "stripe retried the callback")
+
+	// the paid callback arrived before the ride, submission picks it up
+	pending, err := rc.SubmitPendingRideTx(tx)
+	assert.Nil(t, err)
+	assert.Equal(t, RiderPaymentRecieved, pending.RideTxEvts[len(pending.RideTxEvts)-1].EventType)
+
+	disputed := PaymentEvent{ID: "evt_dispute", Kind: PaymentDisputed, PaymentIntent: "pi_webhook", Amount: 500, Reason: "fraudulent"}
+	applied, err = rc.ApplyPaymentEvent(disputed)
+	assert.Nil(t, err)
+	assert.True(t, applied)
+	events := rc.PendingRideTxs[driver].RideTxEvts
+	assert.Equal(t, RiderPaymentDisputed, events[len(events)-1].EventType)
+
+	assert.Nil(t, rc.SubmitPickupProof(pending, pending.PickupCode, pending.PickupLocation))
+	driveTestRide(t, rc, pending, NewLatLng(36.1584, -86.7760))
+	txID, err := rc.ApproveRideTx(pending, driver)
+	assert.Nil(t, err)
+
+	refunded := PaymentEvent{ID: "evt_refund", Kind: PaymentRefunded, PaymentIntent: "pi_webhook", Amount: 200}
+	applied, err = rc.ApplyPaymentEvent(refunded)
+	assert.Nil(t, err)
+	assert.True(t, applied)
+	_, err = rc.ApplyPaymentEvent(PaymentEvent{ID: "evt_other", Kind: PaymentRefunded, PaymentIntent: "pi_unknown", Amount: 200})
+	assert.True(t, errors.Is(err, ErrUnknownPayment), "got %v", err)
+
+	assert.Nil(t, rc.Save())
+	reopened, err := OpenRideChain(filepath.Dir(rc.TokenLedger.filename))
+	assert.Nil(t, err)
+	history, err := reopened.RideHistory(txID)
+	assert.Nil(t, err)
+	assert.Len(t, history.Payments, 3)
+	assert.Equal(t, PaymentRefunded, history.Payments[2].Kind)
+	applied, err = reopened.ApplyPaymentEvent(refunded)
+	assert.Nil(t, err)
+	assert.False(t, applied)
+}
+
+func TestRideChain_SubmitPendingRideTx_PaymentEvents(t *testing.T) {
+	driver, rider := "genesis-123", "rider-webhook"
+	tests := []struct {
+		name        string
+		fromWebhook bool
+		events      []PaymentEvent
+		wantErr     bool
+		wantErrIs   error
+	}{
+		{
+			name: "node without a webhook trusts the caller's paid event",
+		},
+		{
+			name:        "caller's paid event is ignored when the webhook vouches for payments",
+			fromWebhook: true,
+			wantErr:     true,
+		},
+		{
+			name:        "webhook paid the fare",
+			fromWebhook: true,
+			events:      []PaymentEvent{{ID: "evt_paid", Kind: PaymentPaid, PaymentIntent: "pi_webhook", Amount: 500}},
+		},
+		{
+			name:        "webhook paid less than the ride says",
+			fromWebhook: true,
+			events:      []PaymentEvent{{ID: "evt_paid", Kind: PaymentPaid, PaymentIntent: "pi_webhook", Amount: 100}},
+			wantErr:     true,
+			wantErrIs:   ErrPaymentNotVerified,
+		},
+		{
+			name: "payment was refunded before the ride was submitted",
+			events: []PaymentEvent{
+				{ID: "evt_paid", Kind: PaymentPaid, PaymentIntent: "pi_webhook", Amount: 500},
+				{ID: "evt_refund", Kind: PaymentRefunded, PaymentIntent: "pi_webhook", Amount: 500},
+			},
+			wantErr:   true,
+			wantErrIs: ErrPaymentNotVerified,
+		},
+		{
+			name:        "payment is disputed",
+			fromWebhook: true,
+			events: []PaymentEvent{
+				{ID: "evt_paid", Kind: PaymentPaid, PaymentIntent: "pi_webhook", Amount: 500},
+				{ID: "evt_dispute", Kind: PaymentDisputed, PaymentIntent: "pi_webhook", Amount: 500, Reason: "fraudulent"},
+			},
+			wantErr:   true,
+			wantErrIs: ErrPaymentNotVerified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+			assert.Nil(t, err)
+			assert.Nil(t, rc.BecomeValidator(driver))
+			onboardTestDriver(t, rc, driver)
+			rc.PaymentEventsFromWebhook = tt.fromWebhook
+
+			tx := testRideTx(driver, rider)
+			for _, evt := range tt.events {
+				evt.SessionID = tx.StripeSessionId
+				_, err := rc.ApplyPaymentEvent(evt)
+				assert.Nil(t, err)
+			}
+			_, err = rc.SubmitPendingRideTx(tx)
+			if !tt.wantErr {
+				assert.Nil(t, err)
+				return
+			}
+			assert.NotNil(t, err)
+			if tt.wantErrIs != nil {
+				assert.True(t, errors.Is(err, tt.wantErrIs), "got %v", err)
+			}
+			_, pending := rc.PendingRideTxs[driver]
+			assert.False(t, pending)
+		})
+	}
+}
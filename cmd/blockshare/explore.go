@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+	"github.com/x-MrPhillips-x/blockshare/explorer"
+)
+
+const exploreUsage = `usage: blockshare explore <view> --data-dir <dir> [flags]
+
+views:
+  blocks     list blocks by height (--from, --to)
+  block      show a block's header, validators and rides (--ref height or hash)
+  rides      search rides (--rider, --driver, --plate)
+  validator  show a validator's approval history (--uuid)`
+
+// explore browses the block store of a data directory
+func explore(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(exploreUsage)
+	}
+	view, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("explore "+view, flag.ContinueOnError)
+	fs.SetOutput(out)
+	dataDir := fs.String("data-dir", "", "local chain data directory")
+	from := fs.Int("from", 0, "first block height")
+	to := fs.Int("to", -1, "last block height, defaults to the head")
+	ref := fs.String("ref", "", "block height or hash")
+	rider := fs.String("rider", "", "rider uuid")
+	driver := fs.String("driver", "", "driver uuid")
+	plate := fs.String("plate", "", "vehicle plate")
+	uuid := fs.String("uuid", "", "validator uuid")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dataDir == "" {
+		return errors.New("--data-dir is required")
+	}
+
+	rc, err := blockchain.OpenRideChain(*dataDir)
+	if err != nil {
+		return err
+	}
+	ex := explorer.New(rc)
+
+	switch view {
+	case "blocks":
+		return explorer.WriteBlocks(out, ex.Blocks(*from, *to))
+	case "block":
+		if *ref == "" {
+			return errors.New("--ref is required")
+		}
+		b, err := ex.Block(*ref)
+		if err != nil {
+			return err
+		}
+		return explorer.WriteBlock(out, b)
+	case "rides":
+		matches, err := ex.SearchRides(explorer.RideFilter{Rider: *rider, Driver: *driver, Plate: *plate})
+		if err != nil {
+			return err
+		}
+		return explorer.WriteRides(out, matches)
+	case "validator":
+		if *uuid == "" {
+			return errors.New("--uuid is required")
+		}
+		history, err := ex.ValidatorHistory(*uuid)
+		if err != nil {
+			return err
+		}
+		return explorer.WriteValidatorHistory(out, *uuid, history)
+	}
+	return fmt.Errorf("unknown view %q\n%s", view, exploreUsage)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+// keyFile is the wallet key written by keygen
+type keyFile struct {
+	UUID       string `json:"uuid"`
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+}
+
+func newKeyFile(uuid string) (*keyFile, error) {
+	pub, key, err := blockchain.GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return &keyFile{
+		UUID:       uuid,
+		PublicKey:  hex.EncodeToString(pub),
+		PrivateKey: hex.EncodeToString(key),
+	}, nil
+}
+
+func loadKeyFile(path string) (*keyFile, error) {
+	if path == "" {
+		return nil, fmt.Errorf("--key is required")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var k keyFile
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("read key file %s: %w", path, err)
+	}
+	if _, err := k.privateKey(); err != nil {
+		return nil, fmt.Errorf("read key file %s: %w", path, err)
+	}
+	return &k, nil
+}
+
+func (k *keyFile) save(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (k *keyFile) privateKey() (ed25519.PrivateKey, error) {
+	key, err := hex.DecodeString(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(key))
+	}
+	return ed25519.PrivateKey(key), nil
+}
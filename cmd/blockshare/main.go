@@ -0,0 +1,431 @@
+// Command blockshare is the wallet for drivers and validators
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/x-MrPhillips-x/blockshare/api"
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+const usage = `usage: blockshare <command> [flags]
+
+commands:
+  keygen            generate a key file for a driver or validator
+  register          register a key file's public key with the chain
+  bind-key          register the key of an account that already holds tokens as a validator
+  balance           show balance, stake and delegations
+  stake             stake tokens
+  unstake           unstake tokens
+  delegate          delegate tokens to a validator
+  undelegate        take back tokens delegated to a validator
+  become-validator  become a validator
+  request-verify    ask validators to verify you as a driver
+  verify            attest to a driver's verification as a validator
+  reject            reject a driver's verification as a validator
+  register-vehicle  register a vehicle by VIN and plate
+  confirm-vehicle   confirm a driver's vehicle registration as a validator
+  insure-vehicle    confirm a vehicle's insurance policy as a validator
+  refund            propose a full or partial refund of a committed ride
+  approve-refund    approve a proposed refund as a validator
+  reject-refund     withdraw your proposed refund, or reject one as a validator
+  issue-refund      retry sending an authorized refund to the payment provider
+  settle            settle every driver's earnings for a period as a validator
+  payout            write a settled period's payout batch as CSV
+  ride              inspect a ride by TxID
+  serve             serve a node API over a data directory
+  explore           browse blocks, rides and validators in a data directory
+
+wallet commands talk to a node with --node or operate on a local --data-dir`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "blockshare:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	cmd, args := args[0], args[1:]
+	if cmd == "explore" {
+		return explore(args, out)
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(out)
+	node := fs.String("node", "", "node API url, e.g. http://localhost:8080")
+	dataDir := fs.String("data-dir", "", "local chain data directory")
+	keyPath := fs.String("key", "", "key file created by keygen")
+	uuid := fs.String("uuid", "", "driver, rider or validator uuid")
+	amount := fs.Int("amount", 0, "token amount")
+	to := fs.String("to", "", "validator to delegate to or undelegate from")
+	publicKey := fs.String("public-key", "", "hex encoded public key bind-key registers for --uuid")
+	driver := fs.String("driver", "", "driver being verified")
+	results := fs.String("results", "", "verification results or rejection reason")
+	txID := fs.String("tx", "", "ride TxID")
+	vin := fs.String("vin", "", "vehicle identification number")
+	var vehicle blockchain.Vehicle
+	fs.StringVar(&vehicle.Plate, "plate", "", "vehicle license plate")
+	fs.IntVar(&vehicle.Seats, "seats", 4, "passenger seats in the vehicle")
+	fs.StringVar(&vehicle.Brand, "brand", "", "vehicle make")
+	fs.StringVar(&vehicle.Model, "model", "", "vehicle model")
+	fs.StringVar(&vehicle.Year, "year", "", "vehicle model year")
+	fs.StringVar(&vehicle.Color, "color", "", "vehicle color")
+	vehicleID := fs.String("vehicle", "", "registered vehicle ID")
+	policy := fs.String("policy", "", "insurance policy number")
+	insuredFor := fs.Duration("insured-for", 180*24*time.Hour, "how long the confirmed insurance policy runs")
+	refundID := fs.String("refund", "", "refund ID")
+	reason := fs.String("reason", "", "why the ride is refunded")
+	periodStart := fs.String("period-start", "", "start of the settlement period, YYYY-MM-DD or RFC 3339")
+	outPath := fs.String("out", "", "where keygen writes the key file or payout writes the batch, payout defaults to stdout")
+	listen := fs.String("listen", ":8080", "address serve listens on")
+	sweep := fs.Duration("sweep", time.Minute, "how often serve expires stalled rides")
+	verificationURL := fs.String("verification-url", "", "verification service serve asks for background, insurance, license and registration checks")
+	verificationName := fs.String("verification-name", "verification", "name the --verification-url service's results are recorded under")
+	stripeURL := fs.String("stripe-url", "", "Stripe API serve checks ride payments against, defaults to https://api.stripe.com when --stripe-key is set")
+	stripeKey := fs.String("stripe-key", os.Getenv("STRIPE_SECRET_KEY"), "Stripe secret key, defaults to $STRIPE_SECRET_KEY")
+	webhookSecret := fs.String("stripe-webhook-secret", os.Getenv("STRIPE_WEBHOOK_SECRET"), "signing secret for Stripe payment callbacks, defaults to $STRIPE_WEBHOOK_SECRET")
+	requireVerified := fs.Bool("require-verified-validators", false, "reject blocks from validators without an approved verification")
+	verification := blockchain.DefaultVerificationPolicy()
+	fs.IntVar(&verification.Attestations, "attestations", verification.Attestations, "validators that must agree to approve or reject a driver")
+	fs.DurationVar(&verification.ValidFor, "verification-valid-for", verification.ValidFor, "how long a driver verification lasts before re-verifying")
+	var eligibility blockchain.EligibilityPolicy
+	fs.IntVar(&eligibility.MinRidesServed, "min-rides-served", 0, "rides a driver must serve before becoming a validator")
+	fs.IntVar(&eligibility.MinRidesTaken, "min-rides-taken", 0, "rides a driver must take as a passenger before becoming a validator")
+	fs.Float64Var(&eligibility.MinRating, "min-rating", 0, "average rating a driver needs to become a validator")
+	fs.Float64Var(&eligibility.MinTrustScore, "min-trust-score", 0, "trust score between 0 and 1 a driver needs to become a validator")
+	fs.DurationVar(&eligibility.KYCMaxAge, "kyc-max-age", 0, "how recent a validator's approved KYC must be, e.g. 720h")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// eligibility flags change the policy saved with the chain, flags left out keep their saved value
+	eligibilitySet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "min-rides-served", "min-rides-taken", "min-rating", "min-trust-score", "kyc-max-age":
+			eligibilitySet[f.Name] = true
+		}
+	})
+
+	switch cmd {
+	case "keygen":
+		return keygen(out, *uuid, *outPath)
+	case "serve":
+		return serve(out, *dataDir, *keyPath, *listen, *sweep, *verificationURL, *verificationName, *stripeURL, *stripeKey, *webhookSecret, *requireVerified, verification, eligibility, eligibilitySet)
+	}
+
+	if len(eligibilitySet) > 0 && *node != "" {
+		return errors.New("eligibility flags configure a data directory, use --data-dir or serve")
+	}
+	w, err := openWallet(*node, *dataDir, eligibility, eligibilitySet)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "balance":
+		if *uuid == "" && *keyPath != "" {
+			key, err := loadKeyFile(*keyPath)
+			if err != nil {
+				return err
+			}
+			*uuid = key.UUID
+		}
+		if *uuid == "" {
+			return errors.New("--uuid or --key is required")
+		}
+		account, err := w.Account(*uuid)
+		if err != nil {
+			return err
+		}
+		printAccount(out, account)
+		return nil
+	case "ride":
+		if *txID == "" {
+			return errors.New("--tx is required")
+		}
+		tx, err := w.RideTx(*txID)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tx)
+	case "payout":
+		start, err := parsePeriodStart(*periodStart)
+		if err != nil {
+			return err
+		}
+		batch, err := w.PayoutBatch(blockchain.PayoutBatchID(start))
+		if err != nil {
+			return err
+		}
+		return writePayoutBatch(out, batch, *outPath)
+	}
+
+	key, err := loadKeyFile(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	var action string
+	var payload interface{}
+	switch cmd {
+	case "register":
+		action, payload = api.ActionRegisterKey, api.RegisterKeyPayload{PublicKey: key.PublicKey}
+	case "stake":
+		action, payload = api.ActionStake, api.AmountPayload{Amount: *amount}
+	case "unstake":
+		action, payload = api.ActionUnstake, api.AmountPayload{Amount: *amount}
+	case "delegate", "undelegate":
+		if *to == "" {
+			return errors.New("--to is required")
+		}
+		action, payload = api.ActionDelegate, api.DelegatePayload{Validator: *to, Amount: *amount}
+		if cmd == "undelegate" {
+			action = api.ActionUndelegate
+		}
+	case "bind-key":
+		if *uuid == "" || *publicKey == "" {
+			return errors.New("--uuid and --public-key are required")
+		}
+		action, payload = api.ActionBindKey, api.BindKeyPayload{UUID: *uuid, PublicKey: *publicKey}
+	case "become-validator":
+		action, payload = api.ActionBecomeValidator, struct{}{}
+	case "request-verify":
+		signing, err := key.privateKey()
+		if err != nil {
+			return err
+		}
+		at := time.Now().UTC()
+		signature := ed25519.Sign(signing, blockchain.VerificationRequestSigningBytes(key.UUID, key.UUID, at))
+		action, payload = api.ActionRequestVerify, api.RequestVerificationPayload{DriverUUID: key.UUID, RequestedAt: at, Signature: signature}
+	case "verify":
+		if *driver == "" {
+			return errors.New("--driver is required")
+		}
+		action, payload = api.ActionVerifyDriver, api.VerifyDriverPayload{DriverUUID: *driver, Results: *results}
+	case "reject":
+		if *driver == "" {
+			return errors.New("--driver is required")
+		}
+		action, payload = api.ActionRejectDriver, api.VerifyDriverPayload{DriverUUID: *driver, Results: *results}
+	case "register-vehicle":
+		if *vin == "" || vehicle.Plate == "" {
+			return errors.New("--vin and --plate are required")
+		}
+		action, payload = api.ActionRegisterVehicle, api.RegisterVehiclePayload{VIN: *vin, Vehicle: vehicle}
+	case "confirm-vehicle":
+		if *vehicleID == "" || *driver == "" {
+			return errors.New("--vehicle and --driver are required")
+		}
+		action, payload = api.ActionConfirmVehicle, api.ConfirmVehiclePayload{VehicleID: *vehicleID, DriverUUID: *driver}
+	case "insure-vehicle":
+		if *vehicleID == "" || *policy == "" {
+			return errors.New("--vehicle and --policy are required")
+		}
+		action, payload = api.ActionInsureVehicle, api.InsureVehiclePayload{VehicleID: *vehicleID, Policy: *policy, Until: time.Now().Add(*insuredFor)}
+	case "refund":
+		if *txID == "" {
+			return errors.New("--tx is required")
+		}
+		action, payload = api.ActionProposeRefund, api.ProposeRefundPayload{TxID: *txID, Amount: *amount, Reason: *reason}
+	case "approve-refund", "reject-refund", "issue-refund":
+		if *refundID == "" {
+			return errors.New("--refund is required")
+		}
+		action, payload = api.ActionApproveRefund, api.RefundPayload{RefundID: *refundID}
+		switch cmd {
+		case "reject-refund":
+			action = api.ActionRejectRefund
+		case "issue-refund":
+			action = api.ActionIssueRefund
+		}
+	case "settle":
+		start, err := parsePeriodStart(*periodStart)
+		if err != nil {
+			return err
+		}
+		action, payload = api.ActionSettle, api.SettlePayload{PeriodStart: start}
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, usage)
+	}
+
+	account, err := w.Do(key, action, payload)
+	if err != nil {
+		return err
+	}
+	printAccount(out, account)
+	return nil
+}
+
+func openWallet(node, dataDir string, eligibility blockchain.EligibilityPolicy, eligibilitySet map[string]bool) (wallet, error) {
+	switch {
+	case node != "" && dataDir != "":
+		return nil, errors.New("use either --node or --data-dir, not both")
+	case node != "":
+		return &remoteWallet{client: api.NewClient(node)}, nil
+	case dataDir != "":
+		w, err := openLocalWallet(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		return w, applyEligibility(w.rc, eligibility, eligibilitySet)
+	}
+	return nil, errors.New("--node or --data-dir is required")
+}
+
+// parsePeriodStart accepts a UTC date or an RFC 3339 time
+func parsePeriodStart(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("--period-start is required")
+	}
+	if start, err := time.Parse(time.DateOnly, value); err == nil {
+		return start, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// writePayoutBatch writes the batch's CSV to path, or out when path is empty
+func writePayoutBatch(out io.Writer, batch *blockchain.PayoutBatch, path string) error {
+	if path == "" {
+		return batch.WriteCSV(out)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := batch.WriteCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "wrote %s paying %d %s\n", path, batch.Total, batch.Currency)
+	return nil
+}
+
+// applyEligibility copies the eligibility flags that were set over the chain's policy and saves it
+func applyEligibility(rc *blockchain.RideChain, eligibility blockchain.EligibilityPolicy, set map[string]bool) error {
+	if len(set) == 0 {
+		return nil
+	}
+	if set["min-rides-served"] {
+		rc.Eligibility.MinRidesServed = eligibility.MinRidesServed
+	}
+	if set["min-rides-taken"] {
+		rc.Eligibility.MinRidesTaken = eligibility.MinRidesTaken
+	}
+	if set["min-rating"] {
+		rc.Eligibility.MinRating = eligibility.MinRating
+	}
+	if set["min-trust-score"] {
+		rc.Eligibility.MinTrustScore = eligibility.MinTrustScore
+	}
+	if set["kyc-max-age"] {
+		rc.Eligibility.KYCMaxAge = eligibility.KYCMaxAge
+	}
+	return rc.Save()
+}
+
+func keygen(out io.Writer, uuid, path string) error {
+	if uuid == "" {
+		return errors.New("--uuid is required")
+	}
+	if path == "" {
+		path = uuid + ".key.json"
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("key file %s already exists", path)
+	}
+	key, err := newKeyFile(uuid)
+	if err != nil {
+		return err
+	}
+	if err := key.save(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "wrote %s\npublic key %s\n", path, key.PublicKey)
+	return nil
+}
+
+func serve(out io.Writer, dataDir, keyPath, listen string, sweep time.Duration, verificationURL, verificationName, stripeURL, stripeKey, webhookSecret string, requireVerified bool, verification blockchain.VerificationPolicy, eligibility blockchain.EligibilityPolicy, eligibilitySet map[string]bool) error {
+	if dataDir == "" {
+		return errors.New("--data-dir is required")
+	}
+	rc, err := blockchain.OpenRideChain(dataDir)
+	if err != nil {
+		return err
+	}
+	// a validator's key makes the node sign its blocks and approve the rides its sweeper expires
+	if keyPath != "" {
+		key, err := loadKeyFile(keyPath)
+		if err != nil {
+			return err
+		}
+		priv, err := key.privateKey()
+		if err != nil {
+			return err
+		}
+		if err := rc.SetSigner(key.UUID, priv); err != nil {
+			return err
+		}
+	}
+	if err := applyEligibility(rc, eligibility, eligibilitySet); err != nil {
+		return err
+	}
+	rc.RequireVerifiedValidators = requireVerified
+	rc.VerificationPolicy = verification
+	if verificationURL != "" {
+		for _, check := range []blockchain.VerificationCheck{
+			blockchain.CheckBackground,
+			blockchain.CheckInsurance,
+			blockchain.CheckLicense,
+			blockchain.CheckRegistration,
+		} {
+			rc.VerificationProviders = append(rc.VerificationProviders, blockchain.NewHTTPVerificationProvider(verificationName, check, verificationURL))
+		}
+	}
+	if stripeKey != "" {
+		stripe := blockchain.NewStripePaymentVerifier(stripeURL, stripeKey)
+		rc.PaymentVerifier = stripe
+		rc.PaymentRefunder = stripe
+	}
+	rc.PaymentEventsFromWebhook = webhookSecret != ""
+	server := api.NewServer(rc)
+	server.WebhookSecret = webhookSecret
+	go server.Sweep(context.Background(), sweep)
+	fmt.Fprintf(out, "serving %s on %s\n", dataDir, listen)
+	return http.ListenAndServe(listen, server)
+}
+
+func printAccount(out io.Writer, account blockchain.Account) {
+	fmt.Fprintf(out, "account    %s\n", account.UUID)
+	fmt.Fprintf(out, "balance    %d\n", account.Balance)
+	fmt.Fprintf(out, "stake      %d\n", account.Stake)
+	fmt.Fprintf(out, "delegated  %d\n", account.Delegated)
+	fmt.Fprintf(out, "validator  %t\n", account.Validator)
+
+	validators := make([]string, 0, len(account.Delegations))
+	for v := range account.Delegations {
+		validators = append(validators, v)
+	}
+	sort.Strings(validators)
+	for _, v := range validators {
+		fmt.Fprintf(out, "delegation %s %d\n", v, account.Delegations[v])
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/x-MrPhillips-x/blockshare/api"
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+func TestRun_Wallet(t *testing.T) {
+	tests := []struct {
+		name   string
+		target func(t *testing.T, dataDir string) []string
+	}{
+		{
+			name: "operating on a local data directory",
+			target: func(t *testing.T, dataDir string) []string {
+				return []string{"--data-dir", dataDir}
+			},
+		},
+		{
+			name: "talking to a node api",
+			target: func(t *testing.T, dataDir string) []string {
+				rc, err := blockchain.OpenRideChain(dataDir)
+				assert.Nil(t, err)
+				server := httptest.NewServer(api.NewServer(rc))
+				t.Cleanup(server.Close)
+				return []string{"--node", server.URL}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dataDir := filepath.Join(dir, "data")
+			genesisKey := filepath.Join(dir, "genesis.key.json")
+			driverKey := filepath.Join(dir, "driver.key.json")
+
+			// fund the driver before the node starts
+			rc, err := blockchain.OpenRideChain(dataDir)
+			assert.Nil(t, err)
+			rc.TokenLedger.Mint("driver-123", 30)
+			assert.Nil(t, rc.Save())
+
+			target := tt.target(t, dataDir)
+			cli := func(args ...string) (string, error) {
+				var out bytes.Buffer
+				err := run(append(args, target...), &out)
+				return out.String(), err
+			}
+
+			var out bytes.Buffer
+			assert.Nil(t, run([]string{"keygen", "--uuid", "genesis-123", "--out", genesisKey}, &out))
+			assert.Nil(t, run([]string{"keygen", "--uuid", "driver-123", "--out", driverKey}, &out))
+			assert.NotNil(t, run([]string{"keygen", "--uuid", "driver-123", "--out", driverKey}, &out), "keygen never overwrites a key")
+
+			_, err = cli("register", "--key", genesisKey)
+			assert.Nil(t, err)
+			_, err = cli("become-validator", "--key", genesisKey)
+			assert.Nil(t, err)
+
+			// the driver already holds tokens, so a validator vouches for their key
+			driver, err := loadKeyFile(driverKey)
+			assert.Nil(t, err)
+			_, err = cli("bind-key", "--key", genesisKey, "--uuid", "driver-123", "--public-key", driver.PublicKey)
+			assert.Nil(t, err)
+
+			_, err = cli("become-validator", "--key", driverKey)
+			assert.NotNil(t, err, "driver has not staked yet")
+
+			_, err = cli("stake", "--key", driverKey, "--amount", "15")
+			assert.Nil(t, err)
+			_, err = cli("become-validator", "--key", driverKey)
+			assert.Nil(t, err)
+			_, err = cli("delegate", "--key", driverKey, "--to", "genesis-123", "--amount", "5")
+			assert.Nil(t, err)
+			_, err = cli("undelegate", "--key", driverKey, "--to", "genesis-123", "--amount", "2")
+			assert.Nil(t, err)
+			_, err = cli("undelegate", "--key", driverKey, "--to", "genesis-123", "--amount", "4")
+			assert.NotNil(t, err, "only 3 tokens are still delegated")
+			_, err = cli("unstake", "--key", driverKey, "--amount", "3")
+			assert.Nil(t, err)
+
+			_, err = cli("verify", "--key", genesisKey, "--driver", "driver-123", "--results", "license checked")
+			assert.NotNil(t, err, "the driver has not asked to be verified")
+			_, err = cli("request-verify", "--key", driverKey)
+			assert.Nil(t, err)
+			_, err = cli("verify", "--key", genesisKey, "--driver", "driver-123", "--results", "license checked")
+			assert.Nil(t, err)
+
+			_, err = cli("register-vehicle", "--key", driverKey, "--vin", "1HGCM82633A004352", "--plate", "TN-123", "--seats", "3")
+			assert.Nil(t, err)
+			vehicleID := blockchain.VehicleID("1HGCM82633A004352")
+			_, err = cli("insure-vehicle", "--key", genesisKey, "--vehicle", vehicleID, "--policy", "policy-1")
+			assert.NotNil(t, err, "the registration is not confirmed yet")
+			_, err = cli("confirm-vehicle", "--key", genesisKey, "--vehicle", vehicleID, "--driver", "driver-123")
+			assert.Nil(t, err)
+			_, err = cli("insure-vehicle", "--key", driverKey, "--vehicle", vehicleID, "--policy", "policy-1")
+			assert.NotNil(t, err, "a validator cannot confirm their own vehicle's insurance")
+			_, err = cli("insure-vehicle", "--key", genesisKey, "--vehicle", vehicleID, "--policy", "policy-1")
+			assert.Nil(t, err)
+
+			_, err = cli("payout", "--period-start", "2026-10-05")
+			assert.NotNil(t, err, "the period has not been settled")
+			_, err = cli("settle", "--key", genesisKey, "--period-start", "2026-10-05")
+			assert.Nil(t, err)
+			batchPath := filepath.Join(dir, "payout.csv")
+			_, err = cli("payout", "--period-start", "2026-10-05", "--out", batchPath)
+			assert.Nil(t, err)
+			batch, err := os.ReadFile(batchPath)
+			assert.Nil(t, err)
+			assert.True(t, strings.HasPrefix(string(batch), "batch_id,settlement_id,driver_uuid,"))
+
+			balance, err := cli("balance", "--uuid", "driver-123")
+			assert.Nil(t, err)
+			assert.Contains(t, balance, "balance    15\n")
+			assert.Contains(t, balance, "stake      12\n")
+			assert.Contains(t, balance, "validator  true\n")
+			assert.Contains(t, balance, "delegation genesis-123 3\n")
+
+			balance, err = cli("balance", "--key", genesisKey)
+			assert.Nil(t, err)
+			assert.Contains(t, balance, "delegated  3\n")
+
+			_, err = cli("ride", "--tx", "missing")
+			assert.NotNil(t, err)
+
+			rc, err = blockchain.OpenRideChain(dataDir)
+			assert.Nil(t, err)
+			vehicle, err := rc.LookupVehicle("TN-123")
+			assert.Nil(t, err)
+			assert.Equal(t, 3, vehicle.Seats)
+			assert.True(t, vehicle.Insured(time.Now()))
+			assert.Equal(t, blockchain.VerificationApproved, rc.PendingVerifications["driver-123"].Status)
+		})
+	}
+}
+
+func TestRun_Eligibility(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+	driverKey := filepath.Join(dir, "driver.key.json")
+
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"keygen", "--uuid", "driver-123", "--out", driverKey}, &out))
+	assert.Nil(t, run([]string{"register", "--key", driverKey, "--data-dir", dataDir}, &out))
+	rc, err := blockchain.OpenRideChain(dataDir)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator("genesis-123"))
+	rc.TokenLedger.Mint("driver-123", 10)
+	assert.Nil(t, rc.StakeTokens(10, "driver-123"))
+	assert.Nil(t, rc.Save())
+
+	err = run([]string{"become-validator", "--key", driverKey, "--data-dir", dataDir, "--min-rides-served", "1", "--min-trust-score", "0.4"}, &out)
+	assert.True(t, errors.Is(err, blockchain.ErrNotEligible), "got %v", err)
+	err = run([]string{"become-validator", "--key", driverKey, "--data-dir", dataDir}, &out)
+	assert.True(t, errors.Is(err, blockchain.ErrNotEligible), "the policy is saved with the chain, got %v", err)
+
+	rc, err = blockchain.OpenRideChain(dataDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rc.Eligibility.MinRidesServed)
+	assert.Equal(t, 0.4, rc.Eligibility.MinTrustScore)
+
+	assert.NotNil(t, run([]string{"become-validator", "--key", driverKey, "--node", "http://localhost:0", "--min-rides-served", "0"}, &out),
+		"a node's policy is not set by its clients")
+	assert.Nil(t, run([]string{"become-validator", "--key", driverKey, "--data-dir", dataDir, "--min-rides-served", "0"}, &out),
+		"the saved trust score is met by the prior")
+}
+
+func TestRun_Usage(t *testing.T) {
+	var out bytes.Buffer
+	assert.NotNil(t, run(nil, &out))
+	assert.NotNil(t, run([]string{"balance", "--uuid", "driver-123"}, &out), "needs --node or --data-dir")
+	assert.NotNil(t, run([]string{"fly", "--data-dir", t.TempDir(), "--key", "missing.json"}, &out))
+}
+
+func TestRun_Explore(t *testing.T) {
+	dataDir := t.TempDir()
+	rc, err := blockchain.OpenRideChain(dataDir)
+	assert.Nil(t, err)
+	assert.Nil(t, rc.Save())
+
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"explore", "blocks", "--data-dir", dataDir}, &out))
+	assert.Contains(t, out.String(), "HEIGHT")
+	assert.Contains(t, out.String(), rc.Chain.Genesis[:12])
+
+	out.Reset()
+	assert.Nil(t, run([]string{"explore", "block", "--ref", "0", "--data-dir", dataDir}, &out))
+	assert.Contains(t, out.String(), rc.Chain.Genesis)
+
+	assert.NotNil(t, run([]string{"explore", "validator", "--data-dir", dataDir}, &out), "--uuid is required")
+	assert.NotNil(t, run([]string{"explore", "mempool", "--data-dir", dataDir}, &out))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/x-MrPhillips-x/blockshare/api"
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+// wallet is where commands send their actions, a node's API or a local data directory
+type wallet interface {
+	Account(uuid string) (blockchain.Account, error)
+	RideTx(txID string) (blockchain.RideTx, error)
+	PayoutBatch(batchID string) (*blockchain.PayoutBatch, error)
+	Do(key *keyFile, action string, payload interface{}) (blockchain.Account, error)
+}
+
+// remoteWallet signs every action and sends it to a node
+type remoteWallet struct {
+	client *api.Client
+}
+
+func (w *remoteWallet) Account(uuid string) (blockchain.Account, error) {
+	return w.client.Account(uuid)
+}
+
+func (w *remoteWallet) RideTx(txID string) (blockchain.RideTx, error) {
+	return w.client.RideTx(txID)
+}
+
+func (w *remoteWallet) PayoutBatch(batchID string) (*blockchain.PayoutBatch, error) {
+	return w.client.PayoutBatch(batchID)
+}
+
+func (w *remoteWallet) Do(key *keyFile, action string, payload interface{}) (blockchain.Account, error) {
+	priv, err := key.privateKey()
+	if err != nil {
+		return blockchain.Account{}, err
+	}
+	account, err := w.client.Account(key.UUID)
+	if err != nil {
+		return blockchain.Account{}, err
+	}
+	req, err := api.NewSignedRequest(key.UUID, action, payload, account.Nonce+1, priv)
+	if err != nil {
+		return blockchain.Account{}, err
+	}
+	return w.client.Submit(req)
+}
+
+// localWallet applies actions straight to a data directory, the operator owns the node
+type localWallet struct {
+	rc *blockchain.RideChain
+}
+
+func openLocalWallet(dataDir string) (*localWallet, error) {
+	rc, err := blockchain.OpenRideChain(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return &localWallet{rc: rc}, nil
+}
+
+func (w *localWallet) Account(uuid string) (blockchain.Account, error) {
+	return w.rc.Account(uuid), nil
+}
+
+func (w *localWallet) RideTx(txID string) (blockchain.RideTx, error) {
+	return w.rc.RideTx(txID)
+}
+
+func (w *localWallet) PayoutBatch(batchID string) (*blockchain.PayoutBatch, error) {
+	return w.rc.PayoutBatch(batchID)
+}
+
+func (w *localWallet) Do(key *keyFile, action string, payload interface{}) (blockchain.Account, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return blockchain.Account{}, err
+	}
+	if err := api.RunWalletAction(w.rc, key.UUID, action, data); err != nil {
+		return blockchain.Account{}, err
+	}
+	if err := w.rc.Save(); err != nil {
+		return blockchain.Account{}, err
+	}
+	return w.rc.Account(key.UUID), nil
+}
@@ -0,0 +1,126 @@
+// Package explorer answers read-only questions about the ride chain for operators
+package explorer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+// Explorer reads the canonical chain of a RideChain's block store
+type Explorer struct {
+	rc *blockchain.RideChain
+}
+
+func New(rc *blockchain.RideChain) *Explorer {
+	return &Explorer{rc: rc}
+}
+
+// Blocks returns the canonical blocks with from <= height <= to
+// a negative to means up to the head
+func (e *Explorer) Blocks(from, to int) []*blockchain.Block {
+	var blocks []*blockchain.Block
+	for _, b := range e.rc.Chain.CanonicalChain() {
+		if b.Height < from || (to >= 0 && b.Height > to) {
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks
+}
+
+// Block finds a block by height on the canonical chain or by hash on any fork
+func (e *Explorer) Block(ref string) (*blockchain.Block, error) {
+	if height, err := strconv.Atoi(ref); err == nil {
+		blocks := e.Blocks(height, height)
+		if len(blocks) == 0 {
+			return nil, fmt.Errorf("no block at height %d", height)
+		}
+		return blocks[0], nil
+	}
+	return e.rc.BlockByHash(ref)
+}
+
+// RideFilter matches rides by any combination of rider, driver and vehicle plate
+type RideFilter struct {
+	Rider  string
+	Driver string
+	Plate  string
+}
+
+func (f RideFilter) matches(tx blockchain.RideTx) bool {
+	if f.Rider != "" && tx.RiderUUID != f.Rider {
+		return false
+	}
+	if f.Driver != "" && tx.DriverUUID != f.Driver {
+		return false
+	}
+	if f.Plate != "" && !strings.EqualFold(tx.Vehicle.Plate, f.Plate) {
+		return false
+	}
+	return true
+}
+
+// RideMatch is a committed ride and the block that committed it
+type RideMatch struct {
+	Tx        blockchain.RideTx
+	BlockHash string
+	Height    int
+}
+
+// SearchRides decodes every canonical block and returns the rides matching f, oldest first
+func (e *Explorer) SearchRides(f RideFilter) ([]RideMatch, error) {
+	var matches []RideMatch
+	for _, b := range e.rc.Chain.CanonicalChain() {
+		txs, err := b.RideTxs()
+		if err != nil {
+			return nil, fmt.Errorf("decode block %s: %w", b.Hash, err)
+		}
+		for _, tx := range txs {
+			if f.matches(tx) {
+				matches = append(matches, RideMatch{Tx: tx, BlockHash: b.Hash, Height: b.Height})
+			}
+		}
+	}
+	return matches, nil
+}
+
+// Approval is one canonical block a validator approved or proposed
+type Approval struct {
+	Height    int
+	BlockHash string
+	Stake     int  // validator stake recorded in the block
+	Proposed  bool // validator produced the block
+	Approved  bool // validator is in the block's approving validators
+	Rides     int
+}
+
+// ValidatorHistory lists every canonical block a validator approved or proposed
+func (e *Explorer) ValidatorHistory(validatorUUID string) ([]Approval, error) {
+	var history []Approval
+	for _, b := range e.rc.Chain.CanonicalChain() {
+		approval := Approval{
+			Height:    b.Height,
+			BlockHash: b.Hash,
+			Proposed:  b.Proposer == validatorUUID,
+		}
+		for _, v := range b.Validators {
+			if v.UUID == validatorUUID {
+				approval.Approved = true
+				approval.Stake = v.Stake
+			}
+		}
+		if !approval.Proposed && !approval.Approved {
+			continue
+		}
+		txs, err := b.RideTxs()
+		if err != nil {
+			return nil, fmt.Errorf("decode block %s: %w", b.Hash, err)
+		}
+		approval.Rides = len(txs)
+		history = append(history, approval)
+	}
+	return history, nil
+}
@@ -0,0 +1,140 @@
+package explorer
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+const validator = "genesis-123"
+
+func commitRide(t *testing.T, rc *blockchain.RideChain, driver, rider, plate string) string {
+	t.Helper()
+	rc.PendingVerifications[driver] = blockchain.DriverVerificationRequest{
+		DriverUUID: driver,
+		Status:     blockchain.VerificationApproved,
+		VerifiedAt: time.Now(),
+	}
+	vehicle, err := rc.LookupVehicle(plate)
+	assert.Nil(t, err)
+	tx, err := rc.SubmitPendingRideTx(blockchain.RideTx{
+		RiderUUID:       rider,
+		DriverUUID:      driver,
+		PaidAmount:      500, // minimum fare
+		StripeSessionId: "stripe-" + driver + "-" + rider,
+		ComputedRoute:   blockchain.ComputedRoute{Destination: "Broadway, Nashville", DestinationLocation: blockchain.NewLatLng(36.1584, -86.7760)},
+		Vehicle:         blockchain.Vehicle{ID: vehicle.ID},
+		RideTxEvts: []blockchain.RideTxEvt{
+			{EventType: blockchain.RideRequested},
+			{EventType: blockchain.DriverAccepted},
+			{EventType: blockchain.RiderPaymentRecieved},
+		},
+		PickupLocation: blockchain.NewLatLng(36.1627, -86.7816),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+	dropoff := blockchain.NewLatLng(36.1584, -86.7760)
+	start := time.Now().Add(-time.Minute)
+	assert.Nil(t, rc.SubmitTrace(tx.DriverUUID, []blockchain.TracePoint{
+		{Location: tx.PickupLocation, Timestamp: start},
+		{Location: dropoff, Timestamp: start.Add(time.Minute)},
+	}))
+	assert.Nil(t, rc.SubmitDropoff(tx, dropoff))
+	txID, err := rc.ApproveRideTx(tx, validator)
+	assert.Nil(t, err)
+	return txID
+}
+
+// fileVehicle registers and confirms the driver's vehicle, the records ride along in the next block
+func fileVehicle(t *testing.T, rc *blockchain.RideChain, driver, plate string) {
+	t.Helper()
+	vehicle, err := rc.RegisterVehicle(driver, "VIN-"+plate, blockchain.Vehicle{Plate: plate, Seats: 4})
+	assert.Nil(t, err)
+	assert.Nil(t, rc.ConfirmVehicle(vehicle.ID, driver, validator))
+	vehicle.InsuredUntil = time.Now().Add(24 * time.Hour)
+}
+
+func newExplorer(t *testing.T) (*Explorer, *blockchain.RideChain) {
+	t.Helper()
+	rc, err := blockchain.NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(validator))
+	fileVehicle(t, rc, "driver-1", "TN-ABC123")
+	fileVehicle(t, rc, "driver-2", "TN-XYZ789")
+	_, key, err := blockchain.GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SetSigner(validator, key))
+
+	commitRide(t, rc, "driver-1", "rider-a", "TN-ABC123")
+	commitRide(t, rc, "driver-2", "rider-b", "TN-XYZ789")
+	commitRide(t, rc, "driver-1", "rider-b", "TN-ABC123")
+	return New(rc), rc
+}
+
+func TestExplorer_Blocks(t *testing.T) {
+	ex, rc := newExplorer(t)
+
+	assert.Len(t, ex.Blocks(0, -1), 4)
+	assert.Len(t, ex.Blocks(1, 2), 2)
+
+	head, err := ex.Block("3")
+	assert.Nil(t, err)
+	assert.Equal(t, rc.Chain.Head, head.Hash)
+
+	byHash, err := ex.Block(head.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, head, byHash)
+
+	_, err = ex.Block("42")
+	assert.NotNil(t, err)
+
+	var out bytes.Buffer
+	assert.Nil(t, WriteBlock(&out, head))
+	assert.Contains(t, out.String(), "proposer  "+validator)
+	assert.Contains(t, out.String(), "TN-ABC123")
+}
+
+func TestExplorer_SearchRides(t *testing.T) {
+	ex, _ := newExplorer(t)
+
+	tests := []struct {
+		name   string
+		filter RideFilter
+		want   int
+	}{
+		{name: "every ride", filter: RideFilter{}, want: 3},
+		{name: "by rider", filter: RideFilter{Rider: "rider-b"}, want: 2},
+		{name: "by driver", filter: RideFilter{Driver: "driver-2"}, want: 1},
+		{name: "by plate ignoring case", filter: RideFilter{Plate: "tn-abc123"}, want: 2},
+		{name: "by rider and driver", filter: RideFilter{Rider: "rider-b", Driver: "driver-1"}, want: 1},
+		{name: "no match", filter: RideFilter{Plate: "TN-000000"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := ex.SearchRides(tt.filter)
+			assert.Nil(t, err)
+			assert.Len(t, matches, tt.want)
+		})
+	}
+}
+
+func TestExplorer_ValidatorHistory(t *testing.T) {
+	ex, _ := newExplorer(t)
+
+	history, err := ex.ValidatorHistory(validator)
+	assert.Nil(t, err)
+	assert.Len(t, history, 3)
+	for _, a := range history {
+		assert.True(t, a.Proposed)
+		assert.True(t, a.Approved)
+		assert.Equal(t, 1, a.Rides)
+	}
+
+	history, err = ex.ValidatorHistory("driver-1")
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+}
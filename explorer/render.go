@@ -0,0 +1,113 @@
+package explorer
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+// WriteBlocks prints one line per block
+func WriteBlocks(w io.Writer, blocks []*blockchain.Block) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HEIGHT\tHASH\tTIME\tPROPOSER\tVALIDATORS\tRIDES")
+	for _, b := range blocks {
+		txs, err := b.RideTxs()
+		if err != nil {
+			return fmt.Errorf("decode block %s: %w", b.Hash, err)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%d\n", b.Height, short(b.Hash), b.Timestamp.UTC().Format(time.RFC3339), orNone(b.Proposer), len(b.Validators), len(txs))
+	}
+	return tw.Flush()
+}
+
+// WriteBlock prints a block's header, validator set and decoded rides
+func WriteBlock(w io.Writer, b *blockchain.Block) error {
+	txs, err := b.RideTxs()
+	if err != nil {
+		return fmt.Errorf("decode block %s: %w", b.Hash, err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "height\t%d\n", b.Height)
+	fmt.Fprintf(tw, "hash\t%s\n", b.Hash)
+	fmt.Fprintf(tw, "prev\t%s\n", orNone(b.PrevBlockHash))
+	fmt.Fprintf(tw, "time\t%s\n", b.Timestamp.UTC().Format(time.RFC3339))
+	fmt.Fprintf(tw, "tx root\t%s\n", orNone(b.TxRoot))
+	fmt.Fprintf(tw, "proposer\t%s\n", orNone(b.Proposer))
+	fmt.Fprintf(tw, "signed\t%t\n", len(b.Signature) > 0)
+	fmt.Fprintf(tw, "stake\t%d\n", b.ReportedStake())
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nvalidators")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, v := range b.Validators {
+		fmt.Fprintf(tw, "  %s\tstake %d\n", v.UUID, v.Stake)
+	}
+	for _, u := range b.ValidatorUpdates {
+		change := "joined"
+		if u.Removed {
+			change = "left"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", u.UUID, change)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nrides")
+	return writeRideTable(w, rideMatches(b, txs))
+}
+
+// WriteRides prints one line per ride
+func WriteRides(w io.Writer, matches []RideMatch) error {
+	return writeRideTable(w, matches)
+}
+
+// WriteValidatorHistory prints the blocks a validator approved or proposed
+func WriteValidatorHistory(w io.Writer, validatorUUID string, history []Approval) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "validator %s: %d blocks\n", validatorUUID, len(history))
+	fmt.Fprintln(tw, "HEIGHT\tHASH\tPROPOSED\tAPPROVED\tSTAKE\tRIDES")
+	for _, a := range history {
+		fmt.Fprintf(tw, "%d\t%s\t%t\t%t\t%d\t%d\n", a.Height, short(a.BlockHash), a.Proposed, a.Approved, a.Stake, a.Rides)
+	}
+	return tw.Flush()
+}
+
+func writeRideTable(w io.Writer, matches []RideMatch) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HEIGHT\tTXID\tRIDER\tDRIVER\tPLATE\tPAID\tDESTINATION\tEVENTS")
+	for _, m := range matches {
+		tx := m.Tx
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%s\t%d\n", m.Height, short(tx.TxID), tx.RiderUUID, tx.DriverUUID, orNone(tx.Vehicle.Plate), tx.PaidAmount, tx.ComputedRoute.Destination, len(tx.RideTxEvts))
+	}
+	return tw.Flush()
+}
+
+func rideMatches(b *blockchain.Block, txs []blockchain.RideTx) []RideMatch {
+	matches := make([]RideMatch, len(txs))
+	for i, tx := range txs {
+		matches[i] = RideMatch{Tx: tx, BlockHash: b.Hash, Height: b.Height}
+	}
+	return matches
+}
+
+// short trims hashes so tables fit a terminal
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return orNone(hash)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
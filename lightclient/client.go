@@ -0,0 +1,158 @@
+// Package lightclient lets a rider's phone confirm its own rides were committed
+// without running a full node. It only keeps block headers and the validator set.
+package lightclient
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+var (
+	// ErrUnknownBlock is returned when a proof references a header the client has not verified
+	ErrUnknownBlock = errors.New("block header not verified by light client")
+	// ErrMissingEvent is returned when a proven ride is missing a lifecycle event
+	ErrMissingEvent = errors.New("ride missing required event")
+	// ErrRideExpired is returned when the proven ride was expired by the sweeper instead of approved
+	ErrRideExpired = errors.New("ride expired before approval")
+)
+
+// HeaderSource is a full node the light client fetches headers from
+type HeaderSource interface {
+	Headers(fromHeight, max int) ([]blockchain.BlockHeader, error)
+}
+
+// Client tracks the header chain and validator set it has verified so far
+type Client struct {
+	mu         sync.RWMutex
+	head       blockchain.BlockHeader
+	headers    map[string]blockchain.BlockHeader // hash -> header
+	validators map[string]ed25519.PublicKey      // validatorUUID -> key
+	batch      int
+}
+
+// New starts a light client from a trusted header, usually genesis,
+// and the validator set trusted at that header
+func New(trusted blockchain.BlockHeader, validators map[string]ed25519.PublicKey) *Client {
+	c := &Client{
+		head:       trusted,
+		headers:    map[string]blockchain.BlockHeader{trusted.Hash: trusted},
+		validators: make(map[string]ed25519.PublicKey),
+		batch:      64,
+	}
+	for uuid, key := range validators {
+		c.validators[uuid] = key
+	}
+	return c
+}
+
+// Head returns the latest verified header
+func (c *Client) Head() blockchain.BlockHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.head
+}
+
+// Validators returns the current validator set
+func (c *Client) Validators() map[string]ed25519.PublicKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	validators := make(map[string]ed25519.PublicKey, len(c.validators))
+	for uuid, key := range c.validators {
+		validators[uuid] = key
+	}
+	return validators
+}
+
+// AddHeaders verifies headers extending the current head and applies their validator set changes
+func (c *Client) AddHeaders(headers []blockchain.BlockHeader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, h := range headers {
+		if err := c.verify(h); err != nil {
+			return err
+		}
+		c.headers[h.Hash] = h
+		c.head = h
+		c.applyValidatorUpdates(h.ValidatorUpdates)
+	}
+	return nil
+}
+
+func (c *Client) verify(h blockchain.BlockHeader) error {
+	if h.PrevBlockHash != c.head.Hash || h.Height != c.head.Height+1 {
+		return fmt.Errorf("header %s at height %d does not extend head %s", h.Hash, h.Height, c.head.Hash)
+	}
+	key, ok := c.validators[h.Proposer]
+	if !ok {
+		return fmt.Errorf("header %s proposed by %q who is not in the validator set", h.Hash, h.Proposer)
+	}
+	if !h.VerifySignature(key) {
+		return fmt.Errorf("invalid proposer signature on header %s", h.Hash)
+	}
+	return nil
+}
+
+func (c *Client) applyValidatorUpdates(updates []blockchain.ValidatorUpdate) {
+	for _, u := range updates {
+		if u.Removed {
+			delete(c.validators, u.UUID)
+			continue
+		}
+		if len(u.PubKey) == ed25519.PublicKeySize {
+			c.validators[u.UUID] = u.PubKey
+		}
+	}
+}
+
+// Sync pulls every header the source has beyond the current head
+func (c *Client) Sync(source HeaderSource) error {
+	for {
+		headers, err := source.Headers(c.Head().Height+1, c.batch)
+		if err != nil {
+			return err
+		}
+		if err := c.AddHeaders(headers); err != nil {
+			return err
+		}
+		if len(headers) < c.batch {
+			return nil
+		}
+	}
+}
+
+// VerifyRide checks that the proven RideTx was committed in a verified block
+// and that the rider was charged and dropped off before it expired
+func (c *Client) VerifyRide(proof blockchain.RideTxProof) error {
+	c.mu.RLock()
+	header, ok := c.headers[proof.BlockHash]
+	c.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownBlock, proof.BlockHash)
+	}
+	if err := proof.Verify(header.TxRoot); err != nil {
+		return err
+	}
+	for _, required := range []blockchain.RideTxEventType{blockchain.RiderPaymentRecieved, blockchain.DropoffConfirmed} {
+		if !hasEvent(proof.Tx, required) {
+			return fmt.Errorf("%w: %s", ErrMissingEvent, required)
+		}
+	}
+	if hasEvent(proof.Tx, blockchain.Expired) {
+		return ErrRideExpired
+	}
+	return nil
+}
+
+func hasEvent(tx blockchain.RideTx, eventType blockchain.RideTxEventType) bool {
+	for _, evt := range tx.RideTxEvts {
+		if evt.EventType == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package lightclient
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/x-MrPhillips-x/blockshare/api"
+	"github.com/x-MrPhillips-x/blockshare/blockchain"
+)
+
+const genesisValidator = "genesis-123"
+
+func newNode(t *testing.T) (*blockchain.RideChain, ed25519.PublicKey) {
+	t.Helper()
+	rc, err := blockchain.NewRideChain(filepath.Join(t.TempDir(), "token_ledger.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, rc.BecomeValidator(genesisValidator))
+	rc.PendingVerifications[genesisValidator] = blockchain.DriverVerificationRequest{
+		DriverUUID: genesisValidator,
+		Status:     blockchain.VerificationApproved,
+		VerifiedAt: time.Now(),
+	}
+	// another validator vouches for the genesis validator's vehicle, the records ride along in the first block
+	rc.TokenLedger.Mint("validator-2", 10)
+	assert.Nil(t, rc.StakeTokens(10, "validator-2"))
+	assert.Nil(t, rc.BecomeValidator("validator-2"))
+	vehicle, err := rc.RegisterVehicle(genesisValidator, "1HGCM82633A004352", blockchain.Vehicle{Plate: "TN-GEN123", Seats: 4})
+	assert.Nil(t, err)
+	assert.Nil(t, rc.ConfirmVehicle(vehicle.ID, genesisValidator, "validator-2"))
+	assert.Nil(t, rc.InsureVehicle(vehicle.ID, "validator-2", "policy-gen", time.Now().Add(24*time.Hour)))
+	pub, key, err := blockchain.GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SetSigner(genesisValidator, key))
+	return rc, pub
+}
+
+func completeRide(t *testing.T, rc *blockchain.RideChain, rider string) string {
+	t.Helper()
+	tx, err := rc.SubmitPendingRideTx(blockchain.RideTx{
+		RiderUUID:       rider,
+		DriverUUID:      genesisValidator,
+		PaidAmount:      500, // minimum fare
+		StripeSessionId: "stripe-" + rider,
+		ComputedRoute:   blockchain.ComputedRoute{Destination: "Broadway, Nashville", DestinationLocation: blockchain.NewLatLng(36.1584, -86.7760)},
+		RideTxEvts: []blockchain.RideTxEvt{
+			{EventType: blockchain.RideRequested},
+			{EventType: blockchain.DriverAccepted},
+			{EventType: blockchain.RiderPaymentRecieved},
+		},
+		PickupLocation: blockchain.NewLatLng(36.1627, -86.7816),
+		Vehicle:        blockchain.Vehicle{ID: blockchain.VehicleID("1HGCM82633A004352")},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, rc.SubmitPickupProof(tx, tx.PickupCode, tx.PickupLocation))
+	dropoff := blockchain.NewLatLng(36.1584, -86.7760)
+	start := time.Now().Add(-time.Minute)
+	assert.Nil(t, rc.SubmitTrace(tx.DriverUUID, []blockchain.TracePoint{
+		{Location: tx.PickupLocation, Timestamp: start},
+		{Location: dropoff, Timestamp: start.Add(time.Minute)},
+	}))
+	assert.Nil(t, rc.SubmitDropoff(tx, dropoff))
+	txID, err := rc.ApproveRideTx(tx, genesisValidator)
+	assert.Nil(t, err)
+	return txID
+}
+
+func TestClient_VerifyRide(t *testing.T) {
+	rc, pub := newNode(t)
+	completeRide(t, rc, "rider-1")
+	txID := completeRide(t, rc, "rider-2")
+	completeRide(t, rc, "rider-3")
+
+	server := httptest.NewServer(api.NewServer(rc))
+	defer server.Close()
+	node := api.NewClient(server.URL)
+
+	client := New(blockchain.NewGenesisBlock().Header(), map[string]ed25519.PublicKey{genesisValidator: pub})
+	assert.Nil(t, client.Sync(node))
+	assert.Equal(t, rc.Chain.Head, client.Head().Hash)
+
+	proof, err := node.RideTxProof(txID)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name      string
+		proof     func() blockchain.RideTxProof
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name:  "ride committed with payment and dropoff",
+			proof: func() blockchain.RideTxProof { return proof },
+		},
+		{
+			name: "proof for a block the client never verified",
+			proof: func() blockchain.RideTxProof {
+				p := proof
+				p.BlockHash = "unknown"
+				return p
+			},
+			wantErr:   true,
+			wantErrIs: ErrUnknownBlock,
+		},
+		{
+			name: "tampered ride does not match the header tx root",
+			proof: func() blockchain.RideTxProof {
+				p := proof
+				p.Tx.PaidAmount = 1
+				return p
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.VerifyRide(tt.proof())
+			if !tt.wantErr {
+				assert.Nil(t, err)
+				return
+			}
+			assert.NotNil(t, err)
+			if tt.wantErrIs != nil {
+				assert.True(t, errors.Is(err, tt.wantErrIs))
+			}
+		})
+	}
+}
+
+func TestClient_AddHeaders_ValidatorSetChanges(t *testing.T) {
+	rc, pub := newNode(t)
+	completeRide(t, rc, "rider-1")
+
+	// a second validator joins and takes over block production
+	newPub, newKey, err := blockchain.GenerateKeyPair()
+	assert.Nil(t, err)
+	assert.Nil(t, rc.RegisterPublicKey("driver-456", newPub))
+	rc.TokenLedger.Mint("driver-456", 10)
+	assert.Nil(t, rc.StakeTokens(10, "driver-456"))
+	assert.Nil(t, rc.BecomeValidator("driver-456"))
+	completeRide(t, rc, "rider-2")
+
+	client := New(blockchain.NewGenesisBlock().Header(), map[string]ed25519.PublicKey{genesisValidator: pub})
+	assert.Nil(t, client.AddHeaders(rc.Headers(1, 10)))
+	assert.Contains(t, client.Validators(), "driver-456")
+
+	assert.Nil(t, rc.SetSigner("driver-456", newKey))
+	completeRide(t, rc, "rider-3")
+	assert.Nil(t, client.AddHeaders(rc.Headers(client.Head().Height+1, 10)))
+	assert.Equal(t, rc.Chain.Head, client.Head().Hash)
+
+	// a header from a proposer outside the validator set is rejected
+	untrusted := New(blockchain.NewGenesisBlock().Header(), map[string]ed25519.PublicKey{"driver-456": newPub})
+	assert.NotNil(t, untrusted.AddHeaders(rc.Headers(1, 10)))
+}